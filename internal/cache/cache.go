@@ -57,11 +57,14 @@ func Set(key string, value interface{}, duration time.Duration) (*mem.Item, erro
 		return nil, err
 	}
 
-	if duration == 0 {
-		err := cache.Put(key, result)
-		if err != nil {
-			return nil, err
-		}
+	// El store descarta la entrada al vencer
+	if duration > 0 {
+		err = cache.PutWithTTL(key, result, duration)
+	} else {
+		err = cache.Put(key, result)
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	return result, nil
@@ -12,6 +12,8 @@ import (
 
 var series *dbs.Model
 
+const maxSerieRetries = 16
+
 /**
 * initSeries: Initializes the series model
 * @param db *DB
@@ -132,17 +134,25 @@ func GetSerie(tag string) (et.Json, error) {
 		return nil, err
 	}
 
-	items, err := series.
-		Update(et.Json{}).
-		BeforeUpdateFn(func(tx *dbs.Tx, old, new et.Json) error {
-			value := old.Int("value")
-			new["value"] = value + 1
-			return nil
-		}).
-		Where(dbs.Eq("tag", tag)).
-		Execute(nil)
-	if err != nil {
-		return et.Json{}, err
+	// Si otro llamador incrementó la serie primero se reintenta con el valor nuevo
+	var items []et.Json
+	for attempt := 0; ; attempt++ {
+		items, err = series.
+			Update(et.Json{}).
+			BeforeUpdateFn(func(tx *dbs.Tx, old, new et.Json) error {
+				value := old.Int("value")
+				new["value"] = value + 1
+				return nil
+			}).
+			Where(dbs.Eq("tag", tag)).
+			Execute(nil)
+		if dbs.IsConflict(err) && attempt < maxSerieRetries {
+			continue
+		}
+		if err != nil {
+			return et.Json{}, err
+		}
+		break
 	}
 
 	if len(items) != 1 {
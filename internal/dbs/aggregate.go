@@ -0,0 +1,311 @@
+package dbs
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/cgalvisleon/et/et"
+	"github.com/cgalvisleon/et/strs"
+)
+
+/**
+* Aggregate: Aggregation of a field or nested path over the objects of a group, As is the name in the result
+**/
+type Aggregate struct {
+	Type  TypeAggregation `json:"type"`
+	Field string          `json:"field"`
+	As    string          `json:"as"`
+}
+
+/**
+* fieldName: Returns the name of a field or of the last field of a nested path
+* @param path string
+* @return string
+**/
+func fieldName(path string) string {
+	fields := strs.Split(path, ">")
+	return fields[len(fields)-1]
+}
+
+/**
+* newAggregate
+* @param tp TypeAggregation, field string
+* @return *Aggregate
+**/
+func newAggregate(tp TypeAggregation, field string) *Aggregate {
+	as := fmt.Sprintf("%s_%s", tp, fieldName(field))
+	if field == "*" {
+		as = tp.Str()
+	}
+
+	return &Aggregate{
+		Type:  tp,
+		Field: field,
+		As:    as,
+	}
+}
+
+/**
+* Count: Counts the objects with "*" or the objects with a value in the field
+* @param field string
+* @return *Aggregate
+**/
+func Count(field string) *Aggregate {
+	return newAggregate(TpCount, field)
+}
+
+/**
+* Sum: Sums the numbers of the field
+* @param field string
+* @return *Aggregate
+**/
+func Sum(field string) *Aggregate {
+	return newAggregate(TpSum, field)
+}
+
+/**
+* Avg: Average of the numbers of the field, null without numbers
+* @param field string
+* @return *Aggregate
+**/
+func Avg(field string) *Aggregate {
+	return newAggregate(TpAvg, field)
+}
+
+/**
+* Max: Greatest value of the field, the types are ordered as in the orders of the query
+* @param field string
+* @return *Aggregate
+**/
+func Max(field string) *Aggregate {
+	return newAggregate(TpMax, field)
+}
+
+/**
+* Min: Least value of the field
+* @param field string
+* @return *Aggregate
+**/
+func Min(field string) *Aggregate {
+	return newAggregate(TpMin, field)
+}
+
+/**
+* Alias: Changes the name of the aggregate in the result
+* @param as string
+* @return *Aggregate
+**/
+func (s *Aggregate) Alias(as string) *Aggregate {
+	if as != "" {
+		s.As = as
+	}
+	return s
+}
+
+/**
+* ToJson: Returns the aggregate as {"sum": "amount", "as": "total"}
+* @return et.Json
+**/
+func (s *Aggregate) ToJson() et.Json {
+	return et.Json{
+		s.Type.Str(): s.Field,
+		"as":         s.As,
+	}
+}
+
+/**
+* ToAggregate: Returns the aggregate of the json, nil when it has no aggregation
+* @param json et.Json
+* @return *Aggregate
+**/
+func ToAggregate(json et.Json) *Aggregate {
+	for _, key := range slices.Sorted(maps.Keys(json)) {
+		tp := GetAggregation(strs.Lowcase(key))
+		if tp == TpExp {
+			continue
+		}
+
+		field, ok := json[key].(string)
+		if !ok || field == "" {
+			continue
+		}
+
+		return newAggregate(tp, field).Alias(json.Str("as"))
+	}
+
+	return nil
+}
+
+/**
+* accumulator: Value of an aggregate while the objects are read
+**/
+type accumulator struct {
+	count int
+	sum   float64
+	value any // max o min
+}
+
+/**
+* add: The max and the min compare the values with the type of the field in the model
+* @param model *Model, aggregate *Aggregate, item et.Json
+**/
+func (s *accumulator) add(model *Model, aggregate *Aggregate, item et.Json) {
+	if aggregate.Type == TpCount && aggregate.Field == "*" {
+		s.count++
+		return
+	}
+
+	value, err := fieldValue(item, aggregate.Field)
+	if err != nil || value == nil {
+		return
+	}
+
+	switch aggregate.Type {
+	case TpCount:
+		s.count++
+	case TpSum, TpAvg:
+		num, _, ok := numberToFloat64(value)
+		if !ok {
+			return
+		}
+		s.sum += num
+		s.count++
+	case TpMax:
+		value = model.typedValue(aggregate.Field, value)
+		if s.count == 0 || compareValues(value, s.value) > 0 {
+			s.value = value
+		}
+		s.count++
+	case TpMin:
+		value = model.typedValue(aggregate.Field, value)
+		if s.count == 0 || compareValues(value, s.value) < 0 {
+			s.value = value
+		}
+		s.count++
+	}
+}
+
+/**
+* result
+* @param aggregate *Aggregate
+* @return any
+**/
+func (s *accumulator) result(aggregate *Aggregate) any {
+	switch aggregate.Type {
+	case TpCount:
+		return s.count
+	case TpSum:
+		return s.sum
+	case TpAvg:
+		if s.count == 0 {
+			return nil
+		}
+		return s.sum / float64(s.count)
+	default:
+		return s.value
+	}
+}
+
+/**
+* group: Values of the fields of the group and its aggregates
+**/
+type group struct {
+	values       []any
+	accumulators []*accumulator
+}
+
+/**
+* grouper: Groups the objects while they are read, only the groups are kept in memory
+**/
+type grouper struct {
+	model      *Model
+	fields     []string
+	aggregates []*Aggregate
+	groups     map[string]*group
+	keys       []string // en el orden en que aparecen
+}
+
+/**
+* newGrouper: The values of the fields are grouped with their type in the model
+* @param model *Model, fields []string, aggregates []*Aggregate
+* @return *grouper
+**/
+func newGrouper(model *Model, fields []string, aggregates []*Aggregate) *grouper {
+	return &grouper{
+		model:      model,
+		fields:     fields,
+		aggregates: aggregates,
+		groups:     map[string]*group{},
+		keys:       make([]string, 0),
+	}
+}
+
+/**
+* add
+* @param item et.Json
+**/
+func (s *grouper) add(item et.Json) {
+	values := make([]any, len(s.fields))
+	buf := make([]byte, 0, 16*len(s.fields))
+	for i, field := range s.fields {
+		value, _ := fieldValue(item, field)
+		values[i] = s.model.typedValue(field, value)
+		buf = appendKeyValue(buf, values[i])
+	}
+
+	result := s.group(string(buf), values)
+	for i, aggregate := range s.aggregates {
+		result.accumulators[i].add(s.model, aggregate, item)
+	}
+}
+
+/**
+* group: Returns the group of the key, it is created the first time
+* @param key string, values []any
+* @return *group
+**/
+func (s *grouper) group(key string, values []any) *group {
+	result, ok := s.groups[key]
+	if ok {
+		return result
+	}
+
+	result = &group{
+		values:       values,
+		accumulators: make([]*accumulator, len(s.aggregates)),
+	}
+	for i := range s.aggregates {
+		result.accumulators[i] = &accumulator{}
+	}
+	s.groups[key] = result
+	s.keys = append(s.keys, key)
+
+	return result
+}
+
+/**
+* rows: Returns a row for each group with its fields and aggregates, without fields to group
+* there is a single row even when no object was read
+* @return []et.Json
+**/
+func (s *grouper) rows() []et.Json {
+	if len(s.fields) == 0 {
+		s.group("", []any{})
+	}
+
+	result := make([]et.Json, 0, len(s.keys))
+	for _, key := range s.keys {
+		group := s.groups[key]
+		row := et.Json{}
+		for i, field := range s.fields {
+			row[fieldName(field)] = group.values[i]
+		}
+		for i, aggregate := range s.aggregates {
+			row[aggregate.As] = group.accumulators[i].result(aggregate)
+		}
+		result = append(result, row)
+	}
+
+	return result
+}
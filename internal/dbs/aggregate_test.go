@@ -0,0 +1,122 @@
+package dbs
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cgalvisleon/et/et"
+)
+
+func TestGroupByHavingFromJson(t *testing.T) {
+	db, err := GetDb("aggregate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := db.NewModel("", "sales", false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.DefineAtrib("status", TpText, "")
+	model.DefineIndexes("status")
+	if err := model.DefineEphemeral(); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer model.data.Close()
+
+	// s0 tiene 5 ventas, s1 tiene 3 y s2 tiene 2
+	for i, status := range []string{"s0", "s0", "s1", "s0", "s2", "s1", "s0", "s2", "s1", "s0"} {
+		item := et.Json{"status": status, "amount": i + 1}
+		if err := model.PutObject(fmt.Sprintf("k%02d", i), item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := et.Json{}
+	err = json.Unmarshal([]byte(`{
+		"select": [{"count": "*"}, {"sum": "amount", "as": "total"}],
+		"group_by": ["status"],
+		"having": [{"count": {"eq": 5}}]
+	}`), &query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := ByQuery(query).SetOwner(model).Run(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Str("status") != "s0" || rows[0].Num("total") != 1+2+4+7+10 {
+		t.Fatalf("having count eq 5: %v", rows)
+	}
+
+	rows, err = model.Selects().
+		GroupBy("status").
+		Aggregate(Count("*"), Avg("amount"), Max("amount"), Min("amount")).
+		Having(More("count", 1)).
+		Desc("count").
+		Run(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("groups: %v", rows)
+	}
+	if rows[0].Str("status") != "s0" || rows[2].Str("status") != "s2" {
+		t.Fatalf("groups not ordered by count: %v", rows)
+	}
+	if rows[2].Num("avg_amount") != 6.5 || rows[2].Int("max_amount") != 8 || rows[2].Int("min_amount") != 5 {
+		t.Fatalf("aggregates of s2: %v", rows[2])
+	}
+
+	rows, err = model.Selects().Where(Eq("status", "none")).Aggregate(Count("*"), Sum("amount"), Avg("amount")).Run(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Int("count") != 0 || rows[0]["avg_amount"] != nil {
+		t.Fatalf("aggregates without objects: %v", rows)
+	}
+}
+
+func TestGroupByDateTime(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	paris := time.FixedZone("paris", 60*60)
+	model := eventsModel(t, []time.Time{
+		base,
+		base.In(paris),
+		base.Add(time.Second),
+		base.Add(1500 * time.Millisecond).In(paris),
+	})
+
+	// El mismo instante en dos zonas es un solo grupo
+	rows, err := model.Selects().GroupBy("at").Aggregate(Count("*")).Asc("at").Run(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0].Int("count") != 2 {
+		t.Fatalf("groups by instant: %v", rows)
+	}
+
+	rows, err = model.Selects().GroupBy("at").Aggregate(Count("*")).Having(More("at", base)).Run(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("having on the datetime: %v", rows)
+	}
+
+	// El máximo es el instante más tardío aunque su texto local sea menor
+	rows, err = model.Selects().Aggregate(Max("at"), Min("at")).Run(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, ok := rows[0]["max_at"].(time.Time)
+	if !ok || !latest.Equal(base.Add(1500*time.Millisecond)) {
+		t.Fatalf("max of the datetimes: %v", rows)
+	}
+}
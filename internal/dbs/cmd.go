@@ -401,6 +401,16 @@ func (s *Cmd) executeUpdate(tx *Tx) ([]et.Json, error) {
 			return nil, errorRecordNotFound
 		}
 
+		// Se lee la versión actual, el commit falla si la fila cambia antes
+		current := et.Json{}
+		version, exists, err := model.GetVersion(idx, &current)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			old = current
+		}
+
 		// Update data
 		new := old.Clone()
 		for k, v := range data {
@@ -424,7 +434,7 @@ func (s *Cmd) executeUpdate(tx *Tx) ([]et.Json, error) {
 		}
 
 		// Insert data into indexes
-		tx.addTransaction(model.From, UPDATE, idx, new)
+		tx.addTransactionVersion(model.From, UPDATE, idx, new, version)
 
 		// Run after update triggers
 		for _, trigger := range s.afterTriggerUpdates {
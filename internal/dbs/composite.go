@@ -0,0 +1,288 @@
+package dbs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cgalvisleon/et/et"
+	"github.com/cgalvisleon/josefina/internal/store"
+)
+
+const (
+	tagNull   byte = 0x01
+	tagFalse  byte = 0x02
+	tagTrue   byte = 0x03
+	tagNumber byte = 0x04
+	tagString byte = 0x05
+	tagTime   byte = 0x06
+)
+
+/**
+* compositeName: Returns the name of the composite index of the fields
+* @param fields []string
+* @return string
+**/
+func compositeName(fields []string) string {
+	return strings.Join(fields, ",")
+}
+
+/**
+* appendKeyValue: Appends the value with an encoding that keeps the order of the values of the same type,
+* the strings are escaped and terminated so a value is never a prefix of a greater one, the times are
+* their UnixNano in UTC with a fixed width
+* @param buf []byte, value any
+* @return []byte
+**/
+func appendKeyValue(buf []byte, value any) []byte {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, tagNull)
+	case bool:
+		if v {
+			return append(buf, tagTrue)
+		}
+		return append(buf, tagFalse)
+	case string:
+		return appendKeyString(buf, v)
+	case time.Time:
+		// El signo invertido deja las fechas anteriores a 1970 antes de las demás
+		buf = append(buf, tagTime)
+		return binary.BigEndian.AppendUint64(buf, uint64(v.UTC().UnixNano())^(1<<63))
+	}
+
+	num, _, ok := numberToFloat64(value)
+	if !ok {
+		return appendKeyString(buf, fmt.Sprintf("%v", value))
+	}
+
+	// Los negativos invierten todos los bits, los positivos solo el signo
+	if num == 0 {
+		num = 0 // -0 y 0 son la misma clave
+	}
+	bits := math.Float64bits(num)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+
+	buf = append(buf, tagNumber)
+	return binary.BigEndian.AppendUint64(buf, bits)
+}
+
+/**
+* appendKeyString: 0x00 is escaped as 0x00 0xff and the string ends with 0x00 0x01
+* @param buf []byte, value string
+* @return []byte
+**/
+func appendKeyString(buf []byte, value string) []byte {
+	buf = append(buf, tagString)
+	for i := 0; i < len(value); i++ {
+		if value[i] == 0x00 {
+			buf = append(buf, 0x00, 0xff)
+			continue
+		}
+		buf = append(buf, value[i])
+	}
+
+	return append(buf, 0x00, 0x01)
+}
+
+/**
+* keyFieldValue: Returns the value of the field as it is encoded in a key, the datetime fields are read back
+* from the json of the object as text and are encoded as times, in the other fields a time is its text
+* @param field string, value any
+* @return any
+**/
+func (s *Model) keyFieldValue(field string, value any) any {
+	definition, ok := s.Fields[field]
+	if !ok || definition.TypeData != TpDateTime {
+		if v, ok := value.(time.Time); ok {
+			// Igual que en el json del objeto guardado
+			return v.Format(time.RFC3339Nano)
+		}
+		return value
+	}
+
+	return s.typedValue(field, value)
+}
+
+/**
+* compositeKey: Returns the key of the object in the composite index, the missing fields are null
+* @param object et.Json, fields []string
+* @return string
+**/
+func (s *Model) compositeKey(object et.Json, fields []string) string {
+	buf := make([]byte, 0, 16*len(fields))
+	for _, field := range fields {
+		buf = appendKeyValue(buf, s.keyFieldValue(field, object[field]))
+	}
+
+	return string(buf)
+}
+
+/**
+* keyValue: Returns the value of the condition when it can be encoded in a key
+* @param value any
+* @return any, bool
+**/
+func keyValue(value any) (any, bool) {
+	switch value.(type) {
+	case nil:
+		return nil, false
+	case string, bool, time.Time:
+		return value, true
+	}
+
+	_, _, ok := numberToFloat64(value)
+	return value, ok
+}
+
+/**
+* keyRange: Range [start, end) of the keys of a composite index, the first fields are fixed by equalities
+**/
+type keyRange struct {
+	index  string
+	start  string
+	end    string
+	equals int // campos fijados por igualdades
+}
+
+/**
+* compositeRange: Returns the composite index and its key range for the equalities of a prefix
+* of the fields followed by a range on the next field, the conditions are joined by and
+* @param conditions []*Condition
+* @return *keyRange, bool
+**/
+func (s *Model) compositeRange(conditions []*Condition) (*keyRange, bool) {
+	if len(s.Composites) == 0 {
+		return nil, false
+	}
+
+	// Solo cuenta la primera condición de cada campo
+	byField := map[string]*Condition{}
+	for _, con := range conditions {
+		if con.IsGroup() || con.Negate {
+			continue
+		}
+		if _, ok := byField[con.Field]; ok {
+			continue
+		}
+		byField[con.Field] = con
+	}
+
+	// En orden de nombre para que un empate elija siempre el mismo índice
+	names := make([]string, 0, len(s.Composites))
+	for name := range s.Composites {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var (
+		result *keyRange
+		best   int
+	)
+	for _, name := range names {
+		prefix := []byte{}
+		score := 0
+		equals := 0
+		ranged := false
+		lower, upper := "", ""
+		for _, field := range s.Composites[name] {
+			con, ok := byField[field]
+			if !ok {
+				break
+			}
+
+			if con.Operator == OpEq || con.Operator == OpIs {
+				value, ok := keyValue(s.keyFieldValue(field, con.Value))
+				if !ok {
+					break
+				}
+				prefix = appendKeyValue(prefix, value)
+				score += 2
+				equals++
+				continue
+			}
+
+			lower, upper, ranged = s.boundRange(prefix, con)
+			if ranged {
+				score++
+			}
+			break
+		}
+
+		if score <= best {
+			continue
+		}
+
+		best = score
+		if !ranged {
+			lower, upper = string(prefix), store.PrefixEnd(string(prefix))
+		}
+		result = &keyRange{index: name, start: lower, end: upper, equals: equals}
+	}
+
+	return result, best > 0
+}
+
+/**
+* boundRange: Returns the key range of a range condition after the prefix
+* @param prefix []byte, con *Condition
+* @return string, string, bool
+**/
+func (s *Model) boundRange(prefix []byte, con *Condition) (string, string, bool) {
+	bound := func(value any) (string, bool) {
+		value, ok := keyValue(s.keyFieldValue(con.Field, value))
+		if !ok {
+			return "", false
+		}
+
+		return string(appendKeyValue(append([]byte{}, prefix...), value)), true
+	}
+
+	start, end := string(prefix), store.PrefixEnd(string(prefix))
+	switch con.Operator {
+	case OpMore:
+		key, ok := bound(con.Value)
+		if !ok {
+			return "", "", false
+		}
+		return store.PrefixEnd(key), end, true
+	case OpMoreEq:
+		key, ok := bound(con.Value)
+		if !ok {
+			return "", "", false
+		}
+		return key, end, true
+	case OpLess:
+		key, ok := bound(con.Value)
+		if !ok {
+			return "", "", false
+		}
+		return start, key, true
+	case OpLessEq:
+		key, ok := bound(con.Value)
+		if !ok {
+			return "", "", false
+		}
+		return start, store.PrefixEnd(key), true
+	case OpBetween:
+		min, max, ok := getBetweenRange(con.Value)
+		if !ok {
+			return "", "", false
+		}
+		lower, okMin := bound(min)
+		upper, okMax := bound(max)
+		if !okMin || !okMax || lower > upper {
+			return "", "", false
+		}
+		return lower, store.PrefixEnd(upper), true
+	default:
+		return "", "", false
+	}
+}
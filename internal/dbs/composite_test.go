@@ -0,0 +1,93 @@
+package dbs
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cgalvisleon/et/et"
+)
+
+func TestKeyValueTimeOrder(t *testing.T) {
+	bogota := time.FixedZone("bogota", -5*60*60)
+	times := []time.Time{
+		time.Date(1969, 12, 31, 23, 59, 59, 0, time.UTC),
+		time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC),
+		time.Date(2024, 1, 1, 0, 0, 1, 500000000, time.UTC),
+		// Más tarde que las anteriores aunque su texto local sea menor
+		time.Date(2023, 12, 31, 19, 0, 2, 0, bogota),
+	}
+
+	for i := 1; i < len(times); i++ {
+		a := string(appendKeyValue(nil, times[i-1]))
+		b := string(appendKeyValue(nil, times[i]))
+		if a >= b {
+			t.Fatalf("%v is not encoded before %v", times[i-1], times[i])
+		}
+	}
+
+	same := times[3].UTC()
+	if string(appendKeyValue(nil, same)) != string(appendKeyValue(nil, times[3])) {
+		t.Fatal("the same instant in two zones has two keys")
+	}
+}
+
+func TestCompositeTimeRange(t *testing.T) {
+	db, err := GetDb("composite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := db.NewModel("", "events", false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.DefineAtrib("user", TpText, "")
+	model.DefineAtrib("at", TpDateTime, nil)
+	model.DefineCompositeIndex("user", "at")
+	if err := model.DefineEphemeral(); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer model.data.Close()
+
+	// Los segundos sin fracción acortan el texto RFC3339Nano y lo desordenan como string
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 200; i++ {
+		at := base.Add(time.Duration(i) * 500 * time.Millisecond)
+		if err := model.PutObject(fmt.Sprintf("e%d", i), et.Json{"user": "ana", "at": at}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reescribir un objeto lee su fecha como texto y debe quitar la misma clave
+	if err := model.PutObject("e0", et.Json{"user": "ana", "at": base.Add(-time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	index, err := model.store(compositeName([]string{"user", "at"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := index.Count(); n != 200 {
+		t.Fatalf("stale composite keys: %d keys for 200 objects", n)
+	}
+
+	query := model.Selects().Where(Eq("user", "ana")).And(MoreEq("at", base.Add(95*time.Second)))
+	explain, err := query.Explain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explain.Json("plan").Str("kind") != string(PlanComposite) {
+		t.Fatalf("composite index not used: %v", explain.ToString())
+	}
+
+	rows, err := query.Run(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 10 {
+		t.Fatalf("got %d events from the second 95, expected 10", len(rows))
+	}
+}
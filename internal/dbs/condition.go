@@ -1,12 +1,16 @@
 package dbs
 
 import (
+	"maps"
 	"reflect"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cgalvisleon/et/et"
 	"github.com/cgalvisleon/et/strs"
+	"github.com/cgalvisleon/josefina/internal/store"
 )
 
 type Operator string
@@ -78,42 +82,140 @@ type BetweenValue struct {
 }
 
 type Condition struct {
-	Field     string    `json:"field"`
-	Operator  Operator  `json:"operator"`
-	Value     any       `json:"value"`
-	Connector Connector `json:"connector"`
+	Field      string       `json:"field"`
+	Operator   Operator     `json:"operator"`
+	Value      any          `json:"value"`
+	Connector  Connector    `json:"connector"`
+	Group      Connector    `json:"group"`      // conector de las condiciones del grupo, NaC en las hojas
+	Conditions []*Condition `json:"conditions"` // condiciones del grupo
+	Negate     bool         `json:"negate"`     // niega el resultado
 }
 
 /**
-* ToJson
+* IsGroup
+* @return bool
+**/
+func (s *Condition) IsGroup() bool {
+	return s.Group != NaC
+}
+
+/**
+* expression: Returns the condition without its connector, the groups as {"and": [...]} or {"or": [...]}
 * @return et.Json
 **/
-func (s *Condition) ToJson() et.Json {
-	if s.Connector == NaC {
-		return et.Json{
+func (s *Condition) expression() et.Json {
+	var result et.Json
+	if s.Negate && s.IsGroup() && len(s.Conditions) == 1 {
+		result = s.Conditions[0].expression()
+	} else if s.IsGroup() {
+		items := make([]et.Json, 0, len(s.Conditions))
+		for _, con := range s.Conditions {
+			items = append(items, con.expression())
+		}
+		result = et.Json{
+			s.Group.Str(): items,
+		}
+	} else {
+		result = et.Json{
 			s.Field: et.Json{
 				s.Operator.Str(): s.Value,
 			},
 		}
 	}
 
+	if s.Negate {
+		return et.Json{
+			"not": result,
+		}
+	}
+
+	return result
+}
+
+/**
+* ToJson
+* @return et.Json
+**/
+func (s *Condition) ToJson() et.Json {
+	if s.Connector == NaC {
+		return s.expression()
+	}
+
 	return et.Json{
-		s.Connector.Str(): et.Json{
-			s.Field: et.Json{
-				s.Operator.Str(): s.Value,
-			},
-		},
+		s.Connector.Str(): s.expression(),
 	}
 }
 
+/**
+* Eval: Evaluates the condition and its groups on the data
+* @param data et.Json
+* @return bool
+**/
+func (s *Condition) Eval(data et.Json) bool {
+	result := false
+	switch s.Group {
+	case And:
+		result = true
+		for _, con := range s.Conditions {
+			if !con.Eval(data) {
+				result = false
+				break
+			}
+		}
+	case Or:
+		for _, con := range s.Conditions {
+			if con.Eval(data) {
+				result = true
+				break
+			}
+		}
+	default:
+		result = s.ApplyToData(data)
+	}
+
+	return result != s.Negate
+}
+
+/**
+* resolve: Runs the subqueries of the condition and its groups, their rows are the value
+* @param tx *Tx
+* @return error
+**/
+func (s *Condition) resolve(tx *Tx) error {
+	for _, con := range s.Conditions {
+		if err := con.resolve(tx); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	switch v := s.Value.(type) {
+	case *Wheres:
+		s.Value, err = v.Run(tx)
+	case Wheres:
+		s.Value, err = v.Run(tx)
+	}
+
+	return err
+}
+
 /**
 * fieldValue
 * @param data et.Json
 * @return any, error
 **/
 func (s *Condition) fieldValue(data et.Json) (any, error) {
+	return fieldValue(data, s.Field)
+}
+
+/**
+* fieldValue: Returns the value of a field or of a nested path as "a>b>0>c"
+* @param data et.Json, path string
+* @return any, error
+**/
+func fieldValue(data et.Json, path string) (any, error) {
 	array := []et.Json{}
-	fields := strs.Split(s.Field, ">")
+	fields := strs.Split(path, ">")
 	for _, field := range fields {
 		idx, err := strconv.Atoi(field)
 		if err == nil && len(array) > idx {
@@ -147,7 +249,7 @@ func (s *Condition) fieldValue(data et.Json) (any, error) {
 }
 
 /**
-* applyOpEq
+* applyOpEq: The numbers are compared by value whatever their type
 * @param val any
 * @return bool
 **/
@@ -156,16 +258,21 @@ func (s *Condition) applyOpEq(val any) bool {
 		return false
 	}
 
+	equals := func(value any) bool {
+		ok, err := equalsAny(val, value)
+		return err == nil && ok
+	}
+
 	switch bv := s.Value.(type) {
 	case []et.Json:
 		for _, item := range bv {
 			for _, value := range item {
-				return val == value
+				return equals(value)
 			}
 		}
 		return false
 	default:
-		return val == bv
+		return equals(bv)
 	}
 }
 
@@ -199,7 +306,7 @@ func (s *Condition) applyOpLess(val any) bool {
 
 	switch bv := s.Value.(type) {
 	case time.Time:
-		if av, ok := val.(time.Time); ok {
+		if av, ok := timeValue(val); ok {
 			return av.Before(bv)
 		}
 		return invalidType()
@@ -268,7 +375,7 @@ func (s *Condition) applyOpLessEq(val any) bool {
 
 	switch bv := s.Value.(type) {
 	case time.Time:
-		if av, ok := val.(time.Time); ok {
+		if av, ok := timeValue(val); ok {
 			return av.Before(bv) || av.Equal(bv)
 		}
 		return invalidType()
@@ -337,7 +444,7 @@ func (s *Condition) applyOpMore(val any) bool {
 
 	switch bv := s.Value.(type) {
 	case time.Time:
-		if av, ok := val.(time.Time); ok {
+		if av, ok := timeValue(val); ok {
 			return av.After(bv)
 		}
 		return invalidType()
@@ -406,7 +513,7 @@ func (s *Condition) applyOpMoreEq(val any) bool {
 
 	switch bv := s.Value.(type) {
 	case time.Time:
-		if av, ok := val.(time.Time); ok {
+		if av, ok := timeValue(val); ok {
 			return av.After(bv) || av.Equal(bv)
 		}
 		return invalidType()
@@ -717,53 +824,209 @@ func (s *Condition) ApplyToIndex(keys []string) []string {
 }
 
 /**
-* ToCondition
+* IndexRange: Returns the key range [start, end) of an index that can hold the matches. Only the string values
+* seek the index, its keys are the values formatted as text so the numbers are not in numeric order ("10" < "9")
+* and their ranges are resolved reading the objects
+* @return string, string, bool
+**/
+func (s *Condition) IndexRange() (string, string, bool) {
+	switch s.Operator {
+	case OpEq, OpIs, OpMore, OpMoreEq, OpLess, OpLessEq, OpLike:
+		value, ok := s.Value.(string)
+		if !ok || value == "" {
+			return "", "", false
+		}
+
+		switch s.Operator {
+		case OpMore:
+			return value + "\x00", "", true
+		case OpMoreEq:
+			return value, "", true
+		case OpLess:
+			return "", value, true
+		case OpLessEq:
+			return "", value + "\x00", true
+		case OpLike:
+			if strings.HasPrefix(value, "*") || !strings.HasSuffix(value, "*") {
+				return "", "", false
+			}
+			prefix := strings.TrimRight(value, "*")
+			return prefix, store.PrefixEnd(prefix), true
+		default:
+			return value, value + "\x00", true
+		}
+	case OpBetween:
+		min, max, ok := getBetweenRange(s.Value)
+		if !ok {
+			return "", "", false
+		}
+
+		minStr, okMin := min.(string)
+		maxStr, okMax := max.(string)
+		if !okMin || !okMax || minStr > maxStr {
+			return "", "", false
+		}
+
+		return minStr, maxStr + "\x00", true
+	default:
+		return "", "", false
+	}
+}
+
+/**
+* jsonList: Returns the objects of a json array
+* @param value any
+* @return []et.Json, bool
+**/
+func jsonList(value any) ([]et.Json, bool) {
+	switch v := value.(type) {
+	case []et.Json:
+		return v, true
+	case []map[string]interface{}:
+		result := make([]et.Json, 0, len(v))
+		for _, item := range v {
+			result = append(result, item)
+		}
+		return result, true
+	case []interface{}:
+		result := make([]et.Json, 0, len(v))
+		for _, item := range v {
+			switch obj := item.(type) {
+			case et.Json:
+				result = append(result, obj)
+			case map[string]interface{}:
+				result = append(result, obj)
+			default:
+				return nil, false
+			}
+		}
+		return result, true
+	default:
+		return nil, false
+	}
+}
+
+/**
+* toConditions: Returns the conditions of the objects of a json array
+* @param items []et.Json
+* @return []*Condition
+**/
+func toConditions(items []et.Json) []*Condition {
+	result := make([]*Condition, 0, len(items))
+	for _, item := range items {
+		con := ToCondition(item)
+		if con != nil {
+			result = append(result, con)
+		}
+	}
+
+	return result
+}
+
+/**
+* ToCondition: Returns the condition of the json, {"and": [...]} and {"or": [...]} are groups, {"not": ...} negates
+* and the other keys of an object are joined by and, {"and": {...}} joins the condition by and and {"or": {...}}
+* by or to the rest of the object, alone they take the connector to join the previous condition of a list
 * @param json et.Json
 * @return *Condition
 **/
 func ToCondition(json et.Json) *Condition {
-	getWhere := func(json et.Json) *Condition {
-		for fld := range json {
-			cond := json.Json(fld)
-			for cnd := range cond {
-				val := cond[cnd]
-				return condition(fld, val, ToOperator(cnd))
+	result := []*Condition{}
+	alternatives := []*Condition{}
+	for _, key := range slices.Sorted(maps.Keys(json)) {
+		switch strs.Lowcase(key) {
+		case "and", "or":
+			connector := And
+			if strs.Lowcase(key) == "or" {
+				connector = Or
+			}
+
+			if items, ok := jsonList(json[key]); ok {
+				result = append(result, Group(connector, toConditions(items)...))
+				continue
+			}
+
+			con := ToCondition(json.Json(key))
+			if con == nil {
+				continue
+			}
+
+			con.Connector = connector
+			if connector == Or {
+				// Va al final para unirse por or a todas las demás condiciones del objeto
+				alternatives = append(alternatives, con)
+			} else {
+				result = append(result, con)
+			}
+		case "not":
+			var con *Condition
+			if items, ok := jsonList(json[key]); ok {
+				con = Group(And, toConditions(items)...)
+			} else {
+				con = ToCondition(json.Json(key))
+			}
+			if con != nil {
+				result = append(result, Not(con))
+			}
+		default:
+			cond := json.Json(key)
+			for _, op := range slices.Sorted(maps.Keys(cond)) {
+				result = append(result, condition(key, cond[op], ToOperator(op)))
 			}
 		}
+	}
+
+	result = append(result, alternatives...)
+	switch len(result) {
+	case 0:
 		return nil
+	case 1:
+		return result[0]
+	default:
+		return Group(And, result...)
 	}
+}
 
-	and := func(jsons et.Json) *Condition {
-		result := getWhere(jsons)
-		if result != nil {
-			result.Connector = And
-		}
+/**
+* Group: Joins the conditions with the connector, in an and group the conditions with the or connector
+* start a new term and and takes precedence over or
+* @param connector Connector, conditions ...*Condition
+* @return *Condition
+**/
+func Group(connector Connector, conditions ...*Condition) *Condition {
+	if connector != Or {
+		connector = And
+	}
 
-		return result
+	if len(conditions) == 1 {
+		return conditions[0]
 	}
 
-	or := func(jsons et.Json) *Condition {
-		result := getWhere(jsons)
-		if result != nil {
-			result.Connector = Or
+	if connector == And {
+		for i, con := range conditions {
+			if i > 0 && con.Connector == Or {
+				return conditionTree(conditions)
+			}
 		}
-
-		return result
 	}
 
-	for k := range json {
-		if strs.Lowcase(k) == "and" {
-			def := json.Json(k)
-			return and(def)
-		} else if strs.Lowcase(k) == "or" {
-			def := json.Json(k)
-			return or(def)
-		} else {
-			return getWhere(json)
-		}
+	return &Condition{
+		Group:      connector,
+		Conditions: conditions,
 	}
+}
 
-	return nil
+/**
+* Not: Negates the condition
+* @param condition *Condition
+* @return *Condition
+**/
+func Not(condition *Condition) *Condition {
+	return &Condition{
+		Group:      And,
+		Conditions: []*Condition{condition},
+		Negate:     true,
+	}
 }
 
 /**
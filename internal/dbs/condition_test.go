@@ -0,0 +1,139 @@
+package dbs
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/cgalvisleon/et/et"
+)
+
+/**
+* parseCondition
+* @param t *testing.T, src string
+* @return *Condition
+**/
+func parseCondition(t *testing.T, src string) *Condition {
+	t.Helper()
+	data := et.Json{}
+	if err := json.Unmarshal([]byte(src), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	return ToCondition(data)
+}
+
+func TestToConditionConnectors(t *testing.T) {
+	active := et.Json{"status": "active", "n": 1}
+	archived := et.Json{"status": "archived", "n": 2}
+	other := et.Json{"status": "archived", "n": 3}
+
+	cases := []struct {
+		src    string
+		expect []bool // active, archived, other
+	}{
+		// El or une la condición al resto del objeto
+		{`{"status": {"eq": "active"}, "or": {"n": {"eq": 2}}}`, []bool{true, true, false}},
+		// and tiene precedencia sobre or sin importar el orden de las claves
+		{`{"or": {"n": {"eq": 3}}, "status": {"eq": "archived"}, "n": {"eq": 2}}`, []bool{false, true, true}},
+		{`{"status": {"eq": "archived"}, "and": {"n": {"eq": 3}}}`, []bool{false, false, true}},
+		// En una lista los conectores de los elementos se respetan con la misma precedencia
+		{`{"and": [{"n": {"eq": 1}}, {"or": {"n": {"eq": 2}}}, {"and": {"status": {"eq": "archived"}}}]}`, []bool{true, true, false}},
+		{`{"not": {"status": {"eq": "active"}, "or": {"n": {"eq": 1}}}}`, []bool{false, true, true}},
+	}
+
+	for _, c := range cases {
+		con := parseCondition(t, c.src)
+		for i, data := range []et.Json{active, archived, other} {
+			if got := con.Eval(data); got != c.expect[i] {
+				t.Fatalf("%s on %v: got %v, expected %v (%v)", c.src, data, got, c.expect[i], con.ToJson())
+			}
+		}
+
+		// La forma serializada se evalúa igual
+		again := parseCondition(t, con.ToJson().ToString())
+		for i, data := range []et.Json{active, archived, other} {
+			if got := again.Eval(data); got != c.expect[i] {
+				t.Fatalf("%s serialized as %v: got %v on %v", c.src, con.ToJson(), got, data)
+			}
+		}
+	}
+
+	// Solo, el or es el conector con la condición anterior de la lista
+	rows := ByJson([]et.Json{{"status": et.Json{"eq": "active"}}, {"or": et.Json{"n": et.Json{"eq": 3}}}})
+	root := rows.tree()
+	for i, data := range []et.Json{active, archived, other} {
+		if got := root.Eval(data); got != []bool{true, false, true}[i] {
+			t.Fatalf("list with or on %v: got %v", data, got)
+		}
+	}
+}
+
+func TestIndexRange(t *testing.T) {
+	cases := []struct {
+		con        *Condition
+		start, end string
+		ok         bool
+	}{
+		{Eq("s", "b"), "b", "b\x00", true},
+		{More("s", "b"), "b\x00", "", true},
+		{MoreEq("s", "b"), "b", "", true},
+		{Less("s", "b"), "", "b", true},
+		{LessEq("s", "b"), "", "b\x00", true},
+		{Like("s", "ab*"), "ab", "ac", true},
+		{Like("s", "a\xff*"), "a\xff", "b", true},
+		{Like("s", "*ab"), "", "", false},
+		{Between("s", "a", "c"), "a", "c\x00", true},
+		{Between("s", "c", "a"), "", "", false},
+		// Las claves son texto, los números no se buscan por rango en el índice
+		{Eq("n", 9), "", "", false},
+		{More("n", 9), "", "", false},
+		{Between("n", 1, 20), "", "", false},
+	}
+
+	for _, c := range cases {
+		start, end, ok := c.con.IndexRange()
+		if start != c.start || end != c.end || ok != c.ok {
+			t.Fatalf("%v: [%q, %q) %v", c.con.ToJson(), start, end, ok)
+		}
+	}
+}
+
+func TestNumericRangeOnIndex(t *testing.T) {
+	t.Setenv("DATA_PATH", t.TempDir())
+	db, err := GetDb("numeric")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := db.NewModel("", "items", false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.DefineAtrib("n", TpInt, 0)
+	model.DefineIndexes("n")
+	if err := model.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer model.data.Close()
+
+	for i := 1; i <= 20; i++ {
+		if err := model.PutObject(fmt.Sprintf("k%02d", i), et.Json{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// En el índice "10" es menor que "9", el rango numérico se resuelve con los objetos
+	rows, err := model.Selects().Where(More("n", 9)).Run(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 11 {
+		t.Fatalf("%d rows with n > 9", len(rows))
+	}
+	for _, row := range rows {
+		if row.Int("n") <= 9 {
+			t.Fatalf("row %v with n > 9", row)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package dbs
 import (
 	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 
 	"github.com/cgalvisleon/et/envar"
 	"github.com/cgalvisleon/et/et"
@@ -121,6 +123,49 @@ func (s *DB) NewModel(schema, name string, isCore bool, version int) (*Model, er
 	return model, nil
 }
 
+/**
+* Backup: Writes an archive of every model in dir/<database>/<schema>/<model>.tar
+* @param dir string
+* @return error
+**/
+func (s *DB) Backup(dir string) error {
+	for _, schema := range s.Schemas {
+		for _, model := range schema.Models {
+			// Los modelos efímeros no tienen datos durables
+			if model.IsEphemeral {
+				continue
+			}
+
+			path := filepath.Join(dir, s.Name, schema.Name)
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+
+			// Se escribe en un temporal para no dejar archivos a medias
+			name := filepath.Join(path, model.Name+".tar")
+			f, err := os.Create(name + ".tmp")
+			if err != nil {
+				return err
+			}
+
+			err = model.Backup(f)
+			if err == nil {
+				err = f.Sync()
+			}
+			f.Close()
+			if err == nil {
+				err = os.Rename(name+".tmp", name)
+			}
+			if err != nil {
+				os.Remove(name + ".tmp")
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 /**
 * GetDb: Returns a database by name
 * @param name string
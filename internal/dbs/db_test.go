@@ -0,0 +1,58 @@
+package dbs
+
+import (
+	"io/fs"
+	"path/filepath"
+	"testing"
+
+	"github.com/cgalvisleon/et/et"
+)
+
+func TestBackupSkipsEphemeral(t *testing.T) {
+	t.Setenv("DATA_PATH", t.TempDir())
+	db, err := GetDb("backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"users", "sessions"} {
+		model, err := db.NewModel("", name, false, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		model.DefineAtrib("user", TpText, "")
+		model.DefineIndexes("user")
+		if name == "sessions" {
+			if err := model.DefineEphemeral(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := model.Init(); err != nil {
+			t.Fatal(err)
+		}
+		defer model.data.Close()
+
+		if err := model.PutObject("u1", et.Json{"user": "ana"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Solo el modelo persistente tiene archivo en el backup
+	dir := t.TempDir()
+	if err := db.Backup(dir); err != nil {
+		t.Fatal(err)
+	}
+	files := []string{}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, d.Name())
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != "users.tar" {
+		t.Fatalf("backup files %v", files)
+	}
+}
@@ -1,11 +1,14 @@
 package dbs
 
 import (
+	"errors"
 	"fmt"
 	"slices"
+	"time"
 
 	"github.com/cgalvisleon/et/et"
 	"github.com/cgalvisleon/et/utility"
+	"github.com/cgalvisleon/josefina/internal/store"
 	"github.com/cgalvisleon/josefina/pkg/msg"
 )
 
@@ -46,6 +49,9 @@ func (s *Model) DefineIndexes(fields ...string) error {
 		if !ok {
 			return fmt.Errorf(msg.MSG_FIELD_NOT_FOUND, field)
 		}
+		if slices.Contains(s.Protected, field) {
+			return fmt.Errorf(msg.MSG_FIELD_PROTECTED, field)
+		}
 
 		idx := slices.Index(s.Indexes, field)
 		if idx == -1 {
@@ -56,6 +62,34 @@ func (s *Model) DefineIndexes(fields ...string) error {
 	return nil
 }
 
+/**
+* DefineCompositeIndex: Defines an index on several fields, the order of the fields is the order of the keys
+* @param fields ...string
+* @return error
+**/
+func (s *Model) DefineCompositeIndex(fields ...string) error {
+	if len(fields) < 2 {
+		return fmt.Errorf(msg.MSG_ARG_REQUIRED, "fields")
+	}
+
+	for _, field := range fields {
+		_, ok := s.Fields[field]
+		if !ok {
+			return fmt.Errorf(msg.MSG_FIELD_NOT_FOUND, field)
+		}
+		if slices.Contains(s.Protected, field) {
+			return fmt.Errorf(msg.MSG_FIELD_PROTECTED, field)
+		}
+	}
+
+	if s.Composites == nil {
+		s.Composites = make(map[string][]string)
+	}
+	s.Composites[compositeName(fields)] = slices.Clone(fields)
+
+	return nil
+}
+
 /*
 *
 * DefineUnique: Defines the unique
@@ -72,8 +106,10 @@ func (s *Model) DefineUnique(fields ...string) error {
 
 		idx := slices.Index(s.Unique, field)
 		if idx == -1 {
+			if err := s.DefineIndexes(field); err != nil {
+				return err
+			}
 			s.Unique = append(s.Unique, field)
-			s.DefineIndexes(field)
 		}
 	}
 	return nil
@@ -93,13 +129,61 @@ func (s *Model) DefineRequired(fields ...string) error {
 
 		idx := slices.Index(s.Required, field)
 		if idx == -1 {
+			if err := s.DefineIndexes(field); err != nil {
+				return err
+			}
 			s.Required = append(s.Required, field)
-			s.DefineIndexes(field)
 		}
 	}
 	return nil
 }
 
+/**
+* DefineTTL: Defines the time to live of the objects, 0 disables the expiration
+* @param ttl time.Duration
+* @return error
+**/
+func (s *Model) DefineTTL(ttl time.Duration) error {
+	if ttl < 0 {
+		return errors.New(msg.MSG_INVALID_TTL)
+	}
+
+	s.TTL = ttl
+	return nil
+}
+
+/**
+* DefineCodec: Defines the codec of the objects written from now on, the objects keep the codec they were written with
+* @param name string
+* @return error
+**/
+func (s *Model) DefineCodec(name string) error {
+	codec, err := store.CodecByName(name)
+	if err != nil {
+		return err
+	}
+
+	s.Codec = codec.Name()
+	if s.data != nil {
+		s.data.SetCodec(codec)
+	}
+
+	return nil
+}
+
+/**
+* DefineEphemeral: Defines the model in memory, the data is not durable and is lost on restart, must be defined before the model is initialized
+* @return error
+**/
+func (s *Model) DefineEphemeral() error {
+	if s.data != nil {
+		return fmt.Errorf(msg.MSG_MODEL_STORE_OPENED, s.Name)
+	}
+
+	s.IsEphemeral = true
+	return nil
+}
+
 /**
 * DefineHidden: Defines the hidden
 * @param name string
@@ -120,6 +204,49 @@ func (s *Model) DefineHidden(fields ...string) error {
 	return nil
 }
 
+/**
+* DefineProtected: Defines the fields whose values are only stored encrypted, the keys of the indexes are in
+* plain text so a protected field can not be indexed and the store of the model must have encryption keys
+* @param fields ...string
+* @return error
+**/
+func (s *Model) DefineProtected(fields ...string) error {
+	for _, field := range fields {
+		_, ok := s.Fields[field]
+		if !ok {
+			return fmt.Errorf(msg.MSG_FIELD_NOT_FOUND, field)
+		}
+		if s.indexed(field) {
+			return fmt.Errorf(msg.MSG_FIELD_PROTECTED, field)
+		}
+
+		idx := slices.Index(s.Protected, field)
+		if idx == -1 {
+			s.Protected = append(s.Protected, field)
+		}
+	}
+	return nil
+}
+
+/**
+* indexed: Returns if the values of the field are keys of an index
+* @param field string
+* @return bool
+**/
+func (s *Model) indexed(field string) bool {
+	if slices.Contains(s.Indexes, field) {
+		return true
+	}
+
+	for _, fields := range s.Composites {
+		if slices.Contains(fields, field) {
+			return true
+		}
+	}
+
+	return false
+}
+
 /**
 * definePrimaryKey: Defines the primary keys
 * @param name string
@@ -133,9 +260,13 @@ func (s *Model) DefinePrimaryKeys(fields ...string) error {
 
 		idx := slices.Index(s.PrimaryKeys, field)
 		if idx == -1 {
+			if err := s.DefineRequired(field); err != nil {
+				return err
+			}
+			if err := s.DefineUnique(field); err != nil {
+				return err
+			}
 			s.PrimaryKeys = append(s.PrimaryKeys, field)
-			s.DefineRequired(field)
-			s.DefineUnique(field)
 		}
 	}
 
@@ -1,6 +1,7 @@
 package dbs
 
 import (
+	"cmp"
 	"reflect"
 	"slices"
 	"strings"
@@ -43,6 +44,23 @@ func numberToFloat64(v any) (float64, reflect.Kind, bool) {
 	}
 }
 
+/**
+* timeValue: Converts a time or its RFC3339 text, as it is read back from the json of an object, to time
+* @param v any
+* @return time.Time, bool
+**/
+func timeValue(v any) (time.Time, bool) {
+	switch value := v.(type) {
+	case time.Time:
+		return value, true
+	case string:
+		result, err := time.Parse(time.RFC3339Nano, value)
+		return result, err == nil
+	}
+
+	return time.Time{}, false
+}
+
 /**
 * numberToInt64: Converts a number to int64
 * @param v any
@@ -229,6 +247,13 @@ func compareAnyOrdered(a, b any) (int, bool) {
 		return 0, false
 	}
 
+	// Enteros con signo, sin perder precisión en float64
+	if isSignedIntKind(aKind) && isSignedIntKind(bKind) {
+		ai, _ := numberToInt64(a)
+		bi, _ := numberToInt64(b)
+		return cmp.Compare(ai, bi), true
+	}
+
 	// Evitar comparar signed vs unsigned si hay negativos (caso peligroso)
 	if isSignedIntKind(aKind) && isUnsignedIntKind(bKind) {
 		ai, _ := numberToInt64(a)
@@ -4,10 +4,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/cgalvisleon/et/et"
 	"github.com/cgalvisleon/et/reg"
+	"github.com/cgalvisleon/et/utility"
 	"github.com/cgalvisleon/josefina/internal/store"
 	"github.com/cgalvisleon/josefina/pkg/msg"
 )
@@ -27,31 +34,38 @@ type TriggerFunction func(tx *Tx, old, new et.Json) error
 
 type Model struct {
 	*From         `json:"from"`
-	Fields        map[string]*Field           `json:"fields"`
-	Path          string                      `json:"path"`
-	Indexes       []string                    `json:"indexes"`
-	PrimaryKeys   []string                    `json:"primary_keys"`
-	ForeignKeys   map[string]*Detail          `json:"foreign_keys"`
-	Unique        []string                    `json:"unique"`
-	Required      []string                    `json:"required"`
-	Hidden        []string                    `json:"hidden"`
-	Details       map[string]*Detail          `json:"details"`
-	Rollups       map[string]*Detail          `json:"rollups"`
-	Relations     map[string]*Detail          `json:"relations"`
-	Calcs         map[string][]byte           `json:"calcs"`
-	BeforeInserts []*Trigger                  `json:"before_inserts"`
-	BeforeUpdates []*Trigger                  `json:"before_updates"`
-	BeforeDeletes []*Trigger                  `json:"before_deletes"`
-	AfterInserts  []*Trigger                  `json:"after_inserts"`
-	AfterUpdates  []*Trigger                  `json:"after_updates"`
-	AfterDeletes  []*Trigger                  `json:"after_deletes"`
-	Version       int                         `json:"version"`
-	IsCore        bool                        `json:"is_core"`
-	IsStrict      bool                        `json:"is_strict"`
-	isDebug       bool                        `json:"-"`
-	stores        map[string]*store.FileStore `json:"-"`
-	triggers      map[string]*Vm              `json:"-"`
-	schema        *Schema                     `json:"-"`
+	Fields        map[string]*Field          `json:"fields"`
+	Path          string                     `json:"path"`
+	Indexes       []string                   `json:"indexes"`
+	Composites    map[string][]string        `json:"composites"`
+	PrimaryKeys   []string                   `json:"primary_keys"`
+	ForeignKeys   map[string]*Detail         `json:"foreign_keys"`
+	Unique        []string                   `json:"unique"`
+	Required      []string                   `json:"required"`
+	Hidden        []string                   `json:"hidden"`
+	Protected     []string                   `json:"protected"`
+	Details       map[string]*Detail         `json:"details"`
+	Rollups       map[string]*Detail         `json:"rollups"`
+	Relations     map[string]*Detail         `json:"relations"`
+	Calcs         map[string][]byte          `json:"calcs"`
+	BeforeInserts []*Trigger                 `json:"before_inserts"`
+	BeforeUpdates []*Trigger                 `json:"before_updates"`
+	BeforeDeletes []*Trigger                 `json:"before_deletes"`
+	AfterInserts  []*Trigger                 `json:"after_inserts"`
+	AfterUpdates  []*Trigger                 `json:"after_updates"`
+	AfterDeletes  []*Trigger                 `json:"after_deletes"`
+	Version       int                        `json:"version"`
+	IsCore        bool                       `json:"is_core"`
+	IsStrict      bool                       `json:"is_strict"`
+	TTL           time.Duration              `json:"ttl"`
+	Codec         string                     `json:"codec"`
+	IsEphemeral   bool                       `json:"is_ephemeral"`
+	isDebug       bool                       `json:"-"`
+	data          *store.FileStore           `json:"-"`
+	stores        map[string]*store.Keyspace `json:"-"`
+	triggers      map[string]*Vm             `json:"-"`
+	schema        *Schema                    `json:"-"`
+	mu            sync.Mutex                 `json:"-"`
 }
 
 /**
@@ -87,25 +101,124 @@ func (s *Model) ToJson() (et.Json, error) {
 }
 
 /**
-* store: Opens a store
+* store: Returns the keyspace of the index, the data and the indexes share the same store
 * @param name string
-* @return *store.FileStore, error
+* @return *store.Keyspace, error
 **/
-func (s *Model) store(name string) (*store.FileStore, error) {
+func (s *Model) store(name string) (*store.Keyspace, error) {
 	result, ok := s.stores[name]
 	if ok {
 		return result, nil
 	}
 
-	result, err := store.Open(s.Path, name, s.isDebug)
-	if err != nil {
-		return nil, err
+	if s.data == nil {
+		var data *store.FileStore
+		var err error
+		if s.IsEphemeral {
+			// Los modelos efímeros no tocan el sistema de archivos
+			data, err = store.OpenMemory("data", s.isDebug)
+		} else {
+			data, err = store.Open(s.Path, "data", s.isDebug)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(s.Protected) > 0 && !s.IsEphemeral && !data.Encrypted {
+			// Sin llaves los campos protegidos quedarían en texto plano en los segmentos
+			data.Close()
+			return nil, fmt.Errorf(msg.MSG_PROTECTED_NOT_ENCRYPTED, s.Name)
+		}
+		if s.Codec != "" {
+			codec, err := store.CodecByName(s.Codec)
+			if err != nil {
+				data.Close()
+				return nil, err
+			}
+			data.SetCodec(codec)
+		}
+		s.data = data
+	}
+
+	result = s.data.Keyspace(name)
+	if !s.IsEphemeral {
+		if err := s.migrateLegacy(name, result); err != nil {
+			return nil, err
+		}
 	}
 	s.stores[name] = result
 
 	return result, nil
 }
 
+/**
+* migrateLegacy: Copies the store of the index in the old layout, one store per index in Path/<name>,
+* to its keyspace and removes it, a copy interrupted is repeated on the next open
+* @param name string, keyspace *store.Keyspace
+* @return error
+**/
+func (s *Model) migrateLegacy(name string, keyspace *store.Keyspace) error {
+	legacy := filepath.Join(s.Path, utility.Normalize(name))
+	if legacy == filepath.Join(s.Path, "data") {
+		return nil
+	}
+
+	migrated := legacy + ".migrated"
+	if err := os.RemoveAll(migrated); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(filepath.Join(legacy, "segments")); err != nil {
+		return nil
+	}
+
+	old, err := store.Open(s.Path, name, s.isDebug)
+	if err != nil {
+		return err
+	}
+
+	batch := store.NewWriteBatch()
+	for _, id := range old.Keys(true, 0, 0) {
+		var value any
+		exists, err := old.Get(id, &value)
+		if err != nil {
+			old.Close()
+			return err
+		}
+		if !exists {
+			continue
+		}
+
+		if err := keyspace.BatchPut(batch, id, value); err != nil {
+			old.Close()
+			return err
+		}
+		if batch.Len() >= 1000 {
+			if err := s.data.Write(batch); err != nil {
+				old.Close()
+				return err
+			}
+			batch = store.NewWriteBatch()
+		}
+	}
+	if batch.Len() > 0 {
+		if err := s.data.Write(batch); err != nil {
+			old.Close()
+			return err
+		}
+	}
+
+	if err := old.Close(); err != nil {
+		return err
+	}
+
+	// Se renombra antes de borrar para no repetir una copia a medio borrar
+	if err := os.Rename(legacy, migrated); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(migrated)
+}
+
 /**
 * Init: Initializes the model
 * @return error
@@ -127,6 +240,13 @@ func (s *Model) Init() error {
 		}
 	}
 
+	for name := range s.Composites {
+		_, err := s.store(name)
+		if err != nil {
+			return err
+		}
+	}
+
 	s.IsInit = true
 	return nil
 }
@@ -141,9 +261,9 @@ func (s *Model) GenKey() string {
 
 /**
 * Source: Returns the source
-* @return *store.FileStore, error
+* @return *store.Keyspace, error
 **/
-func (s *Model) Source() (*store.FileStore, error) {
+func (s *Model) Source() (*store.Keyspace, error) {
 	result, err := s.store(INDEX)
 	if err != nil {
 		return nil, err
@@ -163,6 +283,10 @@ func (s *Model) Put(idx string, value any) error {
 		return err
 	}
 
+	if s.TTL > 0 {
+		return s.PutWithTTL(idx, value, s.TTL)
+	}
+
 	err = source.Put(idx, value)
 	if err != nil {
 		return err
@@ -171,6 +295,25 @@ func (s *Model) Put(idx string, value any) error {
 	return nil
 }
 
+/**
+* PutWithTTL: Puts the model with an expiration
+* @param idx string, value any, ttl time.Duration
+* @return error
+**/
+func (s *Model) PutWithTTL(idx string, value any, ttl time.Duration) error {
+	source, err := s.Source()
+	if err != nil {
+		return err
+	}
+
+	err = source.PutWithTTL(idx, value, ttl)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 /**
 * Remove: Removes the model
 * @param idx string
@@ -214,50 +357,207 @@ func (s *Model) Get(idx string, dest any) (bool, error) {
 }
 
 /**
-* PutObject: Puts the model
+* GetVersion: Gets the object and its version, the version changes on every write
+* @param idx string, dest any
+* @return uint64, bool, error
+**/
+func (s *Model) GetVersion(idx string, dest any) (uint64, bool, error) {
+	source, err := s.Source()
+	if err != nil {
+		return 0, false, err
+	}
+
+	return source.GetVersion(idx, dest)
+}
+
+/**
+* IsConflict: Returns true when the error is a version conflict, also after crossing the rpc
+* @param err error
+* @return bool
+**/
+func IsConflict(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	return errors.Is(err, store.ErrConflict) || strings.Contains(err.Error(), msg.MSG_VERSION_CONFLICT)
+}
+
+/**
+* indexKey: Returns the key of the field in the index
+* @param object et.Json, name string
+* @return string, bool
+**/
+func indexKey(object et.Json, name string) (string, bool) {
+	value, ok := object[name]
+	if !ok || value == nil {
+		return "", false
+	}
+
+	result := fmt.Sprintf("%v", value)
+	return result, result != ""
+}
+
+/**
+* batchIndex: Adds or removes idx from the index entry of key, with a TTL the entry expires with the objects
+* @param batch *store.WriteBatch, index *store.Keyspace, key, idx string, add bool
+* @return error
+**/
+func (s *Model) batchIndex(batch *store.WriteBatch, index *store.Keyspace, key, idx string, add bool) error {
+	entry := map[string]bool{}
+	exists, err := index.Get(key, &entry)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		entry = map[string]bool{}
+	}
+
+	if s.TTL > 0 && (exists || add) {
+		// La entrada vence con el último objeto que la escribe y pierde los objetos ya vencidos
+		source, err := s.Source()
+		if err != nil {
+			return err
+		}
+
+		for id := range entry {
+			if id != idx && !source.IsExist(id) {
+				delete(entry, id)
+			}
+		}
+		if add {
+			entry[idx] = true
+		} else {
+			delete(entry, idx)
+		}
+
+		if len(entry) == 0 {
+			index.BatchDelete(batch, key)
+			return nil
+		}
+
+		return index.BatchPutWithTTL(batch, key, entry, s.TTL)
+	}
+
+	_, ok := entry[idx]
+	if ok == add {
+		return nil
+	}
+
+	if add {
+		entry[idx] = true
+		return index.BatchPut(batch, key, entry)
+	}
+
+	delete(entry, idx)
+	if len(entry) == 0 {
+		index.BatchDelete(batch, key)
+		return nil
+	}
+
+	return index.BatchPut(batch, key, entry)
+}
+
+/**
+* PutObject: Puts the object and its indexes in one atomic batch
 * @param idx string, object et.Json
 * @return error
 **/
 func (s *Model) PutObject(idx string, object et.Json) error {
+	return s.PutObjectIf(idx, object, 0)
+}
+
+/**
+* PutObjectIf: Puts the object only if it is still in the version, 0 does not check the version
+* @param idx string, object et.Json, version uint64
+* @return error
+**/
+func (s *Model) PutObjectIf(idx string, object et.Json, version uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	source, err := s.Source()
+	if err != nil {
+		return err
+	}
+
+	old := et.Json{}
+	current, exists, err := source.GetVersion(idx, &old)
+	if err != nil {
+		return err
+	}
+
+	if version != 0 && current != version {
+		return store.ErrConflict
+	}
+
 	object[INDEX] = idx
+	batch := store.NewWriteBatch()
 	for _, name := range s.Indexes {
-		key := fmt.Sprintf("%v", object[name])
-		if key == "" {
+		if name == INDEX {
 			continue
 		}
 
-		store := s.stores[name]
-		if name == INDEX {
-			err := store.Put(key, object)
-			if err != nil {
-				return err
-			}
-		} else {
-			index := map[string]bool{}
-			exists, err := store.Get(key, &index)
-			if err != nil {
-				return err
-			}
+		index, err := s.store(name)
+		if err != nil {
+			return err
+		}
 
-			if !exists {
-				index = map[string]bool{}
+		key, ok := indexKey(object, name)
+		if exists {
+			oldKey, had := indexKey(old, name)
+			if had && (!ok || oldKey != key) {
+				if err := s.batchIndex(batch, index, oldKey, idx, false); err != nil {
+					return err
+				}
 			}
+		}
 
-			_, ok := index[idx]
-			if ok {
-				return nil
+		if ok {
+			if err := s.batchIndex(batch, index, key, idx, true); err != nil {
+				return err
 			}
+		}
+	}
 
-			index[idx] = true
-			err = store.Put(key, index)
-			if err != nil {
-				return err
+	for name, fields := range s.Composites {
+		index, err := s.store(name)
+		if err != nil {
+			return err
+		}
+
+		key := s.compositeKey(object, fields)
+		if exists {
+			oldKey := s.compositeKey(old, fields)
+			if oldKey != key {
+				if err := s.batchIndex(batch, index, oldKey, idx, false); err != nil {
+					return err
+				}
 			}
+		}
 
-			return nil
+		if err := s.batchIndex(batch, index, key, idx, true); err != nil {
+			return err
 		}
 	}
-	return nil
+
+	// La escritura se descarta si otro escritor cambió el objeto después de la lectura
+	switch {
+	case version != 0 && s.TTL > 0:
+		err = source.BatchPutIfWithTTL(batch, idx, object, current, s.TTL)
+	case version != 0:
+		err = source.BatchPutIf(batch, idx, object, current)
+	case s.TTL > 0:
+		err = source.BatchPutWithTTL(batch, idx, object, s.TTL)
+	default:
+		err = source.BatchPut(batch, idx, object)
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.data.Write(batch)
 }
 
 /**
@@ -270,13 +570,21 @@ func (s *Model) GetObjet(idx string, dest et.Json) (bool, error) {
 }
 
 /**
-* RemoveObject: Removes the model
+* RemoveObject: Removes the object and its indexes in one atomic batch
 * @param idx string
 * @return error
 **/
 func (s *Model) RemoveObject(idx string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	source, err := s.Source()
+	if err != nil {
+		return err
+	}
+
 	data := et.Json{}
-	exists, err := s.Get(idx, &data)
+	exists, err := source.Get(idx, &data)
 	if err != nil {
 		return err
 	}
@@ -285,52 +593,39 @@ func (s *Model) RemoveObject(idx string) error {
 		return nil
 	}
 
+	batch := store.NewWriteBatch()
 	for _, name := range s.Indexes {
-		key := fmt.Sprintf("%v", data[name])
-		if key == "" {
+		if name == INDEX {
 			continue
 		}
 
-		store := s.stores[name]
-		if name == INDEX {
-			_, err := store.Delete(key)
-			if err != nil {
-				return err
-			}
-		} else {
-			index := map[string]bool{}
-			exists, err := store.Get(key, &index)
-			if err != nil {
-				return err
-			}
-
-			if !exists {
-				return nil
-			}
-
-			_, ok := index[idx]
-			if !ok {
-				return nil
-			}
+		key, ok := indexKey(data, name)
+		if !ok {
+			continue
+		}
 
-			delete(index, key)
-			if len(index) == 0 {
-				_, err = store.Delete(key)
-				if err != nil {
-					return err
-				}
-				return nil
-			}
+		index, err := s.store(name)
+		if err != nil {
+			return err
+		}
 
-			err = store.Put(key, index)
-			if err != nil {
-				return err
-			}
+		if err := s.batchIndex(batch, index, key, idx, false); err != nil {
+			return err
+		}
+	}
+	for name, fields := range s.Composites {
+		index, err := s.store(name)
+		if err != nil {
+			return err
+		}
 
-			return nil
+		if err := s.batchIndex(batch, index, s.compositeKey(data, fields), idx, false); err != nil {
+			return err
 		}
 	}
-	return nil
+	source.BatchDelete(batch, idx)
+
+	return s.data.Write(batch)
 }
 
 /**
@@ -383,6 +678,57 @@ func (s *Model) Count() (int, error) {
 	return result.Count(), nil
 }
 
+/**
+* Stats: Returns the statistics of the store of the model with the records of each index
+* @return et.Json, error
+**/
+func (s *Model) Stats() (et.Json, error) {
+	source, err := s.Source()
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := source.Store().Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := et.Json{}
+	for _, name := range s.Indexes {
+		keyspace, err := s.store(name)
+		if err != nil {
+			return nil, err
+		}
+		indexes[name] = keyspace.Count()
+	}
+	for name := range s.Composites {
+		keyspace, err := s.store(name)
+		if err != nil {
+			return nil, err
+		}
+		indexes[name] = keyspace.Count()
+	}
+
+	result := stats.ToJson()
+	result["model"] = s.Name
+	result["indexes"] = indexes
+	return result, nil
+}
+
+/**
+* Backup: Writes a consistent archive of the data of the model
+* @param w io.Writer
+* @return error
+**/
+func (s *Model) Backup(w io.Writer) error {
+	source, err := s.Source()
+	if err != nil {
+		return err
+	}
+
+	return source.Store().Backup(w)
+}
+
 /**
 * AddBeforeInsert
 * @param name string, fn []byte
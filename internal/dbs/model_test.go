@@ -0,0 +1,322 @@
+package dbs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cgalvisleon/et/et"
+	"github.com/cgalvisleon/josefina/internal/store"
+)
+
+func TestModelMigratesLegacyLayout(t *testing.T) {
+	t.Setenv("DATA_PATH", t.TempDir())
+	db, err := GetDb("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := db.NewModel("", "users", false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.DefineAtrib("status", TpText, "")
+	model.DefineIndexes("status")
+
+	// Un store por índice, como lo dejaban las versiones anteriores
+	source, err := store.Open(model.Path, INDEX, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.Put("u1", et.Json{INDEX: "u1", "name": "ana", "status": "active"})
+	source.Put("u2", et.Json{INDEX: "u2", "name": "luis", "status": "archived"})
+	if err := source.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := store.Open(model.Path, "status", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Put("active", map[string]bool{"u1": true})
+	index.Put("archived", map[string]bool{"u2": true})
+	if err := index.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := model.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer model.data.Close()
+
+	object := et.Json{}
+	exists, err := model.GetObjet("u1", object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists || object.Str("name") != "ana" {
+		t.Fatalf("object not migrated: %v", object)
+	}
+
+	rows, err := model.Selects().Where(Eq("status", "archived")).Run(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Str("name") != "luis" {
+		t.Fatalf("index not migrated: %v", rows)
+	}
+
+	for _, name := range []string{INDEX, "status"} {
+		if _, err := os.Stat(filepath.Join(model.Path, name)); !os.IsNotExist(err) {
+			t.Fatalf("legacy store %s not removed: %v", name, err)
+		}
+	}
+}
+
+func TestModelIndexesExpireWithObjects(t *testing.T) {
+	db, err := GetDb("expire")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := db.NewModel("", "sessions", false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.DefineAtrib("user", TpText, "")
+	model.DefineIndexes("user")
+	model.DefineCompositeIndex("user", "device")
+	if err := model.DefineEphemeral(); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.DefineTTL(50 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer model.data.Close()
+
+	if err := model.PutObject("s1", et.Json{"user": "ana", "device": "web"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := model.PutObject("s2", et.Json{"user": "ana", "device": "app"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// La entrada pierde el objeto vencido y vence con el último
+	entry := map[string]bool{}
+	if _, err := model.GetIndex("user", "ana", entry); err != nil {
+		t.Fatal(err)
+	}
+	if len(entry) != 1 || !entry["s2"] {
+		t.Fatalf("expired object kept in the index: %v", entry)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	for _, name := range []string{"user", compositeName([]string{"user", "device"})} {
+		index, err := model.store(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index.Count() != 0 {
+			t.Fatalf("index %s entries outlive the objects: %v", name, index.Keys(true, 0, 0))
+		}
+	}
+}
+
+func TestModelUpdateConflict(t *testing.T) {
+	t.Setenv("DATA_PATH", t.TempDir())
+	db, err := GetDb("conflict")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := db.NewModel("", "accounts", false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.DefineAtrib("name", TpText, "")
+	model.DefineAtrib("balance", TpInt, 0)
+	if err := model.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer model.data.Close()
+
+	if err := model.PutObject("a1", et.Json{"name": "ana", "balance": 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Update lee la versión de la fila antes de los triggers y la confirma al escribir
+	current := et.Json{}
+	version, exists, err := model.GetVersion("a1", &current)
+	if err != nil || !exists || version == 0 {
+		t.Fatalf("row in version %d %v %v", version, exists, err)
+	}
+
+	if err := model.PutObject("a1", et.Json{"name": "ana", "balance": 20}); err != nil {
+		t.Fatal(err)
+	}
+
+	update := current.Clone()
+	update["balance"] = current.Int("balance") + 5
+	if err := model.PutObjectIf("a1", update, version); !IsConflict(err) {
+		t.Fatalf("update over a concurrent write: %v", err)
+	}
+
+	object := et.Json{}
+	if _, err := model.GetObjet("a1", object); err != nil {
+		t.Fatal(err)
+	}
+	if object.Int("balance") != 20 {
+		t.Fatalf("concurrent write lost: %v", object)
+	}
+
+	// Con la versión releída la escritura se aplica
+	version, _, err = model.GetVersion("a1", &current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update["balance"] = current.Int("balance") + 5
+	if err := model.PutObjectIf("a1", update, version); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModelProtectedFields(t *testing.T) {
+	t.Setenv("DATA_PATH", t.TempDir())
+	db, err := GetDb("protected")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := db.NewModel("", "users", false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.DefineAtrib("email", TpText, "")
+	model.DefineAtrib("status", TpText, "")
+	model.DefineIndexes("status")
+
+	// Los valores indexados son claves en texto plano
+	if err := model.DefineProtected("status"); err == nil {
+		t.Fatal("indexed field protected")
+	}
+	if err := model.DefineProtected("email"); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.DefineIndexes("email"); err == nil {
+		t.Fatal("protected field indexed")
+	}
+	if err := model.DefineCompositeIndex("status", "email"); err == nil {
+		t.Fatal("protected field in a composite index")
+	}
+	if err := model.DefineUnique("email"); err == nil || slices.Contains(model.Unique, "email") {
+		t.Fatal("protected field unique")
+	}
+
+	if err := model.Init(); err == nil {
+		t.Fatal("protected fields opened without encryption keys")
+	}
+
+	t.Setenv("STORE_KEYS", "1:"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0x2a}, 32)))
+	if err := model.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer model.data.Close()
+
+	if err := model.PutObject("u1", et.Json{"email": "ana@example.com", "status": "active"}); err != nil {
+		t.Fatal(err)
+	}
+
+	indexed := false
+	err = filepath.Walk(model.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		bt, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(bt, []byte("ana@example.com")) {
+			t.Fatalf("protected value in plain text in %s", path)
+		}
+		indexed = indexed || bytes.Contains(bt, []byte("active"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !indexed {
+		t.Fatal("segments not written")
+	}
+}
+
+func TestModelEphemeralWithoutFiles(t *testing.T) {
+	t.Setenv("SORT_BUFFER_ROWS", "2")
+	dirs := []string{t.TempDir(), t.TempDir()}
+	t.Setenv("DATA_PATH", dirs[0])
+	// Las corridas del orden no se pueden crear, se borran al cerrar el recorrido
+	t.Setenv("TMPDIR", filepath.Join(dirs[1], "missing"))
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dirs[1]); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	db, err := GetDb("ephemeral")
+	if err != nil {
+		t.Fatal(err)
+	}
+	model, err := db.NewModel("", "sessions", false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.DefineAtrib("user", TpText, "")
+	model.DefineAtrib("n", TpInt, 0)
+	model.DefineIndexes("user")
+	if err := model.DefineEphemeral(); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer model.data.Close()
+	if err := model.DefineEphemeral(); err == nil {
+		t.Fatal("ephemeral defined after the store was opened")
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := model.PutObject(fmt.Sprintf("s%d", 4-i), et.Json{"user": "ana", "n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Sin límite el orden de un modelo persistente escribiría corridas a disco
+	rows, err := model.Selects().Where(Eq("user", "ana")).Desc("n").Run(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 5 || rows[0].Int("n") != 4 || rows[4].Int("n") != 0 {
+		t.Fatalf("rows %v", rows)
+	}
+
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 0 {
+			t.Fatalf("ephemeral model wrote %s in %s", entries[0].Name(), dir)
+		}
+	}
+}
@@ -0,0 +1,582 @@
+package dbs
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/cgalvisleon/et/et"
+	"github.com/cgalvisleon/josefina/internal/store"
+	"github.com/cgalvisleon/josefina/pkg/msg"
+)
+
+type PlanKind string
+
+const (
+	PlanScan      PlanKind = "scan"      // recorre todos los objetos
+	PlanKey       PlanKind = "key"       // claves primarias
+	PlanIndex     PlanKind = "index"     // índice de un campo
+	PlanComposite PlanKind = "composite" // índice compuesto
+	PlanIntersect PlanKind = "intersect" // objetos de todos los hijos
+	PlanUnion     PlanKind = "union"     // objetos de alguno de los hijos
+)
+
+const planPage = 256 // claves del índice leídas por página
+
+/**
+* Plan: Access path of a query, the costs are estimated in records read
+**/
+type Plan struct {
+	Kind     PlanKind   `json:"kind"`
+	Index    string     `json:"index"`
+	Field    string     `json:"field"`
+	Operator Operator   `json:"operator"`
+	Keys     int        `json:"keys"` // claves del índice a leer
+	Rows     float64    `json:"rows"` // objetos estimados
+	Cost     float64    `json:"cost"` // lecturas estimadas sin contar los objetos
+	Children []*Plan    `json:"children"`
+	keys     []string   `json:"-"` // claves exactas, sin ellas el rango
+	start    string     `json:"-"`
+	end      string     `json:"-"`
+	filter   *Condition `json:"-"` // filtra las claves del rango
+	equals   int        `json:"-"` // campos del índice compuesto fijados por igualdades
+}
+
+/**
+* Total: Returns the estimated cost with the objects read
+* @return float64
+**/
+func (s *Plan) Total() float64 {
+	if s.Kind == PlanScan {
+		return s.Cost
+	}
+
+	return s.Cost + s.Rows
+}
+
+/**
+* ToJson
+* @return et.Json
+**/
+func (s *Plan) ToJson() et.Json {
+	result := et.Json{
+		"kind":  s.Kind,
+		"rows":  s.Rows,
+		"cost":  s.Cost,
+		"total": s.Total(),
+	}
+	if s.Index != "" {
+		result["index"] = s.Index
+		result["keys"] = s.Keys
+	}
+	if s.Field != "" {
+		result["field"] = s.Field
+		result["operator"] = s.Operator
+	}
+	if len(s.Children) > 0 {
+		children := make([]et.Json, 0, len(s.Children))
+		for _, child := range s.Children {
+			children = append(children, child.ToJson())
+		}
+		result["children"] = children
+	}
+
+	return result
+}
+
+/**
+* planner: Estimates the access paths of the conditions with the statistics of the indexes
+**/
+type planner struct {
+	model      *Model
+	source     *store.Keyspace
+	records    float64
+	candidates []*Plan
+}
+
+/**
+* scanPlan
+* @return *Plan
+**/
+func (s *planner) scanPlan() *Plan {
+	return &Plan{
+		Kind:  PlanScan,
+		Index: INDEX,
+		Rows:  s.records,
+		Cost:  s.records,
+	}
+}
+
+/**
+* consider: Keeps the plan as a candidate for the explain
+* @param plan *Plan
+* @return *Plan
+**/
+func (s *planner) consider(plan *Plan) *Plan {
+	if plan != nil {
+		s.candidates = append(s.candidates, plan)
+	}
+
+	return plan
+}
+
+/**
+* conditionKeys: Returns the keys of the index that match the condition exactly, as indexKey writes them
+* @param con *Condition
+* @return []string, bool
+**/
+func conditionKeys(con *Condition) ([]string, bool) {
+	format := func(value any) (string, bool) {
+		value, ok := keyValue(value)
+		if !ok {
+			return "", false
+		}
+
+		result := fmt.Sprintf("%v", value)
+		return result, result != ""
+	}
+
+	switch con.Operator {
+	case OpEq, OpIs:
+		key, ok := format(con.Value)
+		if !ok {
+			return nil, false
+		}
+		return []string{key}, true
+	case OpIn:
+		values, ok := con.Value.([]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		result := make([]string, 0, len(values))
+		for _, value := range values {
+			key, ok := format(value)
+			if !ok {
+				return nil, false
+			}
+			if !slices.Contains(result, key) {
+				result = append(result, key)
+			}
+		}
+		return result, true
+	default:
+		return nil, false
+	}
+}
+
+/**
+* leaf: Returns the access path of a condition by an index, nil when the condition needs the objects
+* @param con *Condition
+* @return *Plan
+**/
+func (s *planner) leaf(con *Condition) *Plan {
+	if con.Field == INDEX {
+		return s.keyLeaf(con)
+	}
+
+	if !slices.Contains(s.model.Indexes, con.Field) {
+		return nil
+	}
+
+	index, err := s.model.store(con.Field)
+	if err != nil {
+		return nil
+	}
+
+	// Objetos por clave, las claves repartidas por igual
+	distinct := float64(index.Count())
+	perKey := 0.0
+	if distinct > 0 {
+		perKey = s.records / distinct
+	}
+
+	result := &Plan{
+		Kind:     PlanIndex,
+		Index:    con.Field,
+		Field:    con.Field,
+		Operator: con.Operator,
+	}
+	if keys, ok := conditionKeys(con); ok {
+		result.keys = keys
+		result.Keys = len(keys)
+	} else if start, end, ok := con.IndexRange(); ok {
+		result.start, result.end = start, end
+		result.filter = con
+		result.Keys = index.CountRange(start, end)
+	} else {
+		return nil
+	}
+
+	result.Rows = float64(result.Keys) * perKey
+	result.Cost = float64(result.Keys)
+	return result
+}
+
+/**
+* keyLeaf: The keys of the source are the primary keys
+* @param con *Condition
+* @return *Plan
+**/
+func (s *planner) keyLeaf(con *Condition) *Plan {
+	result := &Plan{
+		Kind:     PlanKey,
+		Index:    INDEX,
+		Field:    con.Field,
+		Operator: con.Operator,
+	}
+	if keys, ok := conditionKeys(con); ok {
+		result.keys = keys
+		result.Keys = len(keys)
+	} else if start, end, ok := con.IndexRange(); ok {
+		result.start, result.end = start, end
+		result.filter = con
+		result.Keys = s.source.CountRange(start, end)
+	} else {
+		return nil
+	}
+
+	// Las claves son las de los objetos, el índice primario está en memoria y solo se leen los objetos
+	result.Rows = min(float64(result.Keys), s.records)
+	return result
+}
+
+/**
+* compositeLeaf: Returns the access path by the best composite index
+* @param conditions []*Condition
+* @return *Plan
+**/
+func (s *planner) compositeLeaf(conditions []*Condition) *Plan {
+	rng, ok := s.model.compositeRange(conditions)
+	if !ok {
+		return nil
+	}
+
+	index, err := s.model.store(rng.index)
+	if err != nil {
+		return nil
+	}
+
+	result := &Plan{
+		Kind:   PlanComposite,
+		Index:  rng.index,
+		start:  rng.start,
+		end:    rng.end,
+		equals: rng.equals,
+		Keys:   index.CountRange(rng.start, rng.end),
+	}
+	if distinct := float64(index.Count()); distinct > 0 {
+		result.Rows = float64(result.Keys) * s.records / distinct
+	}
+	result.Cost = float64(result.Keys)
+	return result
+}
+
+/**
+* and: Returns the cheapest of the plans or of their intersection
+* @param a, b *Plan
+* @return *Plan
+**/
+func (s *planner) and(a, b *Plan) *Plan {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	rows := 0.0
+	if s.records > 0 {
+		rows = a.Rows * b.Rows / s.records
+	}
+	intersect := &Plan{
+		Kind:     PlanIntersect,
+		Rows:     rows,
+		Cost:     a.Cost + b.Cost,
+		Children: []*Plan{a, b},
+	}
+
+	result := s.consider(intersect)
+	for _, plan := range []*Plan{a, b} {
+		if plan.Total() < result.Total() {
+			result = plan
+		}
+	}
+
+	return result
+}
+
+/**
+* or: Returns the union of the plans, nil when one of them needs the objects
+* @param a, b *Plan
+* @return *Plan
+**/
+func (s *planner) or(a, b *Plan) *Plan {
+	if a == nil || b == nil {
+		return nil
+	}
+
+	return s.consider(&Plan{
+		Kind:     PlanUnion,
+		Rows:     min(a.Rows+b.Rows, s.records),
+		Cost:     a.Cost + b.Cost,
+		Children: []*Plan{a, b},
+	})
+}
+
+/**
+* node: Returns the access path of a condition and its groups, nil when the objects must be scanned
+* @param con *Condition
+* @return *Plan
+**/
+func (s *planner) node(con *Condition) *Plan {
+	// Una negación puede cumplirse fuera de cualquier rango del índice
+	if con.Negate {
+		return nil
+	}
+
+	switch con.Group {
+	case And:
+		var result *Plan
+		leaves := []*Condition{}
+		for _, child := range con.Conditions {
+			result = s.and(result, s.node(child))
+			if !child.IsGroup() && !child.Negate {
+				leaves = append(leaves, child)
+			}
+		}
+
+		if composite := s.consider(s.compositeLeaf(leaves)); composite != nil {
+			if result == nil || composite.Total() < result.Total() {
+				result = composite
+			}
+		}
+		return result
+	case Or:
+		var result *Plan
+		for i, child := range con.Conditions {
+			plan := s.node(child)
+			if plan == nil {
+				return nil
+			}
+			if i == 0 {
+				result = plan
+			} else {
+				result = s.or(result, plan)
+			}
+		}
+		return result
+	default:
+		return s.consider(s.leaf(con))
+	}
+}
+
+/**
+* plan: Returns the cheapest access path of the tree of conditions or the scan
+* @param root *Condition
+* @return *Plan
+**/
+func (s *planner) plan(root *Condition) *Plan {
+	scan := s.consider(s.scanPlan())
+	if root == nil {
+		return scan
+	}
+
+	result := s.node(root)
+	if !root.IsGroup() && !root.Negate {
+		// Una sola condición también puede usar un índice compuesto
+		if composite := s.consider(s.compositeLeaf([]*Condition{root})); composite != nil {
+			if result == nil || composite.Total() < result.Total() {
+				result = composite
+			}
+		}
+	}
+
+	if result == nil || scan.Total() <= result.Total() {
+		return scan
+	}
+
+	return result
+}
+
+/**
+* newPlanner
+* @param model *Model
+* @return *planner, error
+**/
+func newPlanner(model *Model) (*planner, error) {
+	source, err := model.Source()
+	if err != nil {
+		return nil, err
+	}
+
+	return &planner{
+		model:   model,
+		source:  source,
+		records: float64(source.Count()),
+	}, nil
+}
+
+/**
+* planIds: Calls fn with the primary keys of the plan in the order of its index, without repeating, until fn
+* returns false. The keys of a range are read by pages, so a query that stops at its limit does not read the
+* rest of the index
+* @param model *Model, plan *Plan, asc bool, fn func(id string) (bool, error)
+* @return error
+**/
+func planIds(model *Model, plan *Plan, asc bool, fn func(id string) (bool, error)) error {
+	_, err := eachPlanId(model, plan, asc, fn)
+	return err
+}
+
+/**
+* eachPlanId: Calls fn with the primary keys of the plan, returns false when fn stopped the reading
+* @param model *Model, plan *Plan, asc bool, fn func(id string) (bool, error)
+* @return bool, error
+**/
+func eachPlanId(model *Model, plan *Plan, asc bool, fn func(id string) (bool, error)) (bool, error) {
+	switch plan.Kind {
+	case PlanUnion:
+		seen := map[string]bool{}
+		for _, child := range plan.Children {
+			next, err := eachPlanId(model, child, asc, func(id string) (bool, error) {
+				if seen[id] {
+					return true, nil
+				}
+				seen[id] = true
+				return fn(id)
+			})
+			if err != nil || !next {
+				return next, err
+			}
+		}
+		return true, nil
+	case PlanIntersect:
+		// Los demás hijos se leen completos, el primero se recorre mientras fn lo pida
+		sets := make([]map[string]bool, 0, len(plan.Children)-1)
+		for _, child := range plan.Children[1:] {
+			set := map[string]bool{}
+			_, err := eachPlanId(model, child, asc, func(id string) (bool, error) {
+				set[id] = true
+				return true, nil
+			})
+			if err != nil {
+				return false, err
+			}
+			sets = append(sets, set)
+		}
+		return eachPlanId(model, plan.Children[0], asc, func(id string) (bool, error) {
+			for _, set := range sets {
+				if !set[id] {
+					return true, nil
+				}
+			}
+			return fn(id)
+		})
+	}
+
+	index, err := model.store(plan.Index)
+	if err != nil {
+		return false, err
+	}
+
+	seen := map[string]bool{}
+	eachKeys := func(keys []string) (bool, error) {
+		for _, key := range keys {
+			if plan.Kind == PlanKey {
+				next, err := fn(key)
+				if err != nil || !next {
+					return false, err
+				}
+				continue
+			}
+
+			entry := map[string]bool{}
+			exists, err := model.GetIndex(plan.Index, key, entry)
+			if err != nil {
+				return false, err
+			}
+			if !exists {
+				continue
+			}
+
+			ids := make([]string, 0, len(entry))
+			for id := range entry {
+				if !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
+				}
+			}
+			slices.Sort(ids)
+			for _, id := range ids {
+				next, err := fn(id)
+				if err != nil || !next {
+					return false, err
+				}
+			}
+		}
+		return true, nil
+	}
+
+	if plan.keys != nil {
+		return eachKeys(plan.keys)
+	}
+
+	// Cada página sigue después de la última clave leída, en el sentido del recorrido
+	start, end := plan.start, plan.end
+	for {
+		page := index.KeysRange(start, end, asc, 0, planPage)
+		keys := page
+		if plan.filter != nil {
+			keys = plan.filter.ApplyToIndex(keys)
+		}
+		next, err := eachKeys(keys)
+		if err != nil || !next || len(page) < planPage {
+			return next, err
+		}
+
+		last := page[len(page)-1]
+		if asc {
+			start = last + "\x00"
+		} else {
+			end = last
+		}
+	}
+}
+
+/**
+* Explain: Returns the plan chosen for the conditions with its estimates and the candidates considered,
+* pushdown tells if the reading stops at the offset plus the limit, that is when nothing is sorted or grouped
+* @return et.Json, error
+**/
+func (s *Wheres) Explain() (et.Json, error) {
+	model := s.owner
+	if model == nil {
+		return nil, errors.New(msg.MSG_MODEL_NOT_FOUND)
+	}
+
+	planner, err := newPlanner(model)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := planner.plan(s.tree())
+	_, ordered := s.ordered(plan)
+	candidates := make([]et.Json, 0, len(planner.candidates))
+	for _, candidate := range planner.candidates {
+		candidates = append(candidates, candidate.ToJson())
+	}
+
+	return et.Json{
+		"model":      model.Name,
+		"records":    planner.records,
+		"plan":       plan.ToJson(),
+		"offset":     s.offset,
+		"limit":      s.limit,
+		"orders":     s.orders,
+		"sort":       !ordered && !s.isGrouped(),
+		"pushdown":   s.limit > 0 && ordered && !s.isGrouped(),
+		"group_by":   s.groupBy,
+		"candidates": candidates,
+	}, nil
+}
@@ -0,0 +1,157 @@
+package dbs
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cgalvisleon/et/et"
+)
+
+/**
+* codesModel: Returns an ephemeral model with n objects indexed by a unique code
+* @param t *testing.T, n int
+* @return *Model
+**/
+func codesModel(t *testing.T, n int) *Model {
+	t.Helper()
+	db, err := GetDb("planner")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := db.NewModel("", t.Name(), false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.DefineAtrib("code", TpText, "")
+	model.DefineAtrib("n", TpInt, 0)
+	model.DefineIndexes("code")
+	if err := model.DefineEphemeral(); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { model.data.Close() })
+
+	for i := 0; i < n; i++ {
+		if err := model.PutObject(fmt.Sprintf("k%04d", i), et.Json{"code": fmt.Sprintf("c%04d", i), "n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return model
+}
+
+func TestPlanIdsReadsPages(t *testing.T) {
+	n := 2*planPage + 10
+	model := codesModel(t, n)
+	plan := &Plan{Kind: PlanIndex, Index: "code"}
+
+	// Los dos sentidos recorren todas las páginas sin saltar ni repetir claves
+	for _, asc := range []bool{true, false} {
+		got := []string{}
+		err := planIds(model, plan, asc, func(id string) (bool, error) {
+			got = append(got, id)
+			return true, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != n {
+			t.Fatalf("asc %v: read %d ids, expected %d", asc, len(got), n)
+		}
+		for i, id := range got {
+			j := i
+			if !asc {
+				j = n - 1 - i
+			}
+			if id != fmt.Sprintf("k%04d", j) {
+				t.Fatalf("asc %v: id %d is %s", asc, i, id)
+			}
+		}
+	}
+
+	// La lectura se detiene cuando fn lo pide
+	calls := 0
+	err := planIds(model, plan, true, func(id string) (bool, error) {
+		calls++
+		return calls < 5, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 5 {
+		t.Fatalf("read continued after stopping: %d calls", calls)
+	}
+}
+
+func TestRunLimitPushdown(t *testing.T) {
+	model := codesModel(t, 4*planPage)
+
+	// La página cruza el límite entre dos páginas del índice y el campo sin índice queda como filtro residual
+	query := model.Selects().Where(Less("code", "c0300")).And(Group(Or, Eq("n", 0), More("n", 0)))
+	query.Limit(2, 140)
+	explain, err := query.Explain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explain.Json("plan").Str("kind") != string(PlanIndex) || !explain.Bool("pushdown") {
+		t.Fatalf("limit not pushed down: %v", explain.ToString())
+	}
+
+	rows, err := query.Run(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 140 || rows[0].Str("code") != "c0140" || rows[139].Str("code") != "c0279" {
+		t.Fatalf("wrong page: %d rows starting at %v", len(rows), rows[0])
+	}
+
+	// Con un orden que el índice no da, se leen todos los objetos para ordenarlos
+	sorted := model.Selects().Where(Less("code", "c0300")).Desc("n")
+	sorted.Limit(1, 3)
+	explain, err = sorted.Explain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explain.Bool("pushdown") || !explain.Bool("sort") {
+		t.Fatalf("pushdown reported with a sort: %v", explain.ToString())
+	}
+
+	rows, err = sorted.Run(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0].Int("n") != 299 {
+		t.Fatalf("sorted rows: %v", rows)
+	}
+}
+
+func TestPlannerCrossover(t *testing.T) {
+	model := codesModel(t, 300)
+
+	// Con un objeto por clave el índice lee cada clave y su objeto, el scan lee los 300 objetos
+	cases := []struct {
+		con  *Condition
+		kind PlanKind
+	}{
+		{Less("code", "c0149"), PlanIndex},
+		{Less("code", "c0151"), PlanScan},
+		{Less(INDEX, "k0299"), PlanKey},
+	}
+	for _, c := range cases {
+		explain, err := model.Selects().Where(c.con).Explain()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		plan := explain.Json("plan")
+		if plan.Str("kind") != string(c.kind) {
+			t.Fatalf("%s %v: got %v", c.con.Field, c.con.Value, explain.ToString())
+		}
+		if c.kind == PlanIndex && (plan.Num("cost") != plan.Num("keys") || plan.Num("total") != plan.Num("keys")+plan.Num("rows")) {
+			t.Fatalf("objects counted twice: %v", plan.ToString())
+		}
+	}
+}
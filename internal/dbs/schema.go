@@ -44,11 +44,13 @@ func (s *Schema) newModel(name string, isCore bool, version int) (*Model, error)
 		Fields:        make(map[string]*Field, 0),
 		Path:          path,
 		Indexes:       make([]string, 0),
+		Composites:    make(map[string][]string, 0),
 		PrimaryKeys:   make([]string, 0),
 		ForeignKeys:   make(map[string]*Detail, 0),
 		Unique:        make([]string, 0),
 		Required:      make([]string, 0),
 		Hidden:        make([]string, 0),
+		Protected:     make([]string, 0),
 		Details:       make(map[string]*Detail, 0),
 		Rollups:       make(map[string]*Detail, 0),
 		Relations:     make(map[string]*Detail, 0),
@@ -61,7 +63,7 @@ func (s *Schema) newModel(name string, isCore bool, version int) (*Model, error)
 		AfterDeletes:  make([]*Trigger, 0),
 		Version:       version,
 		IsCore:        isCore,
-		stores:        make(map[string]*store.FileStore, 0),
+		stores:        make(map[string]*store.Keyspace, 0),
 		triggers:      make(map[string]*Vm, 0),
 		schema:        s,
 	}
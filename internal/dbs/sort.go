@@ -0,0 +1,367 @@
+package dbs
+
+import (
+	"bufio"
+	"cmp"
+	"container/heap"
+	"encoding/gob"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/cgalvisleon/et/envar"
+	"github.com/cgalvisleon/et/et"
+)
+
+/**
+* OrderBy: Field or nested path of the order and its direction
+**/
+type OrderBy struct {
+	Field string `json:"field"`
+	Asc   bool   `json:"asc"`
+}
+
+/**
+* valueRank: Order of the types, the nulls first
+* @param value any
+* @return int
+**/
+func valueRank(value any) int {
+	switch value.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case string:
+		return 3
+	case time.Time:
+		return 4
+	}
+
+	if _, _, ok := numberToFloat64(value); ok {
+		return 2
+	}
+
+	return 5
+}
+
+/**
+* compareValues: Compares two values of any type, the values of different types are ordered by type
+* @param a, b any
+* @return int
+**/
+func compareValues(a, b any) int {
+	ra, rb := valueRank(a), valueRank(b)
+	if ra != rb {
+		return cmp.Compare(ra, rb)
+	}
+
+	if ba, ok := a.(bool); ok {
+		bb := b.(bool)
+		switch {
+		case ba == bb:
+			return 0
+		case bb:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	result, _ := compareAnyOrdered(a, b)
+	return result
+}
+
+/**
+* typedValue: Returns the value of the field with the type of its definition, the datetime fields are read back
+* from the json of the objects as text and are compared as times
+* @param field string, value any
+* @return any
+**/
+func (s *Model) typedValue(field string, value any) any {
+	if s == nil {
+		return value
+	}
+
+	definition, ok := s.Fields[field]
+	if !ok || definition.TypeData != TpDateTime {
+		return value
+	}
+
+	if result, ok := timeValue(value); ok {
+		return result
+	}
+
+	return value
+}
+
+/**
+* compareItems: Compares two objects by the orders with the types of the fields of the model, the missing fields are null
+* @param model *Model, a, b et.Json, orders []*OrderBy
+* @return int
+**/
+func compareItems(model *Model, a, b et.Json, orders []*OrderBy) int {
+	for _, order := range orders {
+		av, _ := fieldValue(a, order.Field)
+		bv, _ := fieldValue(b, order.Field)
+		result := compareValues(model.typedValue(order.Field, av), model.typedValue(order.Field, bv))
+		if !order.Asc {
+			result = -result
+		}
+		if result != 0 {
+			return result
+		}
+	}
+
+	return 0
+}
+
+/**
+* sorter: Sorts the objects of a query, with a limit only the first ones are kept and without it
+* the sorted runs that exceed the buffer are written to temporary files and merged
+**/
+type sorter struct {
+	model  *Model
+	orders []*OrderBy
+	keep   int // objetos a conservar, 0 todos
+	buffer int // objetos en memoria antes de escribir una corrida
+	spill  bool
+	items  []et.Json
+	runs   []*os.File
+}
+
+/**
+* newSorter: SORT_BUFFER_ROWS is the number of objects kept in memory
+* @param model *Model, orders []*OrderBy, keep int, spill bool
+* @return *sorter
+**/
+func newSorter(model *Model, orders []*OrderBy, keep int, spill bool) *sorter {
+	buffer := envar.GetInt("SORT_BUFFER_ROWS", 50000)
+	return &sorter{
+		model:  model,
+		orders: orders,
+		keep:   max(keep, 0),
+		buffer: max(buffer, 1),
+		spill:  spill,
+		items:  make([]et.Json, 0),
+	}
+}
+
+/**
+* sort: Sorts the objects in memory, stable so the ties keep the order of reading
+**/
+func (s *sorter) sort() {
+	slices.SortStableFunc(s.items, func(a, b et.Json) int {
+		return compareItems(s.model, a, b, s.orders)
+	})
+}
+
+/**
+* add
+* @param item et.Json
+* @return error
+**/
+func (s *sorter) add(item et.Json) error {
+	s.items = append(s.items, item)
+	if s.keep > 0 && len(s.items) >= 2*s.keep+s.buffer {
+		// Solo los primeros pueden quedar en el resultado
+		s.sort()
+		clear(s.items[s.keep:])
+		s.items = s.items[:s.keep]
+		return nil
+	}
+
+	if s.keep == 0 && s.spill && len(s.items) >= s.buffer {
+		return s.writeRun()
+	}
+
+	return nil
+}
+
+/**
+* writeRun: Writes the objects in memory sorted to a temporary file, gob keeps the types of the values
+* so the objects read back are the same as the sorted in memory, the store registers the types of the objects
+* @return error
+**/
+func (s *sorter) writeRun() error {
+	s.sort()
+	file, err := os.CreateTemp("", "josefina-sort-*")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, file)
+
+	writer := bufio.NewWriter(file)
+	encoder := gob.NewEncoder(writer)
+	for _, item := range s.items {
+		if err := encoder.Encode(&item); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	s.items = make([]et.Json, 0)
+	return nil
+}
+
+/**
+* each: Calls fn with the objects in order until it returns false
+* @param fn func(item et.Json) bool
+* @return error
+**/
+func (s *sorter) each(fn func(item et.Json) bool) error {
+	if len(s.runs) == 0 {
+		s.sort()
+		if s.keep > 0 && len(s.items) > s.keep {
+			s.items = s.items[:s.keep]
+		}
+		for _, item := range s.items {
+			if !fn(item) {
+				return nil
+			}
+		}
+		return nil
+	}
+
+	if len(s.items) > 0 {
+		if err := s.writeRun(); err != nil {
+			return err
+		}
+	}
+
+	// Mezcla de las corridas, cada una ya ordenada
+	merge := &runHeap{model: s.model, orders: s.orders}
+	for i, file := range s.runs {
+		run := &sortRun{index: i, decoder: gob.NewDecoder(bufio.NewReader(file))}
+		ok, err := run.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			merge.runs = append(merge.runs, run)
+		}
+	}
+	heap.Init(merge)
+
+	for merge.Len() > 0 {
+		run := merge.runs[0]
+		if !fn(run.item) {
+			return nil
+		}
+
+		ok, err := run.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(merge, 0)
+		} else {
+			heap.Pop(merge)
+		}
+	}
+
+	return nil
+}
+
+/**
+* close: Removes the temporary files
+**/
+func (s *sorter) close() {
+	for _, file := range s.runs {
+		file.Close()
+		os.Remove(file.Name())
+	}
+	s.runs = nil
+	s.items = nil
+}
+
+/**
+* sortRun: Reader of a sorted run
+**/
+type sortRun struct {
+	index   int // orden de la corrida
+	decoder *gob.Decoder
+	item    et.Json
+}
+
+/**
+* next
+* @return bool, error
+**/
+func (s *sortRun) next() (bool, error) {
+	item := et.Json{}
+	if err := s.decoder.Decode(&item); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+
+	s.item = item
+	return true, nil
+}
+
+/**
+* runHeap: Runs by their current object
+**/
+type runHeap struct {
+	model  *Model
+	orders []*OrderBy
+	runs   []*sortRun
+}
+
+/**
+* Len
+* @return int
+**/
+func (s *runHeap) Len() int {
+	return len(s.runs)
+}
+
+/**
+* Less: The ties are broken by run, the first ones were read before
+* @param i, j int
+* @return bool
+**/
+func (s *runHeap) Less(i, j int) bool {
+	result := compareItems(s.model, s.runs[i].item, s.runs[j].item, s.orders)
+	if result == 0 {
+		return s.runs[i].index < s.runs[j].index
+	}
+
+	return result < 0
+}
+
+/**
+* Swap
+* @param i, j int
+**/
+func (s *runHeap) Swap(i, j int) {
+	s.runs[i], s.runs[j] = s.runs[j], s.runs[i]
+}
+
+/**
+* Push
+* @param x any
+**/
+func (s *runHeap) Push(x any) {
+	s.runs = append(s.runs, x.(*sortRun))
+}
+
+/**
+* Pop
+* @return any
+**/
+func (s *runHeap) Pop() any {
+	n := len(s.runs)
+	result := s.runs[n-1]
+	s.runs = s.runs[:n-1]
+	return result
+}
@@ -0,0 +1,138 @@
+package dbs
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cgalvisleon/et/et"
+)
+
+func TestSorterSpillKeepsTypes(t *testing.T) {
+	t.Setenv("SORT_BUFFER_ROWS", "3")
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	orders := []*OrderBy{{Field: "at", Asc: true}, {Field: "n", Asc: false}}
+
+	spilled := newSorter(nil, orders, 0, true)
+	defer spilled.close()
+	memory := newSorter(nil, orders, 0, false)
+	defer memory.close()
+	for i := 0; i < 20; i++ {
+		// Los segundos sin fracción acortan el texto RFC3339Nano y lo desordenan como string
+		at := base.Add(time.Duration(i%7)*time.Second + time.Duration(i%2)*500*time.Millisecond)
+		for _, s := range []*sorter{spilled, memory} {
+			item := et.Json{"at": at, "n": i, "meta": et.Json{"i": int64(i)}}
+			if err := s.add(item); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if len(spilled.runs) == 0 {
+		t.Fatal("the sorter did not spill")
+	}
+
+	expected := []et.Json{}
+	memory.each(func(item et.Json) bool {
+		expected = append(expected, item)
+		return true
+	})
+
+	got := []et.Json{}
+	if err := spilled.each(func(item et.Json) bool {
+		got = append(got, item)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(expected) {
+		t.Fatalf("got %d objects, expected %d", len(got), len(expected))
+	}
+	for i := range got {
+		if _, ok := got[i]["at"].(time.Time); !ok {
+			t.Fatalf("time read back as %T", got[i]["at"])
+		}
+		if _, ok := got[i]["n"].(int); !ok {
+			t.Fatalf("int read back as %T", got[i]["n"])
+		}
+		if _, ok := got[i]["meta"].(et.Json)["i"].(int64); !ok {
+			t.Fatalf("nested int64 read back as %T", got[i]["meta"].(et.Json)["i"])
+		}
+		if compareItems(nil, got[i], expected[i], orders) != 0 || got[i]["n"] != expected[i]["n"] {
+			t.Fatalf("position %d: got %v, expected %v", i, got[i], expected[i])
+		}
+	}
+}
+
+/**
+* eventsModel: Returns a model of events with a datetime field, the position of each event in time is n
+* @param t *testing.T, times []time.Time
+* @return *Model
+**/
+func eventsModel(t *testing.T, times []time.Time) *Model {
+	t.Helper()
+	t.Setenv("DATA_PATH", t.TempDir())
+	db, err := GetDb("events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := db.NewModel("", t.Name(), false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.DefineAtrib("at", TpDateTime, nil)
+	model.DefineAtrib("n", TpInt, 0)
+	if err := model.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { model.data.Close() })
+
+	// Las claves no siguen el orden de los instantes
+	for i, at := range times {
+		if err := model.PutObject(fmt.Sprintf("e%d", len(times)-i), et.Json{"at": at, "n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return model
+}
+
+func TestRunOrdersDateTimesByInstant(t *testing.T) {
+	t.Setenv("SORT_BUFFER_ROWS", "2")
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	paris := time.FixedZone("paris", 60*60)
+	model := eventsModel(t, []time.Time{
+		// El instante más temprano aunque su texto local sea el mayor
+		base.In(paris),
+		base.Add(time.Second),
+		// Los segundos con fracción tienen un texto más largo
+		base.Add(1500 * time.Millisecond),
+		base.Add(2 * time.Second),
+		base.Add(2500 * time.Millisecond).In(paris),
+	})
+
+	// Sin límite las corridas se escriben a disco, con él solo se conservan las primeras
+	for _, rows := range []int{0, 3} {
+		query := model.Selects().Asc("at")
+		if rows > 0 {
+			query.Limit(1, rows)
+		}
+		items, err := query.Run(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rows == 0 {
+			rows = 5
+		}
+		if len(items) != rows {
+			t.Fatalf("got %d rows, expected %d", len(items), rows)
+		}
+		for i, item := range items {
+			if item.Int("n") != i {
+				t.Fatalf("limit %d: position %d has the event %v", rows, i, item)
+			}
+		}
+	}
+}
@@ -82,15 +82,16 @@ func (s *Dbs) RemoveObject(require et.Json, response *bool) error {
 
 /**
 * putObject
-* @params from *From, idx string, data et.Json
+* @params from *From, idx string, data et.Json, version uint64
 * @return error
 **/
-func (s *Dbs) putObject(from *From, idx string, data et.Json) error {
+func (s *Dbs) putObject(from *From, idx string, data et.Json, version uint64) error {
 	var response bool
 	err := jrpc.CallRpc(from.Host, "Dbs.PutObject", et.Json{
-		"from": from,
-		"idx":  idx,
-		"data": data,
+		"from":    from,
+		"idx":     idx,
+		"data":    data,
+		"version": int64(version),
 	}, &response)
 	if err != nil {
 		return err
@@ -108,11 +109,12 @@ func (s *Dbs) PutObject(require et.Json, response *bool) error {
 	from := ToFrom(require.Json("from"))
 	idx := require.Str("idx")
 	data := require.Json("data")
+	version := uint64(require.Int64("version"))
 	model, err := getModel(from)
 	if err != nil {
 		return err
 	}
-	err = model.PutObject(idx, data)
+	err = model.PutObjectIf(idx, data, version)
 	if err != nil {
 		return err
 	}
@@ -17,6 +17,7 @@ type Transaction struct {
 	Idx     string  `json:"idx"`
 	Data    et.Json `json:"data"`
 	Status  Status  `json:"status"`
+	Version uint64  `json:"version"`
 }
 
 /**
@@ -30,6 +31,7 @@ func (s *Transaction) toJson() et.Json {
 		"idx":     s.Idx,
 		"data":    s.Data,
 		"status":  s.Status,
+		"version": s.Version,
 	}
 }
 
@@ -126,7 +128,16 @@ func (s *Tx) change() error {
 * @param from *From, cmd Command, idx string, data et.Json
 **/
 func (s *Tx) addTransaction(from *From, cmd Command, idx string, data et.Json) error {
+	return s.addTransactionVersion(from, cmd, idx, data, 0)
+}
+
+/**
+* addTransactionVersion: Adds data to the Transaction, the commit fails if the record is no longer in the version
+* @param from *From, cmd Command, idx string, data et.Json, version uint64
+**/
+func (s *Tx) addTransactionVersion(from *From, cmd Command, idx string, data et.Json, version uint64) error {
 	transaction := newTransaction(from, cmd, idx, data, Pending)
+	transaction.Version = version
 	s.Transactions = append(s.Transactions, transaction)
 	return s.change()
 }
@@ -177,7 +188,7 @@ func (s *Tx) commit() error {
 			}
 		} else {
 			data := tr.Data
-			err := syn.putObject(tr.From, idx, data)
+			err := syn.putObject(tr.From, idx, data, tr.Version)
 			if err != nil {
 				return err
 			}
@@ -1,9 +1,9 @@
 package dbs
 
 import (
-	"encoding/json"
 	"errors"
 	"slices"
+	"sync"
 
 	"github.com/cgalvisleon/et/et"
 	"github.com/cgalvisleon/josefina/pkg/msg"
@@ -13,16 +13,18 @@ import (
 * Wheres
 **/
 type Wheres struct {
-	owner      *Model              `json:"-"`
-	selects    []string            `json:"-"`
-	hidden     []string            `json:"-"`
-	keys       map[string][]string `json:"-"`
-	asc        map[string]bool     `json:"-"`
-	offset     int                 `json:"-"`
-	limit      int                 `json:"-"`
-	conditions []*Condition        `json:"-"`
-	workers    int                 `json:"-"`
-	isDebug    bool                `json:"-"`
+	owner      *Model       `json:"-"`
+	selects    []string     `json:"-"`
+	hidden     []string     `json:"-"`
+	orders     []*OrderBy   `json:"-"`
+	offset     int          `json:"-"`
+	limit      int          `json:"-"`
+	conditions []*Condition `json:"-"`
+	groupBy    []string     `json:"-"`
+	aggregates []*Aggregate `json:"-"`
+	having     []*Condition `json:"-"`
+	workers    int          `json:"-"`
+	isDebug    bool         `json:"-"`
 }
 
 /**
@@ -34,11 +36,13 @@ func newWhere() *Wheres {
 	return &Wheres{
 		selects:    make([]string, 0),
 		hidden:     make([]string, 0),
-		keys:       make(map[string][]string, 0),
-		asc:        make(map[string]bool, 0),
+		orders:     make([]*OrderBy, 0),
 		offset:     0,
 		limit:      0,
 		conditions: make([]*Condition, 0),
+		groupBy:    make([]string, 0),
+		aggregates: make([]*Aggregate, 0),
+		having:     make([]*Condition, 0),
 		workers:    1,
 	}
 }
@@ -59,6 +63,53 @@ func ByJson(jsons []et.Json) *Wheres {
 	return result
 }
 
+/**
+* ByQuery: Returns the query of the json, {"select": ["status", {"sum": "amount", "as": "total"}], "where": [...],
+* "group_by": ["status"], "having": [...], "order_by": [{"field": "total", "asc": false}], "page": 1, "rows": 10}
+* @param query et.Json
+* @return *Wheres
+**/
+func ByQuery(query et.Json) *Wheres {
+	result := ByJson(query.ArrayJson("where"))
+	for _, item := range query.Array("select") {
+		switch v := item.(type) {
+		case string:
+			result.Selects(v)
+		case et.Json:
+			result.Aggregate(ToAggregate(v))
+		case map[string]interface{}:
+			result.Aggregate(ToAggregate(v))
+		}
+	}
+
+	result.GroupBy(query.ArrayStr("group_by")...)
+	for _, item := range query.ArrayJson("having") {
+		condition := ToCondition(item)
+		if condition != nil {
+			result.Having(condition)
+		}
+	}
+
+	for _, item := range query.Array("order_by") {
+		switch v := item.(type) {
+		case string:
+			result.Asc(v)
+		case et.Json:
+			result.orderBy(v.Str("field"), v.ValBool(true, "asc"))
+		case map[string]interface{}:
+			order := et.Json(v)
+			result.orderBy(order.Str("field"), order.ValBool(true, "asc"))
+		}
+	}
+
+	rows := query.Int("rows")
+	if rows > 0 {
+		result.Limit(max(query.Int("page"), 1), rows)
+	}
+
+	return result
+}
+
 /**
 * IsDebug: Returns the debug mode
 * @return *Wheres
@@ -99,6 +150,47 @@ func (s *Wheres) ToJson() []et.Json {
 	return result
 }
 
+/**
+* Query: Returns the query in the json format of ByQuery
+* @return et.Json
+**/
+func (s *Wheres) Query() et.Json {
+	selects := []any{}
+	for _, field := range s.selects {
+		selects = append(selects, field)
+	}
+	for _, aggregate := range s.aggregates {
+		selects = append(selects, aggregate.ToJson())
+	}
+
+	having := []et.Json{}
+	for _, condition := range s.having {
+		having = append(having, condition.ToJson())
+	}
+
+	orders := []et.Json{}
+	for _, order := range s.orders {
+		orders = append(orders, et.Json{
+			"field": order.Field,
+			"asc":   order.Asc,
+		})
+	}
+
+	result := et.Json{
+		"select":   selects,
+		"where":    s.ToJson(),
+		"group_by": s.groupBy,
+		"having":   having,
+		"order_by": orders,
+	}
+	if s.limit > 0 {
+		result["page"] = s.offset/s.limit + 1
+		result["rows"] = s.limit
+	}
+
+	return result
+}
+
 /**
 * Add
 * @param condition *Condition
@@ -142,6 +234,38 @@ func (s *Wheres) Or(condition *Condition) *Wheres {
 	return s.Add(condition)
 }
 
+/**
+* tree: Returns the conditions as a tree, nil without conditions
+* @return *Condition
+**/
+func (s *Wheres) tree() *Condition {
+	return conditionTree(s.conditions)
+}
+
+/**
+* conditionTree: Returns the conditions as a tree, and takes precedence over or, nil without conditions
+* @param conditions []*Condition
+* @return *Condition
+**/
+func conditionTree(conditions []*Condition) *Condition {
+	if len(conditions) == 0 {
+		return nil
+	}
+
+	terms := []*Condition{}
+	factors := []*Condition{}
+	for i, con := range conditions {
+		if i > 0 && con.Connector == Or {
+			terms = append(terms, Group(And, factors...))
+			factors = []*Condition{}
+		}
+		factors = append(factors, con)
+	}
+	terms = append(terms, Group(And, factors...))
+
+	return Group(Or, terms...)
+}
+
 /**
 * Selects
 * @param fields ...string
@@ -177,37 +301,91 @@ func (s *Wheres) Hidden(fields ...string) *Wheres {
 }
 
 /**
-* Asc
+* orderBy: Adds the field to the order or changes its direction
+* @param field string, asc bool
+* @return *Wheres
+**/
+func (s *Wheres) orderBy(field string, asc bool) *Wheres {
+	for _, order := range s.orders {
+		if order.Field == field {
+			order.Asc = asc
+			return s
+		}
+	}
+
+	s.orders = append(s.orders, &OrderBy{Field: field, Asc: asc})
+	return s
+}
+
+/**
+* Asc: Orders by the field or nested path, the orders are applied in the order they are added
 * @param field string
 * @return *Wheres
 **/
 func (s *Wheres) Asc(field string) *Wheres {
-	s.asc[field] = true
-	return s
+	return s.orderBy(field, true)
 }
 
 /**
-* Desc
+* Desc: Orders by the field or nested path descending
 * @param field string
 * @return *Wheres
 **/
 func (s *Wheres) Desc(field string) *Wheres {
-	s.asc[field] = false
-	return s
+	return s.orderBy(field, false)
 }
 
 /**
-* Order
+* Order: Returns the direction of the field, ascending when it is not in the order
 * @param field string
 * @return bool
 **/
 func (s *Wheres) Order(field string) bool {
-	result, exists := s.asc[field]
-	if !exists {
-		result = true
+	for _, order := range s.orders {
+		if order.Field == field {
+			return order.Asc
+		}
 	}
 
-	return result
+	return true
+}
+
+/**
+* ordered: Returns the direction to read the plan and if its objects come in the order of the query,
+* the primary keys give the order of the scan and a composite index the order of its fields after the equalities
+* @param plan *Plan
+* @return bool, bool
+**/
+func (s *Wheres) ordered(plan *Plan) (bool, bool) {
+	if len(s.orders) == 0 {
+		return s.Order(plan.Index), true
+	}
+
+	asc := s.orders[0].Asc
+	fields := make([]string, 0, len(s.orders))
+	for _, order := range s.orders {
+		if order.Asc != asc {
+			return asc, false
+		}
+		fields = append(fields, order.Field)
+	}
+
+	switch plan.Kind {
+	case PlanScan:
+		return asc, len(fields) == 1 && fields[0] == INDEX
+	case PlanKey:
+		return asc, plan.keys == nil && len(fields) == 1 && fields[0] == INDEX
+	case PlanComposite:
+		index := s.owner.Composites[plan.Index]
+		for i := 0; i <= plan.equals && i < len(index); i++ {
+			rest := index[i:]
+			if len(rest) >= len(fields) && slices.Equal(rest[:len(fields)], fields) {
+				return asc, true
+			}
+		}
+	}
+
+	return asc, false
 }
 
 /**
@@ -223,7 +401,92 @@ func (s *Wheres) Limit(page int, rows int) *Wheres {
 }
 
 /**
-* Run
+* GroupBy: Groups the objects by the fields or nested paths, the result has a row for each group
+* with the values of the fields and the aggregates
+* @param fields ...string
+* @return *Wheres
+**/
+func (s *Wheres) GroupBy(fields ...string) *Wheres {
+	for _, field := range fields {
+		if !slices.Contains(s.groupBy, field) {
+			s.groupBy = append(s.groupBy, field)
+		}
+	}
+
+	return s
+}
+
+/**
+* Aggregate: Adds the aggregates to the result, without fields to group they are calculated over all the objects
+* @param aggregates ...*Aggregate
+* @return *Wheres
+**/
+func (s *Wheres) Aggregate(aggregates ...*Aggregate) *Wheres {
+	for _, aggregate := range aggregates {
+		if aggregate != nil {
+			s.aggregates = append(s.aggregates, aggregate)
+		}
+	}
+
+	return s
+}
+
+/**
+* Having: Filters the groups, the condition uses the names of the fields and of the aggregates in the result
+* @param condition *Condition
+* @return *Wheres
+**/
+func (s *Wheres) Having(condition *Condition) *Wheres {
+	if len(s.having) > 0 && condition.Connector == NaC {
+		condition.Connector = And
+	}
+
+	s.having = append(s.having, condition)
+	return s
+}
+
+/**
+* isGrouped: Returns if the result are groups
+* @return bool
+**/
+func (s *Wheres) isGrouped() bool {
+	return len(s.groupBy) > 0 || len(s.aggregates) > 0
+}
+
+/**
+* groups: Returns the rows of the groups that pass the having, in the order of the query
+* @param grouped *grouper
+* @return []et.Json
+**/
+func (s *Wheres) groups(grouped *grouper) []et.Json {
+	having := conditionTree(s.having)
+	result := []et.Json{}
+	for _, row := range grouped.rows() {
+		if having != nil && !having.Eval(row) {
+			continue
+		}
+		result = append(result, row)
+	}
+
+	if len(s.orders) > 0 {
+		slices.SortStableFunc(result, func(a, b et.Json) int {
+			return compareItems(s.owner, a, b, s.orders)
+		})
+	}
+
+	offset := min(max(s.offset, 0), len(result))
+	result = result[offset:]
+	if s.limit > 0 && len(result) > s.limit {
+		result = result[:s.limit]
+	}
+
+	return result
+}
+
+/**
+* Run: Runs the query by the plan of the planner, without an order the offset and the limit are applied while
+* the objects are read, with it the objects are sorted unless the plan reads them in the order, with groups
+* the offset, the limit and the order apply to the groups
 * @param tx *Tx
 * @return []et.Json, error
 **/
@@ -235,40 +498,20 @@ func (s *Wheres) Run(tx *Tx) ([]et.Json, error) {
 		return nil, errors.New(msg.MSG_MODEL_NOT_FOUND)
 	}
 
+	skip := s.offset
 	addResult := func(item et.Json) bool {
+		if skip > 0 {
+			skip--
+			return true
+		}
+
 		if len(s.selects) == 0 {
 			item = Hidden(s.hidden, item)
 		} else {
 			item = Select(s.selects, item)
 		}
 		result = append(result, item)
-		n := len(result)
-		return n < s.limit
-	}
-
-	validateItem := func(item et.Json, conditions []*Condition) bool {
-		next := true
-		var ok bool
-		for i, con := range conditions {
-			tmp := con.ApplyToData(item)
-			if i == 0 {
-				ok = tmp
-			} else if con.Connector == And {
-				ok = ok && tmp
-			} else if con.Connector == Or {
-				ok = ok || tmp
-			}
-
-			if !ok {
-				break
-			}
-		}
-
-		if ok {
-			next = addResult(item)
-		}
-
-		return next
+		return s.limit <= 0 || len(result) < s.limit
 	}
 
 	st, err := model.Source()
@@ -276,153 +519,150 @@ func (s *Wheres) Run(tx *Tx) ([]et.Json, error) {
 		return nil, err
 	}
 
-	if len(s.conditions) == 0 {
-		// Items by data
-		next := true
-		asc := s.Order(INDEX)
-		err = st.Iterate(func(id string, src []byte) (bool, error) {
-			item := et.Json{}
-			err := json.Unmarshal(src, &item)
-			if err != nil {
-				return false, err
-			}
-
-			next = addResult(item)
-			return next, nil
-		}, asc, s.offset, s.limit, s.workers)
-		if err != nil {
+	root := s.tree()
+	if root != nil {
+		if err := root.resolve(tx); err != nil {
 			return nil, err
 		}
+	}
 
-		if !next {
-			return result, nil
-		}
+	planner, err := newPlanner(model)
+	if err != nil {
+		return nil, err
+	}
 
-		// Items by cache
-		cache := tx.getRecors(model.From)
-		for _, item := range cache {
-			next = addResult(item)
-			if !next {
-				return result, nil
-			}
+	plan := planner.plan(root)
+	cache := tx.getRecors(model.From)
+	asc, ordered := s.ordered(plan)
+	var (
+		sorted  *sorter
+		grouped *grouper
+	)
+	if s.isGrouped() {
+		// Los grupos se calculan mientras se leen los objetos, el orden es el de los grupos
+		grouped = newGrouper(model, s.groupBy, s.aggregates)
+		asc = true
+	} else if !ordered || (len(s.orders) > 0 && len(cache) > 0) {
+		// Solo se conservan los objetos que pueden llegar al resultado, los modelos efímeros no escriben corridas
+		keep := 0
+		if s.limit > 0 {
+			keep = s.offset + s.limit
 		}
-
-		return result, nil
+		sorted = newSorter(model, s.orders, keep, !model.IsEphemeral)
+		defer sorted.close()
 	}
 
-	onlyKeys := true
-	for _, con := range s.conditions {
-		value := con.Value
-		switch v := value.(type) {
-		case *Wheres:
-			var err error
-			con.Value, err = v.Run(tx)
-			if err != nil {
-				return nil, err
-			}
-		case Wheres:
-			var err error
-			con.Value, err = v.Run(tx)
-			if err != nil {
-				return nil, err
-			}
+	var sortErr error
+	validateItem := func(item et.Json) bool {
+		if root != nil && !root.Eval(item) {
+			return true
 		}
 
-		field := con.Field
-		index, ok := model.stores[field]
-		if !ok {
-			onlyKeys = false
-			continue
+		if grouped != nil {
+			grouped.add(item)
+			return true
 		}
 
-		keys, ok := s.keys[field]
-		if !ok {
-			asc := s.Order(field)
-			keys = index.Keys(asc, 0, 0)
+		if sorted != nil {
+			sortErr = sorted.add(item)
+			return sortErr == nil
 		}
 
-		s.keys[field] = con.ApplyToIndex(keys)
+		return addResult(item)
 	}
 
-	// Items by keys
-	items := []et.Json{}
-	addItem := func(item et.Json) {
-		index, ok := item[INDEX]
-		if !ok {
-			return
-		}
-
-		if index == "" {
-			return
-		}
-
-		idx := slices.IndexFunc(items, func(v et.Json) bool { return v[INDEX] == index })
-		if idx == -1 {
-			items = append(items, item)
+	// Las claves leídas solo se guardan para descartar los objetos repetidos de la transacción
+	seen := map[string]bool{}
+	mark := func(idx string) {
+		if len(cache) > 0 {
+			seen[idx] = true
 		}
 	}
+	// Los workers leen en paralelo, el orden de lectura solo se conserva con uno
+	var mu sync.Mutex
+	workers := s.workers
+	if len(s.orders) > 0 && sorted == nil && grouped == nil {
+		workers = 1
+	}
+	next := true
+	switch {
+	case root == nil && sorted == nil && grouped == nil:
+		// Items by data, el offset y el límite los aplica la iteración
+		skip = 0
+		err = st.IterateObjects(func(id string, item et.Json) (bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if !next {
+				return false, nil
+			}
+
+			mark(id)
+			next = addResult(item)
+			return next, nil
+		}, asc, s.offset, s.limit, workers)
+	case plan.Kind == PlanScan:
+		// Items by data
+		err = st.IterateObjects(func(id string, item et.Json) (bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if !next {
+				return false, nil
+			}
 
-	for field, keys := range s.keys {
-		for _, key := range keys {
-			indexes := map[string]bool{}
-			exists, err := model.GetIndex(field, key, indexes)
+			mark(id)
+			next = validateItem(item)
+			return next, nil
+		}, asc, 0, 0, workers)
+	default:
+		// Items by keys, el índice deja de leerse cuando se completa el límite
+		err = planIds(model, plan, asc, func(idx string) (bool, error) {
+			item := et.Json{}
+			exists, err := model.GetObjet(idx, item)
 			if err != nil {
-				return nil, err
+				return false, err
 			}
 			if !exists {
-				continue
+				return true, nil
 			}
 
-			for idx := range indexes {
-				item := et.Json{}
-				exists, err = model.GetObjet(idx, item)
-				if err != nil {
-					return nil, err
-				}
-
-				if exists {
-					addItem(item)
-				}
-			}
-		}
+			mark(idx)
+			next = validateItem(item)
+			return next, nil
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sortErr != nil {
+		return nil, sortErr
 	}
 
 	// Items by cache
-	cache := tx.getRecors(model.From)
 	for _, item := range cache {
-		addItem(item)
-	}
-
-	next := true
-	for _, item := range items {
-		next = validateItem(item, s.conditions)
 		if !next {
-			return result, nil
+			break
 		}
-	}
-
-	if onlyKeys {
-		return result, nil
-	}
 
-	// Items by data
-	asc := s.Order(INDEX)
-	err = st.Iterate(func(id string, src []byte) (bool, error) {
-		item := et.Json{}
-		err := json.Unmarshal(src, &item)
-		if err != nil {
-			return false, err
+		idx, ok := item[INDEX].(string)
+		if !ok || idx == "" || seen[idx] {
+			continue
 		}
 
-		next = validateItem(item, s.conditions)
-		return next, nil
-	}, asc, s.offset, s.limit, s.workers)
-	if err != nil {
-		return nil, err
+		seen[idx] = true
+		next = validateItem(item)
+	}
+	if sortErr != nil {
+		return nil, sortErr
+	}
+
+	if grouped != nil {
+		return s.groups(grouped), nil
 	}
 
-	if !next {
-		return result, nil
+	if sorted != nil {
+		if err := sorted.each(addResult); err != nil {
+			return nil, err
+		}
 	}
 
 	return result, nil
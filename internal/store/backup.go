@@ -0,0 +1,349 @@
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cgalvisleon/et/et"
+	"github.com/cgalvisleon/et/logs"
+	"github.com/cgalvisleon/josefina/pkg/msg"
+)
+
+const backupMetadata = "metadata.json"
+
+type backupSegment struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+type backupMeta struct {
+	Name      string          `json:"name"`
+	Seq       uint64          `json:"seq"`
+	WAL       uint64          `json:"wal"`
+	Count     int             `json:"count"`
+	CreatedAt time.Time       `json:"created_at"`
+	Segments  []backupSegment `json:"segments"`
+}
+
+/**
+* ToJson
+* @return et.Json
+**/
+func (s *backupMeta) ToJson() et.Json {
+	bt, err := json.Marshal(s)
+	if err != nil {
+		return et.Json{}
+	}
+
+	result := et.Json{}
+	json.Unmarshal(bt, &result)
+	return result
+}
+
+/**
+* writeTarFile
+* @param tw *tar.Writer, name string, size int64, r io.Reader
+* @return error
+**/
+func writeTarFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err := io.CopyN(tw, r, size)
+	return err
+}
+
+/**
+* Backup: Writes a consistent tar archive of the store while the writes continue
+* @param w io.Writer
+* @return error
+**/
+func (s *FileStore) Backup(w io.Writer) error {
+	// Captura: con writeMu no hay registros a medio escribir
+	s.writeMu.Lock()
+	if s.isClosed() {
+		s.writeMu.Unlock()
+		return errors.New(msg.MSG_STORE_CLOSED)
+	}
+
+	meta := &backupMeta{
+		Name:      s.Name,
+		Seq:       s.seq.Load(),
+		WAL:       s.WAL,
+		Count:     s.Count(),
+		CreatedAt: time.Now(),
+	}
+	segments := append([]*segment{}, s.segments...)
+	for _, seg := range segments {
+		meta.Segments = append(meta.Segments, backupSegment{Name: seg.name, Size: seg.size})
+	}
+	state := s.captureSnapshot()
+
+	// Los blobs de los registros capturados ya son durables
+	blobs, err := s.blobFiles()
+	if err != nil {
+		s.writeMu.Unlock()
+		return err
+	}
+
+	// La compactación y la recolección de blobs esperan a que termine la copia
+	s.pins.Add(1)
+	defer s.pins.Add(-1)
+	s.writeMu.Unlock()
+
+	tw := tar.NewWriter(w)
+	bt, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, backupMetadata, int64(len(bt)), strings.NewReader(string(bt))); err != nil {
+		return err
+	}
+
+	// Los segmentos solo crecen, se copia hasta el tamaño capturado
+	for i, seg := range segments {
+		size := meta.Segments[i].Size
+		name := filepath.ToSlash(filepath.Join("segments", seg.name))
+		if err := writeTarFile(tw, name, size, io.NewSectionReader(seg.file, 0, size)); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range blobs {
+		if !strings.HasSuffix(name, blobExt) {
+			continue
+		}
+
+		path := filepath.Join(s.PathBlobs, name)
+		data, err := readFile(s.fs, path)
+		if err != nil && !s.fs.Exists(path) {
+			continue // huérfano recolectado durante la copia, ningún segmento capturado lo referencia
+		}
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(filepath.Join("blobs", name))
+		if err := writeTarFile(tw, name, int64(len(data)), bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+
+	snapshot, err := s.sealSnapshot(encodeSnapshot(state))
+	if err != nil {
+		return err
+	}
+	name := filepath.ToSlash(filepath.Join("snapshot", fmt.Sprintf("state-%s.snap", s.Name)))
+	if err := writeTarFile(tw, name, int64(len(snapshot)), strings.NewReader(string(snapshot))); err != nil {
+		return err
+	}
+
+	if s.isDebug {
+		logs.Debug("backup:", s.Path, ":", s.Name, ":seq:", meta.Seq, ":segments:", len(segments))
+	}
+
+	return tw.Close()
+}
+
+/**
+* Restore: Rebuilds the store directory from a backup
+* @param r io.Reader, path, name string
+* @return error
+**/
+func Restore(r io.Reader, path, name string) error {
+	return RestoreTo(r, path, name, 0, time.Time{})
+}
+
+/**
+* RestoreTo: Rebuilds the store directory from a backup up to the sequence or the time, zero values are unbounded
+* @param r io.Reader, path, name string, untilSeq uint64, until time.Time
+* @return error
+**/
+func RestoreTo(r io.Reader, path, name string, untilSeq uint64, until time.Time) error {
+	return RestoreToFS(OSFS, r, path, name, untilSeq, until)
+}
+
+/**
+* RestoreToFS: Rebuilds the store directory over the given filesystem
+* @param fsys FS, r io.Reader, path, name string, untilSeq uint64, until time.Time
+* @return error
+**/
+func RestoreToFS(fsys FS, r io.Reader, path, name string, untilSeq uint64, until time.Time) error {
+	target := filepath.Join(path, name)
+	if fsys.Exists(target) {
+		return fmt.Errorf(msg.MSG_STORE_EXISTS, target)
+	}
+
+	tmp := target + ".restore"
+	fsys.RemoveAll(tmp)
+	if err := extractBackup(fsys, r, tmp); err != nil {
+		fsys.RemoveAll(tmp)
+		return err
+	}
+
+	if untilSeq > 0 || !until.IsZero() {
+		if err := truncateLog(fsys, filepath.Join(tmp, "segments"), untilSeq, until); err != nil {
+			fsys.RemoveAll(tmp)
+			return err
+		}
+
+		// El snapshot no corresponde al log recortado, se reconstruye al abrir
+		fsys.RemoveAll(filepath.Join(tmp, "snapshot"))
+	}
+
+	if err := fsys.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	if err := fsys.Rename(tmp, target); err != nil {
+		return err
+	}
+
+	// Abrir valida el log y deja un snapshot nuevo
+	st, err := OpenFS(fsys, path, name, false)
+	if err != nil {
+		return err
+	}
+
+	return st.Close()
+}
+
+/**
+* extractBackup
+* @param fsys FS, r io.Reader, dir string
+* @return error
+**/
+func extractBackup(fsys FS, r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	found := false
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		if filepath.IsAbs(name) || strings.HasPrefix(name, "..") {
+			return fmt.Errorf(msg.MSG_INVALID_BACKUP_ENTRY, header.Name)
+		}
+
+		if name == backupMetadata {
+			found = true
+		}
+
+		path := filepath.Join(dir, name)
+		if err := fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		if err := writeFile(fsys, path, data); err != nil {
+			return err
+		}
+	}
+
+	if !found {
+		return fmt.Errorf(msg.MSG_INVALID_BACKUP_ENTRY, backupMetadata)
+	}
+
+	return fsys.MkdirAll(filepath.Join(dir, "segments"), 0755)
+}
+
+/**
+* truncateLog: Cuts the log at the first mutation after the sequence or the time
+* @param fsys FS, dir string, untilSeq uint64, until time.Time
+* @return error
+**/
+func truncateLog(fsys FS, dir string, untilSeq uint64, until time.Time) error {
+	files, err := fsys.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	after := func(h recordHeader) bool {
+		if untilSeq > 0 && h.Seq > untilSeq {
+			return true
+		}
+		return !until.IsZero() && h.Timestamp > until.UnixNano()
+	}
+
+	cut := false
+	for _, name := range files {
+		path := filepath.Join(dir, name)
+		if cut {
+			if err := fsys.Remove(path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		seg, err := openSegment(fsys, path, name, 0)
+		if err != nil {
+			return err
+		}
+
+		var (
+			begin  int64 = -1
+			offset int64 = -1
+			failed error
+		)
+		seg.scan(seg.size, func(h recordHeader, data []byte, at int64) {
+			if offset >= 0 || failed != nil {
+				return
+			}
+
+			switch h.Status {
+			case BatchBegin:
+				begin = at
+				return
+			case BatchCommit:
+				begin = -1
+				return
+			}
+
+			if !after(h) {
+				return
+			}
+
+			// Los registros compactados no conservan el orden en el tiempo
+			if seg.version < segmentV3 || h.Seq <= seg.baseSeq {
+				failed = errors.New(msg.MSG_SEQ_COMPACTED)
+				return
+			}
+
+			offset = at
+			if begin >= 0 {
+				offset = begin // el batch se descarta completo
+			}
+		})
+
+		if failed == nil && offset >= 0 {
+			failed = seg.file.Truncate(offset)
+			cut = true
+		}
+		seg.Close()
+		if failed != nil {
+			return failed
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,238 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cgalvisleon/josefina/pkg/msg"
+)
+
+/**
+* restored: Restores the backup up to the sequence or the time and opens the restored store
+* @param t *testing.T, backup []byte, untilSeq uint64, until time.Time
+* @return *FileStore, error
+**/
+func restored(t *testing.T, backup []byte, untilSeq uint64, until time.Time) (*FileStore, error) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := RestoreTo(bytes.NewReader(backup), dir, "restored", untilSeq, until); err != nil {
+		return nil, err
+	}
+
+	result, err := Open(dir, "restored", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { result.Close() })
+
+	return result, nil
+}
+
+/**
+* backupOf
+* @param t *testing.T, fs *FileStore
+* @return []byte
+**/
+func backupOf(t *testing.T, fs *FileStore) []byte {
+	t.Helper()
+	buf := bytes.NewBuffer(nil)
+	if err := fs.Backup(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestBackupDuringWrites(t *testing.T) {
+	fs, err := Open(t.TempDir(), "backup", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	// Cada batch escribe un par de claves que se restauran juntas o no se restauran
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			batch := NewWriteBatch()
+			batch.Put(fmt.Sprintf("x%06d", i), i)
+			batch.Put(fmt.Sprintf("y%06d", i), i)
+			if err := fs.Write(batch); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for fs.Count() < 200 {
+		time.Sleep(time.Millisecond)
+	}
+	backup := backupOf(t, fs)
+	close(stop)
+	wg.Wait()
+
+	st, err := restored(t, backup, 0, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	xs := st.KeysPrefix("x", true, 0, 0)
+	ys := st.KeysPrefix("y", true, 0, 0)
+	if len(xs) < 100 || len(xs) != len(ys) || st.Count() != 2*len(xs) {
+		t.Fatalf("%d x and %d y restored", len(xs), len(ys))
+	}
+	for i, key := range xs {
+		if key != fmt.Sprintf("x%06d", i) || ys[i] != fmt.Sprintf("y%06d", i) {
+			t.Fatalf("hole in the restored writes at %d: %s %s", i, key, ys[i])
+		}
+
+		var value int
+		if exists, err := st.Get(key, &value); err != nil || !exists || value != i {
+			t.Fatalf("%s restored as %d %v %v", key, value, exists, err)
+		}
+	}
+	if st.Seq() != uint64(st.Count()) {
+		t.Fatalf("sequence %d with %d records", st.Seq(), st.Count())
+	}
+}
+
+func TestRestoreToSeqAndTime(t *testing.T) {
+	fs, err := Open(t.TempDir(), "backup", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	fs.Put("a", 1)
+	fs.Put("b", 2)
+	time.Sleep(2 * time.Millisecond)
+	at := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	fs.Put("a", 3)
+	fs.Delete("b")
+	fs.Put("c", 4)
+	backup := backupOf(t, fs)
+
+	for _, c := range []struct {
+		name     string
+		untilSeq uint64
+		until    time.Time
+		expected map[string]int
+	}{
+		{"seq", 2, time.Time{}, map[string]int{"a": 1, "b": 2}},
+		{"seq after the delete", 4, time.Time{}, map[string]int{"a": 3}},
+		{"time", 0, at, map[string]int{"a": 1, "b": 2}},
+		{"all", 0, time.Time{}, map[string]int{"a": 3, "c": 4}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			st, err := restored(t, backup, c.untilSeq, c.until)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys := st.Keys(true, 0, 0)
+			if len(keys) != len(c.expected) {
+				t.Fatalf("keys %v", keys)
+			}
+			for id, expected := range c.expected {
+				var value int
+				if exists, err := st.Get(id, &value); err != nil || !exists || value != expected {
+					t.Fatalf("%s restored as %d %v %v", id, value, exists, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreDropsSplitBatch(t *testing.T) {
+	fs, err := Open(t.TempDir(), "backup", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	fs.Put("a", 1)
+	batch := NewWriteBatch()
+	batch.Put("b", 2)
+	batch.Put("c", 3)
+	batch.Put("d", 4)
+	if err := fs.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	fs.Put("e", 5)
+	backup := backupOf(t, fs)
+
+	// El corte cae dentro del batch, se descarta completo
+	st, err := restored(t, backup, 2, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := st.Keys(true, 0, 0); !slices.Equal(keys, []string{"a"}) {
+		t.Fatalf("batch split by the restore: %v", keys)
+	}
+
+	st, err = restored(t, backup, 4, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := st.Keys(true, 0, 0); !slices.Equal(keys, []string{"a", "b", "c", "d"}) {
+		t.Fatalf("batch lost by the restore: %v", keys)
+	}
+}
+
+func TestRestoreCompactedLog(t *testing.T) {
+	fs, err := Open(t.TempDir(), "backup", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	fs.Put("a", 1)
+	fs.Put("b", 2)
+	fs.Put("a", 3)
+	if err := fs.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	fs.Put("c", 4)
+	backup := backupOf(t, fs)
+
+	// Antes del horizonte de la compactación el orden de las mutaciones se perdió
+	if _, err := restored(t, backup, 2, time.Time{}); err == nil || err.Error() != msg.MSG_SEQ_COMPACTED {
+		t.Fatalf("restored before the compaction: %v", err)
+	}
+
+	st, err := restored(t, backup, 3, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := st.Keys(true, 0, 0); !slices.Equal(keys, []string{"a", "b"}) {
+		t.Fatalf("keys at the compaction %v", keys)
+	}
+}
+
+func TestRestoreExistingStore(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := Open(dir, "backup", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	fs.Put("a", 1)
+	if err := Restore(bytes.NewReader(backupOf(t, fs)), dir, "backup"); err == nil {
+		t.Fatal("backup restored over a store")
+	}
+}
@@ -0,0 +1,263 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cgalvisleon/et/logs"
+	"github.com/cgalvisleon/josefina/pkg/msg"
+)
+
+const batchMarker = "\x00batch" // id de los registros que delimitan un batch
+
+type batchOp struct {
+	id       string
+	value    any
+	status   byte
+	check    bool
+	expected uint64
+	expires  int64
+}
+
+/**
+* WriteBatch: Group of puts and deletes that is written to the log as one atomic unit
+**/
+type WriteBatch struct {
+	ops []*batchOp
+}
+
+/**
+* NewWriteBatch
+* @return *WriteBatch
+**/
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{
+		ops: make([]*batchOp, 0),
+	}
+}
+
+/**
+* Put: The value is encoded with the codec of the store when the batch is written
+* @param id string, value any
+* @return error
+**/
+func (s *WriteBatch) Put(id string, value any) error {
+	if id == "" {
+		return errors.New(msg.MSG_ID_IS_REQUIRED)
+	}
+
+	s.ops = append(s.ops, &batchOp{id: id, value: value, status: Active})
+	return nil
+}
+
+/**
+* PutIf: Adds a put that is applied only if the version of the key is expected, 0 when the key must not exist
+* @param id string, value any, expected uint64
+* @return error
+**/
+func (s *WriteBatch) PutIf(id string, value any, expected uint64) error {
+	if err := s.Put(id, value); err != nil {
+		return err
+	}
+
+	op := s.ops[len(s.ops)-1]
+	op.check, op.expected = true, expected
+	return nil
+}
+
+/**
+* PutWithTTL: Adds a put that stops being visible after ttl
+* @param id string, value any, ttl time.Duration
+* @return error
+**/
+func (s *WriteBatch) PutWithTTL(id string, value any, ttl time.Duration) error {
+	if ttl <= 0 {
+		return errors.New(msg.MSG_INVALID_TTL)
+	}
+
+	if err := s.Put(id, value); err != nil {
+		return err
+	}
+
+	s.ops[len(s.ops)-1].expires = time.Now().Add(ttl).UnixNano()
+	return nil
+}
+
+/**
+* PutIfWithTTL: Adds a put with expiration that is applied only if the version of the key is expected
+* @param id string, value any, expected uint64, ttl time.Duration
+* @return error
+**/
+func (s *WriteBatch) PutIfWithTTL(id string, value any, expected uint64, ttl time.Duration) error {
+	if err := s.PutWithTTL(id, value, ttl); err != nil {
+		return err
+	}
+
+	op := s.ops[len(s.ops)-1]
+	op.check, op.expected = true, expected
+	return nil
+}
+
+/**
+* Delete
+* @param id string
+**/
+func (s *WriteBatch) Delete(id string) {
+	s.ops = append(s.ops, &batchOp{id: id, status: Deleted})
+}
+
+/**
+* DeleteIf: Adds a delete that is applied only if the version of the key is expected
+* @param id string, expected uint64
+**/
+func (s *WriteBatch) DeleteIf(id string, expected uint64) {
+	s.ops = append(s.ops, &batchOp{id: id, status: Deleted, check: true, expected: expected})
+}
+
+/**
+* Len
+* @return int
+**/
+func (s *WriteBatch) Len() int {
+	return len(s.ops)
+}
+
+/**
+* Reset
+**/
+func (s *WriteBatch) Reset() {
+	s.ops = s.ops[:0]
+}
+
+/**
+* newBatchMarker: Begin and commit carry the number of operations of the batch
+* @param status byte, count int
+* @return *logRecord
+**/
+func newBatchMarker(status byte, count int) *logRecord {
+	data := make([]byte, 4)
+	putUint32(data, uint32(count))
+	return newLogRecord(batchMarker, data, status, CompressNone)
+}
+
+/**
+* Write: Writes the batch, after a crash the recovery applies all its operations or none
+* @param batch *WriteBatch
+* @return error
+**/
+func (s *FileStore) Write(batch *WriteBatch) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+
+	if batch == nil || batch.Len() == 0 {
+		return nil
+	}
+
+	s.blobMu.RLock()
+	defer s.blobMu.RUnlock()
+
+	values := make([][]byte, batch.Len())
+	records := make([]*logRecord, 0, batch.Len()+2)
+	records = append(records, newBatchMarker(BatchBegin, batch.Len()))
+	for i, op := range batch.ops {
+		var rec *logRecord
+		if op.status == Deleted {
+			rec = newLogRecord(op.id, nil, Deleted, CompressNone)
+		} else {
+			data, stored, flags, err := s.encode(op.id, op.value, op.expires)
+			if err != nil {
+				return err
+			}
+			values[i] = data
+			rec = newLogRecord(op.id, stored, Active, flags)
+		}
+		rec.check, rec.expected, rec.expires = op.check, op.expected, op.expires
+		records = append(records, rec)
+	}
+	records = append(records, newBatchMarker(BatchCommit, batch.Len()))
+
+	if _, err := s.commit(records...); err != nil {
+		return err
+	}
+
+	for i, op := range batch.ops {
+		if op.status == Deleted {
+			for _, fn := range s.onDelete {
+				fn(op.id)
+			}
+			continue
+		}
+
+		s.firePut(op.id, values[i], records[i+1].flags)
+	}
+
+	if s.isDebug {
+		logs.Debug("write:", s.Path, ":", s.Name, ":ops:", batch.Len())
+	}
+
+	return nil
+}
+
+type replayRecord struct {
+	header recordHeader
+	offset int64
+}
+
+/**
+* replay: Walks the records of the segment grouping the batches, a batch without commit is reported as corrupt
+* @param limit int64, fn func(h recordHeader, offset int64)
+* @return []*CorruptRange
+**/
+func (s *segment) replay(limit int64, fn func(h recordHeader, offset int64)) []*CorruptRange {
+	return s.replayFrom(s.start(), limit, fn)
+}
+
+/**
+* replayFrom: Replays the records from start, start must be outside of a batch
+* @param start, limit int64, fn func(h recordHeader, offset int64)
+* @return []*CorruptRange
+**/
+func (s *segment) replayFrom(start, limit int64, fn func(h recordHeader, offset int64)) []*CorruptRange {
+	var (
+		pending []replayRecord
+		begin   int64
+		expect  int
+		inBatch bool
+	)
+
+	result := s.scanFrom(start, limit, func(h recordHeader, data []byte, offset int64) {
+		switch h.Status {
+		case BatchBegin:
+			pending = pending[:0]
+			begin = offset
+			expect = int(getUint32(data))
+			inBatch = true
+		case BatchCommit:
+			if inBatch && len(pending) == expect {
+				for _, rec := range pending {
+					fn(rec.header, rec.offset)
+				}
+			}
+			pending = pending[:0]
+			inBatch = false
+		default:
+			if !inBatch {
+				fn(h, offset)
+				return
+			}
+			pending = append(pending, replayRecord{header: h, offset: offset})
+		}
+	})
+
+	if inBatch {
+		result = append(result, &CorruptRange{
+			Segment: s.name,
+			Start:   begin,
+			End:     limit,
+			Reason:  msg.MSG_INCOMPLETE_BATCH,
+		})
+	}
+
+	return result
+}
@@ -0,0 +1,314 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+
+	"github.com/cgalvisleon/et/logs"
+	"github.com/cgalvisleon/josefina/pkg/msg"
+)
+
+const (
+	flagBlob        byte = 0x08   // los datos son el puntero a un blob
+	blobMagic            = "JBLB" // cabecera de los archivos de blobs
+	blobHeaderSize       = 8      // magic y CRC
+	blobHashSize         = sha256.Size
+	blobPointerSize      = blobHashSize + 8 // hash y tamaño del contenido
+	blobExt              = ".blob"
+)
+
+/**
+* blobName: Returns the file name of the blob, its content address
+* @param hash []byte
+* @return string
+**/
+func blobName(hash []byte) string {
+	return hex.EncodeToString(hash) + blobExt
+}
+
+/**
+* blobPath
+* @param hash []byte
+* @return string
+**/
+func (s *FileStore) blobPath(hash []byte) string {
+	return filepath.Join(s.PathBlobs, blobName(hash))
+}
+
+/**
+* separate: Moves the stored data to a blob when the value is above the threshold, returns the pointer
+* @param raw, stored []byte, flags byte
+* @return []byte, byte, error
+**/
+func (s *FileStore) separate(raw, stored []byte, flags byte) ([]byte, byte, error) {
+	if s.BlobThreshold <= 0 || int64(len(raw)) <= s.BlobThreshold {
+		return stored, flags, nil
+	}
+
+	hash := sha256.Sum256(stored)
+	path := s.blobPath(hash[:])
+
+	// Mismo contenido, mismo archivo
+	if !s.fs.Exists(path) {
+		content := make([]byte, blobHeaderSize+len(stored))
+		copy(content[0:4], blobMagic)
+		putUint32(content[4:8], checksum(stored))
+		copy(content[blobHeaderSize:], stored)
+
+		tmp := path + ".tmp"
+		if err := writeFile(s.fs, tmp, content); err != nil {
+			return nil, 0, err
+		}
+		if err := s.fs.Rename(tmp, path); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	pointer := make([]byte, blobPointerSize)
+	copy(pointer[0:blobHashSize], hash[:])
+	putUint64(pointer[blobHashSize:], uint64(len(stored)))
+	return pointer, flags | flagBlob, nil
+}
+
+/**
+* encodeValue: Compresses and encrypts the value, separates it to a blob when it is large and prefixes the
+* expiration date, flags carries the codec, the caller holds blobMu until the record is durable
+* @param id string, data []byte, flags byte, expires int64
+* @return []byte, byte, error
+**/
+func (s *FileStore) encodeValue(id string, data []byte, flags byte, expires int64) ([]byte, byte, error) {
+	stored, compressed := compress(data, s.compression)
+	flags |= compressed
+	stored, flags, err := s.seal(stored, flags, recordAAD(id, flags, expires))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	stored, flags, err = s.separate(data, stored, flags)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	stored, flags = withExpiry(stored, flags, expires)
+	return stored, flags, nil
+}
+
+/**
+* readBlob: Reads the content of the blob of the pointer and validates it
+* @param pointer []byte
+* @return []byte, error
+**/
+func (s *FileStore) readBlob(pointer []byte) ([]byte, error) {
+	if len(pointer) != blobPointerSize {
+		return nil, errors.New(msg.MSG_CORRUPTED_RECORD)
+	}
+
+	content, err := readFile(s.fs, s.blobPath(pointer[0:blobHashSize]))
+	if err != nil {
+		return nil, err
+	}
+
+	size := getUint64(pointer[blobHashSize:])
+	if len(content) < blobHeaderSize || string(content[0:4]) != blobMagic || uint64(len(content)-blobHeaderSize) != size {
+		return nil, errors.New(msg.MSG_CORRUPTED_BLOB)
+	}
+
+	data := content[blobHeaderSize:]
+	if checksum(data) != getUint32(content[4:8]) {
+		return nil, errors.New(msg.MSG_CORRUPTED_BLOB)
+	}
+
+	return data, nil
+}
+
+/**
+* decodeRecord: Returns the value of the stored data of the key, without the expiration date, read from its blob, decrypted and decompressed
+* @param id string, data []byte, flags byte
+* @return []byte, error
+**/
+func (s *FileStore) decodeRecord(id string, data []byte, flags byte) ([]byte, error) {
+	data, expires, err := splitExpiry(data, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	if flags&flagBlob != 0 {
+		data, err = s.readBlob(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.unseal(data, flags, recordAAD(id, flags, expires))
+}
+
+/**
+* readRef: Reads the value of the record of the key and the codec it was written with, the caller holds indexMu
+* @param id string, ref *RecordRef
+* @return []byte, Codec, error
+**/
+func (s *FileStore) readRef(id string, ref *RecordRef) ([]byte, Codec, error) {
+	key, _ := s.refKey(ref)
+	if data, codec, ok := s.cache.get(key); ok {
+		return data, codec, nil
+	}
+
+	h, stored, err := s.segments[ref.segment].readRecord(id, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	codec, err := codecOf(h.Flags)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := s.decodeRecord(id, stored, h.Flags)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Con indexMu tomado la posición no se reutiliza mientras se guarda
+	s.cache.put(key, data, codec)
+	return data, codec, nil
+}
+
+/**
+* blobRefs: Adds the blobs referenced by the records of the segments between from and to
+* @param segments []*segment, from, to []int64, result map[string]bool
+* @return error
+**/
+func blobRefs(segments []*segment, from, to []int64, result map[string]bool) error {
+	for i, seg := range segments {
+		var failed error
+		seg.scanFrom(from[i], to[i], func(h recordHeader, data []byte, offset int64) {
+			if failed != nil || h.Flags&flagBlob == 0 {
+				return
+			}
+
+			pointer, _, err := splitExpiry(data, h.Flags)
+			if err != nil || len(pointer) != blobPointerSize {
+				failed = errors.New(msg.MSG_CORRUPTED_RECORD)
+				return
+			}
+			result[blobName(pointer[0:blobHashSize])] = true
+		})
+		if failed != nil {
+			return failed
+		}
+	}
+
+	return nil
+}
+
+/**
+* blobFiles: Returns the names of the files of the blobs directory, the stores created before the blobs have none
+* @return []string, error
+**/
+func (s *FileStore) blobFiles() ([]string, error) {
+	if !s.fs.Exists(s.PathBlobs) {
+		return []string{}, nil
+	}
+
+	return s.fs.ReadDir(s.PathBlobs)
+}
+
+/**
+* CollectBlobs: Removes the blobs that no record of the log points to, returns the number removed. The segments
+* are read without blocking the writes, under the locks only the records written since then are read again
+* @return int, error
+**/
+func (s *FileStore) CollectBlobs() (int, error) {
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+
+	s.writeMu.Lock()
+	if s.isClosed() {
+		s.writeMu.Unlock()
+		return 0, nil
+	}
+	segments := append([]*segment{}, s.segments...)
+	starts := make([]int64, len(segments))
+	sizes := make([]int64, len(segments))
+	for i, seg := range segments {
+		starts[i] = seg.start()
+		sizes[i] = seg.size
+	}
+
+	// Con los segmentos fijados la compactación no los reemplaza, el log solo crece
+	s.pins.Add(1)
+	defer s.pins.Add(-1)
+	s.writeMu.Unlock()
+
+	refs := map[string]bool{}
+	if err := blobRefs(segments, starts, sizes, refs); err != nil {
+		return 0, err
+	}
+
+	files, err := s.blobFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	candidates := []string{}
+	for _, name := range files {
+		if !refs[name] {
+			candidates = append(candidates, name)
+		}
+	}
+	if len(candidates) == 0 {
+		return 0, nil
+	}
+
+	// Los escritores con un blob escrito y aún sin confirmar lo tienen tomado
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if s.isClosed() || s.rewrapping.Load() > 0 {
+		return 0, nil // blobs reescritos sin referenciar, se recolecta al terminar la compactación
+	}
+
+	// Los registros escritos después de la lectura pueden apuntar a los candidatos
+	read := map[*segment]int64{}
+	for i, seg := range segments {
+		read[seg] = sizes[i]
+	}
+	from := make([]int64, len(s.segments))
+	to := make([]int64, len(s.segments))
+	for i, seg := range s.segments {
+		start, ok := read[seg]
+		if !ok {
+			start = seg.start()
+		}
+		from[i] = start
+		to[i] = seg.size
+	}
+	if err := blobRefs(s.segments, from, to, refs); err != nil {
+		return 0, err
+	}
+
+	// Los temporales son de escrituras interrumpidas
+	n := 0
+	for _, name := range candidates {
+		path := filepath.Join(s.PathBlobs, name)
+		if refs[name] || !s.fs.Exists(path) {
+			continue
+		}
+
+		if err := s.fs.Remove(path); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	if s.isDebug && n > 0 {
+		logs.Debug("blobs:", s.Path, ":", s.Name, ":removed:", n)
+	}
+
+	return n, nil
+}
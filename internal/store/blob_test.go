@@ -0,0 +1,193 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/**
+* blobCount: Returns the number of blob files of the store
+* @param t *testing.T, fs *FileStore
+* @return int
+**/
+func blobCount(t *testing.T, fs *FileStore) int {
+	t.Helper()
+	files, err := fs.blobFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return len(files)
+}
+
+func TestBlobSeparation(t *testing.T) {
+	t.Setenv("BLOB_THRESHOLD", "1")
+	fsys := NewMemFS()
+	fs, err := OpenFS(fsys, "/db", "blob", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := strings.Repeat("josefina ", 512)
+	fs.Put("small", "value")
+	fs.Put("large", large)
+	fs.Put("copy", large)
+
+	// El mismo contenido comparte el archivo
+	if n := blobCount(t, fs); n != 1 {
+		t.Fatalf("%d blobs for one large value", n)
+	}
+	for id, separated := range map[string]bool{"small": false, "large": true} {
+		ref := indexRef(t, fs, id)
+		h, _, err := fs.segments[ref.segment].readRecord(id, ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (h.Flags&flagBlob != 0) != separated {
+			t.Fatalf("%s separated %v", id, h.Flags&flagBlob != 0)
+		}
+	}
+
+	data, err := readFile(fsys, filepath.Join(fs.PathSegments, fs.segments[0].name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(large)) {
+		t.Fatal("large value written in the segment")
+	}
+
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fs, err = OpenFS(fsys, "/db", "blob", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	for id, expected := range map[string]string{"small": "value", "large": large, "copy": large} {
+		var value string
+		exists, err := fs.Get(id, &value)
+		if err != nil || !exists || value != expected {
+			t.Fatalf("%s: %d bytes %v %v", id, len(value), exists, err)
+		}
+	}
+}
+
+func TestCollectBlobsOrphans(t *testing.T) {
+	t.Setenv("BLOB_THRESHOLD", "1")
+	fs, err := OpenFS(NewMemFS(), "/db", "blob", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	fs.Put("a", strings.Repeat("a", 4096))
+	fs.Put("b", strings.Repeat("b", 4096))
+	fs.Put("a", strings.Repeat("c", 4096))
+	fs.Delete("b")
+
+	// Un temporal de una escritura interrumpida
+	if err := writeFile(fs.fs, filepath.Join(fs.PathBlobs, "interrupted"+blobExt+".tmp"), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Los registros anteriores siguen en el log hasta la compactación
+	n, err := fs.CollectBlobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || blobCount(t, fs) != 3 {
+		t.Fatalf("%d removed before the compaction, %d left", n, blobCount(t, fs))
+	}
+
+	if err := fs.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if n := blobCount(t, fs); n != 1 {
+		t.Fatalf("%d blobs after the compaction", n)
+	}
+
+	var value string
+	if exists, err := fs.Get("a", &value); err != nil || !exists || value != strings.Repeat("c", 4096) {
+		t.Fatalf("live blob removed: %v %v", exists, err)
+	}
+}
+
+func TestCollectBlobsWithReaders(t *testing.T) {
+	t.Setenv("BLOB_THRESHOLD", "1")
+	fs, err := OpenFS(NewMemFS(), "/db", "blob", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	fs.Put("a", strings.Repeat("a", 4096))
+	if err := writeFile(fs.fs, filepath.Join(fs.PathBlobs, strings.Repeat("0", 64)+blobExt), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Un lector del log, como un backup, no detiene la recolección, solo la compactación
+	fs.pins.Add(1)
+	defer fs.pins.Add(-1)
+
+	n, err := fs.CollectBlobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("%d orphans removed with a reader", n)
+	}
+
+	var value string
+	if exists, err := fs.Get("a", &value); err != nil || !exists || len(value) != 4096 {
+		t.Fatalf("live blob removed: %v %v", exists, err)
+	}
+}
+
+func TestCollectBlobsDuringWrites(t *testing.T) {
+	t.Setenv("BLOB_THRESHOLD", "1")
+	fs, err := OpenFS(NewMemFS(), "/db", "blob", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	// Las escrituras siguen mientras se leen los segmentos, sus blobs no se recolectan
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			if err := fs.Put(fmt.Sprintf("k%d", i%20), strings.Repeat(fmt.Sprint(i), 2048)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		if _, err := fs.CollectBlobs(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	if err := fs.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 180; i < 200; i++ {
+		var value string
+		exists, err := fs.Get(fmt.Sprintf("k%d", i%20), &value)
+		if err != nil || !exists || value != strings.Repeat(fmt.Sprint(i), 2048) {
+			t.Fatalf("k%d: %v %v", i%20, exists, err)
+		}
+	}
+	if n := blobCount(t, fs); n != 20 {
+		t.Fatalf("%d blobs for 20 records", n)
+	}
+}
@@ -0,0 +1,251 @@
+package store
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/cgalvisleon/et/et"
+)
+
+const cacheEntryOverhead = 96 // bytes aproximados de la entrada, la lista y el mapa
+
+/**
+* cacheKey: Position of a record, the segment is the object so the rewritten segments never hit
+**/
+type cacheKey struct {
+	segment *segment
+	offset  int64
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	data  []byte
+	codec Codec
+}
+
+/**
+* CacheStats: Counters of the read cache
+**/
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Size      int64  `json:"size"`
+	Capacity  int64  `json:"capacity"`
+}
+
+/**
+* ToJson
+* @return et.Json
+**/
+func (s CacheStats) ToJson() et.Json {
+	ratio := 0.0
+	if total := s.Hits + s.Misses; total > 0 {
+		ratio = float64(s.Hits) / float64(total)
+	}
+
+	return et.Json{
+		"hits":      s.Hits,
+		"misses":    s.Misses,
+		"hit_ratio": ratio,
+		"evictions": s.Evictions,
+		"entries":   s.Entries,
+		"size":      s.Size,
+		"capacity":  s.Capacity,
+	}
+}
+
+/**
+* readCache: LRU of the decoded values by position of the record, bounded in bytes
+**/
+type readCache struct {
+	mu        sync.Mutex
+	capacity  int64
+	size      int64
+	items     map[cacheKey]*list.Element
+	order     *list.List // el más reciente al frente
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+/**
+* newReadCache: capacity 0 disables the cache
+* @param capacity int64
+* @return *readCache
+**/
+func newReadCache(capacity int64) *readCache {
+	return &readCache{
+		capacity: max(capacity, 0),
+		items:    make(map[cacheKey]*list.Element),
+		order:    list.New(),
+	}
+}
+
+/**
+* entrySize
+* @param data []byte
+* @return int64
+**/
+func entrySize(data []byte) int64 {
+	return int64(len(data)) + cacheEntryOverhead
+}
+
+/**
+* get
+* @param key cacheKey
+* @return []byte, Codec, bool
+**/
+func (s *readCache) get(key cacheKey) ([]byte, Codec, bool) {
+	if s.capacity == 0 {
+		return nil, nil, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if !ok {
+		s.misses++
+		return nil, nil, false
+	}
+
+	s.hits++
+	s.order.MoveToFront(el)
+	entry := el.Value.(*cacheEntry)
+	return entry.data, entry.codec, true
+}
+
+/**
+* put: The values larger than an eighth of the capacity are not cached, they would evict the hot ones
+* @param key cacheKey, data []byte, codec Codec
+**/
+func (s *readCache) put(key cacheKey, data []byte, codec Codec) {
+	size := entrySize(data)
+	if s.capacity == 0 || size > s.capacity/8 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[key]; ok {
+		s.order.MoveToFront(el)
+		return
+	}
+
+	s.items[key] = s.order.PushFront(&cacheEntry{key: key, data: data, codec: codec})
+	s.size += size
+	for s.size > s.capacity {
+		s.evict(s.order.Back())
+		s.evictions++
+	}
+}
+
+/**
+* evict: The caller holds mu
+* @param el *list.Element
+**/
+func (s *readCache) evict(el *list.Element) {
+	entry := s.order.Remove(el).(*cacheEntry)
+	delete(s.items, entry.key)
+	s.size -= entrySize(entry.data)
+}
+
+/**
+* remove: Removes the value of an overwritten or deleted record
+* @param key cacheKey
+**/
+func (s *readCache) remove(key cacheKey) {
+	if s.capacity == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[key]; ok {
+		s.evict(el)
+	}
+}
+
+/**
+* removeSegment: Removes the values of a segment rewritten by the compaction
+* @param seg *segment
+**/
+func (s *readCache) removeSegment(seg *segment) {
+	if s.capacity == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, el := range s.items {
+		if key.segment == seg {
+			s.evict(el)
+		}
+	}
+}
+
+/**
+* reset: Removes all the values, the counters are kept
+**/
+func (s *readCache) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items = make(map[cacheKey]*list.Element)
+	s.order.Init()
+	s.size = 0
+}
+
+/**
+* stats
+* @return CacheStats
+**/
+func (s *readCache) stats() CacheStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return CacheStats{
+		Hits:      s.hits,
+		Misses:    s.misses,
+		Evictions: s.evictions,
+		Entries:   len(s.items),
+		Size:      s.size,
+		Capacity:  s.capacity,
+	}
+}
+
+/**
+* CacheStats: Returns the counters of the read cache
+* @return CacheStats
+**/
+func (s *FileStore) CacheStats() CacheStats {
+	return s.cache.stats()
+}
+
+/**
+* refKey: The caller holds indexMu
+* @param ref *RecordRef
+* @return cacheKey, bool
+**/
+func (s *FileStore) refKey(ref *RecordRef) (cacheKey, bool) {
+	if ref.segment < 0 || ref.segment >= len(s.segments) {
+		return cacheKey{}, false
+	}
+
+	return cacheKey{segment: s.segments[ref.segment], offset: ref.offset}, true
+}
+
+/**
+* uncache: Removes the value of the record of ref, the caller holds indexMu
+* @param ref *RecordRef
+**/
+func (s *FileStore) uncache(ref *RecordRef) {
+	if key, ok := s.refKey(ref); ok {
+		s.cache.remove(key)
+	}
+}
@@ -0,0 +1,232 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+/**
+* cacheStore: Opens a store on disk with the read cache and without the compaction loop
+* @param t *testing.T
+* @return *FileStore
+**/
+func cacheStore(t *testing.T) *FileStore {
+	t.Helper()
+	t.Setenv("READ_CACHE_MB", "1")
+	result, err := Open(t.TempDir(), "cache", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result.Compactor().stop()
+	t.Cleanup(func() { result.Close() })
+
+	return result
+}
+
+/**
+* readCached: Reads the key and returns if the read was a hit of the cache
+* @param t *testing.T, fs *FileStore, id, expected string
+* @return bool
+**/
+func readCached(t *testing.T, fs *FileStore, id, expected string) bool {
+	t.Helper()
+	hits := fs.CacheStats().Hits
+	var value string
+	exists, err := fs.Get(id, &value)
+	if err != nil || exists != (expected != "") || value != expected {
+		t.Fatalf("%s: %q %v %v, expected %q", id, value, exists, err, expected)
+	}
+
+	return fs.CacheStats().Hits > hits
+}
+
+func TestReadCacheEviction(t *testing.T) {
+	data := make([]byte, 100)
+	cache := newReadCache(entrySize(data) * 8)
+	key := func(i int) cacheKey {
+		return cacheKey{offset: int64(i)}
+	}
+
+	for i := 0; i < 8; i++ {
+		cache.put(key(i), data, nil)
+	}
+	if stats := cache.stats(); stats.Entries != 8 || stats.Size != stats.Capacity || stats.Evictions != 0 {
+		t.Fatalf("full cache %+v", stats)
+	}
+
+	// Leer la clave más antigua la deja al frente, la siguiente es la que sale
+	if _, _, ok := cache.get(key(0)); !ok {
+		t.Fatal("key 0 not cached")
+	}
+	cache.put(key(8), data, nil)
+	if _, _, ok := cache.get(key(1)); ok {
+		t.Fatal("key 1 not evicted")
+	}
+	for _, i := range []int{0, 2, 8} {
+		if _, _, ok := cache.get(key(i)); !ok {
+			t.Fatalf("key %d evicted", i)
+		}
+	}
+
+	stats := cache.stats()
+	if stats.Entries != 8 || stats.Evictions != 1 || stats.Hits != 4 || stats.Misses != 1 {
+		t.Fatalf("counters %+v", stats)
+	}
+
+	cache.remove(key(0))
+	cache.reset()
+	if stats := cache.stats(); stats.Entries != 0 || stats.Size != 0 || stats.Evictions != 1 {
+		t.Fatalf("reset %+v", stats)
+	}
+}
+
+func TestReadCacheLargeValues(t *testing.T) {
+	cache := newReadCache(8 * 1024)
+	limit := make([]byte, 1024-cacheEntryOverhead)
+	cache.put(cacheKey{offset: 1}, limit, nil)
+	cache.put(cacheKey{offset: 2}, append(limit, 0), nil)
+	if _, _, ok := cache.get(cacheKey{offset: 1}); !ok {
+		t.Fatal("value of an eighth of the capacity not cached")
+	}
+	if _, _, ok := cache.get(cacheKey{offset: 2}); ok {
+		t.Fatal("value larger than an eighth of the capacity cached")
+	}
+
+	// En el store un valor grande se lee siempre del segmento
+	fs := cacheStore(t)
+	large := strings.Repeat("x", int(fs.CacheSize/8))
+	fs.Put("large", large)
+	fs.Put("small", "s")
+	for i := 0; i < 2; i++ {
+		if readCached(t, fs, "large", large) {
+			t.Fatal("large value read from the cache")
+		}
+		if readCached(t, fs, "small", "s") != (i > 0) {
+			t.Fatalf("small value read %d", i)
+		}
+	}
+	if stats := fs.CacheStats(); stats.Entries != 1 || stats.Size != entrySize([]byte(`"s"`)) {
+		t.Fatalf("cache %+v", stats)
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	fs := cacheStore(t)
+	fs.Put("a", "a1")
+	fs.Put("b", "b1")
+	readCached(t, fs, "a", "a1")
+	if !readCached(t, fs, "a", "a1") {
+		t.Fatal("second read not cached")
+	}
+
+	// Sobrescribir y borrar quitan el valor de la posición anterior
+	fs.Put("a", "a2")
+	if readCached(t, fs, "a", "a2") {
+		t.Fatal("overwritten value read from the cache")
+	}
+	readCached(t, fs, "b", "b1")
+	if entries := fs.CacheStats().Entries; entries != 2 {
+		t.Fatalf("%d entries after the overwrite", entries)
+	}
+	fs.Delete("b")
+	readCached(t, fs, "b", "")
+	if entries := fs.CacheStats().Entries; entries != 1 {
+		t.Fatalf("%d entries after the delete", entries)
+	}
+
+	// La compactación completa reescribe todas las posiciones
+	if err := fs.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if entries := fs.CacheStats().Entries; entries != 0 {
+		t.Fatalf("%d entries after the compaction", entries)
+	}
+	if readCached(t, fs, "a", "a2") {
+		t.Fatal("compacted value read from the cache")
+	}
+	if !readCached(t, fs, "a", "a2") {
+		t.Fatal("compacted value not cached")
+	}
+}
+
+func TestCacheSegmentSwap(t *testing.T) {
+	fs := cacheStore(t)
+	keyring := NewKeyring()
+	keyring.Add(1, bytes.Repeat([]byte{1}, 32))
+	fs.SetKeyring(keyring)
+	fs.MaxSegment = 300
+
+	values := map[string]string{}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("k%02d", i)
+		values[key] = key + strings.Repeat("v", 50)
+		fs.Put(key, values[key])
+	}
+	closed := len(fs.segments) - 1
+	if closed < 2 {
+		t.Fatalf("%d segments", len(fs.segments))
+	}
+	for key, value := range values {
+		readCached(t, fs, key, value)
+	}
+
+	// Un registro sobrescrito en cada segmento cerrado, se reescriben con la clave nueva
+	victims := map[int]bool{}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("k%02d", i)
+		if ref := indexRef(t, fs, key); ref.segment < closed && !victims[ref.segment] {
+			victims[ref.segment] = true
+			values[key] = key + "2"
+			fs.Put(key, values[key])
+		}
+	}
+	rotated := NewKeyring()
+	rotated.Add(2, bytes.Repeat([]byte{2}, 32))
+	rotated.Add(1, bytes.Repeat([]byte{1}, 32))
+	fs.SetKeyring(rotated)
+	fs.Compactor().SetRatio(0.01)
+	if err := fs.Compactor().Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if stats := fs.Compactor().Stats(); stats.Segments != int64(len(victims)) {
+		t.Fatalf("%d segments compacted, expected %d", stats.Segments, len(victims))
+	}
+
+	// Los valores de los segmentos reemplazados salen de la caché
+	cached := 0
+	for key, value := range values {
+		if !victims[indexRef(t, fs, key).segment] && value != key+"2" {
+			cached++
+		}
+	}
+	if entries := fs.CacheStats().Entries; entries != cached {
+		t.Fatalf("%d entries after the swap, expected %d", entries, cached)
+	}
+
+	// Los valores de los segmentos reescritos se vuelven a leer del disco
+	moved := []string{}
+	for key, value := range values {
+		if !victims[indexRef(t, fs, key).segment] {
+			continue
+		}
+		moved = append(moved, key)
+		if readCached(t, fs, key, value) {
+			t.Fatalf("%s read from the cache of the replaced segment", key)
+		}
+	}
+	if len(moved) == 0 {
+		t.Fatal("no records moved by the compaction")
+	}
+
+	// Sin la clave anterior los registros reescritos se leen con la nueva
+	only := NewKeyring()
+	only.Add(2, bytes.Repeat([]byte{2}, 32))
+	fs.SetKeyring(only)
+	fs.cache.reset()
+	for _, key := range moved {
+		readCached(t, fs, key, values[key])
+	}
+}
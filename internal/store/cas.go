@@ -0,0 +1,187 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/cgalvisleon/josefina/pkg/msg"
+)
+
+var ErrConflict = errors.New(msg.MSG_VERSION_CONFLICT)
+
+// Versión de los registros de los formatos sin secuencia, ninguna escritura nueva la recibe
+const legacyVersion = math.MaxUint64
+
+/**
+* version: Returns the version of the record, the caller holds indexMu for reading
+* @param ref *RecordRef
+* @return uint64, error
+**/
+func (s *FileStore) version(ref *RecordRef) (uint64, error) {
+	if seq := atomic.LoadUint64(&ref.seq); seq != 0 {
+		return seq, nil
+	}
+
+	// Las referencias de snapshots anteriores no tienen la secuencia, otros lectores pueden guardarla a la vez
+	h, err := s.segments[ref.segment].ReadHeader(ref)
+	if err != nil {
+		return 0, err
+	}
+	if h.Seq == 0 {
+		return legacyVersion, nil
+	}
+	atomic.StoreUint64(&ref.seq, h.Seq)
+
+	return h.Seq, nil
+}
+
+/**
+* currentVersion: Returns the version of the key, 0 when it does not exist
+* @param id string
+* @return uint64, error
+**/
+func (s *FileStore) currentVersion(id string) (uint64, error) {
+	s.indexMu.RLock()
+	defer s.indexMu.RUnlock()
+
+	ref, ok := s.index.Get(id)
+	if !ok || ref.expired(time.Now().UnixNano()) {
+		return 0, nil
+	}
+
+	return s.version(ref)
+}
+
+/**
+* Version: Returns the version of the key, it changes on every write of the key
+* @param id string
+* @return uint64, bool, error
+**/
+func (s *FileStore) Version(id string) (uint64, bool, error) {
+	result, err := s.currentVersion(id)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return result, result != 0, nil
+}
+
+/**
+* GetVersion: Reads the value and its version in one step
+* @param id string, dest any
+* @return uint64, bool, error
+**/
+func (s *FileStore) GetVersion(id string, dest any) (uint64, bool, error) {
+	s.indexMu.RLock()
+	defer s.indexMu.RUnlock()
+
+	ref, ok := s.index.Get(id)
+	if !ok || ref.expired(time.Now().UnixNano()) {
+		return 0, false, nil
+	}
+
+	version, err := s.version(ref)
+	if err != nil {
+		return 0, true, err
+	}
+
+	data, codec, err := s.readRef(id, ref)
+	if err != nil {
+		return 0, true, err
+	}
+
+	return version, true, codec.Unmarshal(data, dest)
+}
+
+/**
+* checkVersions: Validates the expected versions of a request against the index and the writes of the group, the caller holds writeMu
+* @param req *commitRequest, pending map[string]uint64
+* @return error
+**/
+func (s *FileStore) checkVersions(req *commitRequest, pending map[string]uint64) error {
+	for _, rec := range req.records {
+		if !rec.check {
+			continue
+		}
+
+		current, ok := pending[rec.id]
+		if !ok {
+			var err error
+			current, err = s.currentVersion(rec.id)
+			if err != nil {
+				return err
+			}
+		}
+
+		if current != rec.expected {
+			return ErrConflict
+		}
+	}
+
+	return nil
+}
+
+/**
+* PutIf: Writes the value only if the version of the key is expected, 0 when the key must not exist
+* @param id string, value any, expected uint64
+* @return uint64, error
+**/
+func (s *FileStore) PutIf(id string, value any, expected uint64) (uint64, error) {
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+
+	if id == "" {
+		return 0, errors.New(msg.MSG_ID_IS_REQUIRED)
+	}
+
+	s.blobMu.RLock()
+	data, stored, flags, err := s.encode(id, value, 0)
+	if err != nil {
+		s.blobMu.RUnlock()
+		return 0, err
+	}
+	rec := newLogRecord(id, stored, Active, flags)
+	rec.check, rec.expected = true, expected
+	refs, err := s.commit(rec)
+	s.blobMu.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+
+	s.firePut(id, data, flags)
+
+	return refs[0].seq, nil
+}
+
+/**
+* DeleteIf: Deletes the key only if its version is expected
+* @param id string, expected uint64
+* @return bool, error
+**/
+func (s *FileStore) DeleteIf(id string, expected uint64) (bool, error) {
+	if err := s.writable(); err != nil {
+		return false, err
+	}
+
+	if !s.IsExist(id) {
+		if expected != 0 {
+			return false, ErrConflict
+		}
+		return false, nil
+	}
+
+	rec := newLogRecord(id, nil, Deleted, CompressNone)
+	rec.check, rec.expected = true, expected
+	if _, err := s.commit(rec); err != nil {
+		return false, err
+	}
+
+	for _, fn := range s.onDelete {
+		fn(id)
+	}
+
+	return true, nil
+}
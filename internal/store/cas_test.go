@@ -0,0 +1,88 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPutIfVersions(t *testing.T) {
+	fs, err := Open(t.TempDir(), "cas", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	// La versión 0 exige que la clave no exista
+	first, err := fs.PutIf("a", "one", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.PutIf("a", "again", 0); !errors.Is(err, ErrConflict) {
+		t.Fatalf("existing key written with version 0: %v", err)
+	}
+
+	version, exists, err := fs.Version("a")
+	if err != nil || !exists || version != first {
+		t.Fatalf("version %d %v %v, expected %d", version, exists, err, first)
+	}
+
+	second, err := fs.PutIf("a", "two", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first {
+		t.Fatal("the version did not change with the write")
+	}
+	if _, err := fs.PutIf("a", "stale", first); !errors.Is(err, ErrConflict) {
+		t.Fatalf("write with a stale version: %v", err)
+	}
+
+	var value string
+	version, exists, err = fs.GetVersion("a", &value)
+	if err != nil || !exists || version != second || value != "two" {
+		t.Fatalf("%q in version %d %v %v", value, version, exists, err)
+	}
+
+	// DeleteIf compara la versión igual que PutIf
+	if _, err := fs.DeleteIf("a", first); !errors.Is(err, ErrConflict) {
+		t.Fatalf("delete with a stale version: %v", err)
+	}
+	deleted, err := fs.DeleteIf("a", second)
+	if err != nil || !deleted {
+		t.Fatalf("delete in the current version: %v %v", deleted, err)
+	}
+	if _, err := fs.DeleteIf("a", second); !errors.Is(err, ErrConflict) {
+		t.Fatalf("delete of a missing key with a version: %v", err)
+	}
+	if _, exists, _ := fs.Version("a"); exists {
+		t.Fatal("deleted key has a version")
+	}
+}
+
+func TestLegacyRecordVersion(t *testing.T) {
+	fs, err := Open(copyFixture(t, "v2"), "fixture", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	// Los registros sin secuencia existen y su versión no es la de una clave que no existe
+	version, exists, err := fs.Version("a")
+	if err != nil || !exists || version == 0 {
+		t.Fatalf("legacy record in version %d %v %v", version, exists, err)
+	}
+	if _, err := fs.PutIf("a", "replaced", 0); !errors.Is(err, ErrConflict) {
+		t.Fatalf("legacy record written as a missing key: %v", err)
+	}
+
+	written, err := fs.PutIf("a", "replaced", version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written == version {
+		t.Fatal("the legacy version did not change with the write")
+	}
+	if _, err := fs.PutIf("a", "stale", version); !errors.Is(err, ErrConflict) {
+		t.Fatalf("write with the legacy version after a write: %v", err)
+	}
+}
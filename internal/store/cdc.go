@@ -0,0 +1,387 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/cgalvisleon/et/et"
+	"github.com/cgalvisleon/josefina/pkg/msg"
+)
+
+// Eventos del historial leídos de una vez antes de entregarlos
+const replayBuffer = 256
+
+/**
+* Event: Mutation of the store in the change stream
+**/
+type Event struct {
+	Seq       uint64 `json:"seq"`
+	Timestamp int64  `json:"timestamp"`
+	ID        string `json:"id"`
+	Deleted   bool   `json:"deleted"`
+	Data      []byte `json:"data"`
+}
+
+/**
+* ToJson
+* @return et.Json
+**/
+func (s *Event) ToJson() et.Json {
+	return et.Json{
+		"seq":       s.Seq,
+		"timestamp": s.Timestamp,
+		"id":        s.ID,
+		"deleted":   s.Deleted,
+		"data":      string(s.Data),
+	}
+}
+
+/**
+* Subscription: Ordered stream of events, the history is replayed before the live changes. A subscriber that
+* falls MaxQueue events behind is closed with MSG_SUBSCRIBER_BEHIND and can subscribe again from its last sequence
+**/
+type Subscription struct {
+	store  *FileStore
+	out    chan *Event
+	done   chan struct{}
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []*Event
+	limit  int // eventos en cola antes de cerrar la suscripción
+	closed bool
+	err    error
+}
+
+/**
+* Events: Channel of events, it is closed when the subscription ends
+* @return <-chan *Event
+**/
+func (s *Subscription) Events() <-chan *Event {
+	return s.out
+}
+
+/**
+* Err: Returns the error that ended the subscription
+* @return error
+**/
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+/**
+* Close
+**/
+func (s *Subscription) Close() {
+	s.stop(nil)
+
+	s.store.subMu.Lock()
+	delete(s.store.subscribers, s)
+	s.store.subMu.Unlock()
+}
+
+/**
+* stop
+* @param err error
+**/
+func (s *Subscription) stop(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	s.err = err
+	close(s.done)
+	s.cond.Broadcast()
+}
+
+/**
+* push: Queues a live event without blocking the writer, returns false when the subscription is closed
+* @param event *Event
+* @return bool
+**/
+func (s *Subscription) push(event *Event) bool {
+	s.mu.Lock()
+	closed := s.closed
+	full := len(s.queue) >= s.limit
+	if !closed && !full {
+		s.queue = append(s.queue, event)
+		s.cond.Signal()
+	}
+	s.mu.Unlock()
+
+	if full && !closed {
+		// Sin el evento el stream tendría un hueco, el suscriptor se sincroniza de nuevo desde el log
+		s.stop(errors.New(msg.MSG_SUBSCRIBER_BEHIND))
+	}
+
+	return !closed && !full
+}
+
+/**
+* send
+* @param event *Event
+* @return bool
+**/
+func (s *Subscription) send(event *Event) bool {
+	select {
+	case s.out <- event:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+/**
+* replay: Delivers the events of the log segment by segment in blocks of replayBuffer events, the segments stay
+* pinned until the last block is read and not until the subscriber receives it
+* @param segments []*segment, sizes []int64, fromSeq, toSeq uint64
+* @return bool
+**/
+func (s *Subscription) replay(segments []*segment, sizes []int64, fromSeq, toSeq uint64) bool {
+	pinned := true
+	unpin := func() {
+		if pinned {
+			pinned = false
+			s.store.pins.Add(-1)
+		}
+	}
+	defer unpin()
+
+	for i, seg := range segments {
+		items := history(seg, sizes[i], fromSeq, toSeq)
+		for len(items) > 0 {
+			n := min(len(items), replayBuffer)
+			events := make([]*Event, 0, n)
+			for _, item := range items[:n] {
+				event, err := s.store.readEvent(seg, item, sizes[i])
+				if err != nil {
+					s.stop(err)
+					return false
+				}
+				events = append(events, event)
+			}
+			items = items[n:]
+
+			// Leído el último bloque, la compactación no espera la entrega
+			if i == len(segments)-1 && len(items) == 0 {
+				unpin()
+			}
+
+			for _, event := range events {
+				if !s.send(event) {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+/**
+* run: Replays the history and then delivers the queued live events
+* @param segments []*segment, sizes []int64, fromSeq, toSeq uint64
+**/
+func (s *Subscription) run(segments []*segment, sizes []int64, fromSeq, toSeq uint64) {
+	defer close(s.out)
+
+	if !s.replay(segments, sizes, fromSeq, toSeq) {
+		return
+	}
+
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		event := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		if !s.send(event) {
+			return
+		}
+	}
+}
+
+type historyItem struct {
+	seq    uint64
+	offset int64
+}
+
+/**
+* history: Collects the records of the segment with sequence in (fromSeq, toSeq] ordered by sequence
+* @param seg *segment, limit int64, fromSeq, toSeq uint64
+* @return []historyItem
+**/
+func history(seg *segment, limit int64, fromSeq, toSeq uint64) []historyItem {
+	result := []historyItem{}
+	if seg.version < segmentV3 {
+		return result // los formatos anteriores no tienen secuencia
+	}
+
+	seg.replay(limit, func(h recordHeader, offset int64) {
+		if h.Seq > fromSeq && h.Seq <= toSeq {
+			result = append(result, historyItem{seq: h.Seq, offset: offset})
+		}
+	})
+
+	// Los segmentos compactados están ordenados por clave
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].seq < result[j].seq
+	})
+
+	return result
+}
+
+/**
+* readEvent
+* @param seg *segment, item historyItem, limit int64
+* @return *Event, error
+**/
+func (s *FileStore) readEvent(seg *segment, item historyItem, limit int64) (*Event, error) {
+	s.indexMu.RLock()
+	defer s.indexMu.RUnlock()
+
+	h, stored, err := seg.readRecordAt(item.offset, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.decodeJSON(h.ID, stored, h.Flags)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Event{
+		Seq:       h.Seq,
+		Timestamp: h.Timestamp,
+		ID:        h.ID,
+		Deleted:   h.Status == Deleted,
+		Data:      data,
+	}, nil
+}
+
+/**
+* Subscribe: Streams the mutations with sequence greater than fromSeq, first from the log and then live
+* @param fromSeq uint64
+* @return *Subscription, error
+**/
+func (s *FileStore) Subscribe(fromSeq uint64) (*Subscription, error) {
+	// Con writeMu no hay escrituras entre la captura del log y el registro del suscriptor
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if s.isClosed() {
+		return nil, errors.New(msg.MSG_STORE_CLOSED)
+	}
+
+	if fromSeq < s.compactedSeq() {
+		return nil, errors.New(msg.MSG_SEQ_COMPACTED)
+	}
+
+	sizes := make([]int64, len(s.segments))
+	for i, seg := range s.segments {
+		sizes[i] = seg.size
+	}
+
+	result := &Subscription{
+		store: s,
+		out:   make(chan *Event),
+		done:  make(chan struct{}),
+		limit: s.MaxQueue,
+	}
+	result.cond = sync.NewCond(&result.mu)
+
+	s.subMu.Lock()
+	s.subscribers[result] = true
+	s.subMu.Unlock()
+
+	// La compactación espera a que se lea el historial
+	s.pins.Add(1)
+	segments := append([]*segment{}, s.segments...)
+	go result.run(segments, sizes, fromSeq, s.seq.Load())
+	return result, nil
+}
+
+/**
+* compactedSeq: Returns the sequence up to which the history was lost by the compaction, the caller holds writeMu
+* @return uint64
+**/
+func (s *FileStore) compactedSeq() uint64 {
+	result := s.segments[0].baseSeq
+	for _, seg := range s.segments {
+		if seg.compacted && seg.baseSeq > result {
+			result = seg.baseSeq
+		}
+	}
+
+	return result
+}
+
+/**
+* publish: Sends the durable records to the subscribers, a record that can not be decoded closes the
+* subscriptions with the error instead of leaving a hole in their streams
+* @param records []*logRecord
+**/
+func (s *FileStore) publish(records []*logRecord) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+
+	if len(s.subscribers) == 0 {
+		return
+	}
+
+	for _, rec := range records {
+		if rec.seq == 0 {
+			continue // marcadores de batch
+		}
+
+		data, err := s.decodeJSON(rec.id, rec.data, rec.flags)
+		if err != nil {
+			for sub := range s.subscribers {
+				sub.stop(err)
+			}
+			s.subscribers = make(map[*Subscription]bool)
+			return
+		}
+
+		event := &Event{
+			Seq:       rec.seq,
+			Timestamp: rec.timestamp,
+			ID:        rec.id,
+			Deleted:   rec.status == Deleted,
+			Data:      data,
+		}
+		for sub := range s.subscribers {
+			if !sub.push(event) {
+				delete(s.subscribers, sub)
+			}
+		}
+	}
+}
+
+/**
+* closeSubscribers
+**/
+func (s *FileStore) closeSubscribers() {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+
+	for sub := range s.subscribers {
+		sub.stop(errors.New(msg.MSG_STORE_CLOSED))
+	}
+	s.subscribers = make(map[*Subscription]bool)
+}
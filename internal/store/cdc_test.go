@@ -0,0 +1,167 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cgalvisleon/josefina/pkg/msg"
+)
+
+/**
+* drain: Reads the events until the subscription ends
+* @param t *testing.T, sub *Subscription
+* @return []*Event
+**/
+func drain(t *testing.T, sub *Subscription) []*Event {
+	t.Helper()
+	result := []*Event{}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				return result
+			}
+			result = append(result, event)
+		case <-timeout:
+			t.Fatal("subscription not closed")
+		}
+	}
+}
+
+func TestSubscriberBehindIsClosed(t *testing.T) {
+	t.Setenv("CDC_QUEUE_SIZE", "2")
+	fs, err := OpenMemory("cdc", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	sub, err := fs.Subscribe(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nadie lee los eventos, la cola se llena
+	for i := 0; i < 10; i++ {
+		if err := fs.Put(fmt.Sprintf("k%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events := drain(t, sub)
+	if sub.Err() == nil || sub.Err().Error() != msg.MSG_SUBSCRIBER_BEHIND {
+		t.Fatalf("subscription closed with %v", sub.Err())
+	}
+	if len(events) >= 10 {
+		t.Fatalf("a closed subscription delivered %d events", len(events))
+	}
+
+	// Suscribirse de nuevo desde la última secuencia recibida completa el stream sin huecos
+	last := uint64(0)
+	for _, event := range events {
+		if event.Seq != last+1 {
+			t.Fatalf("hole in the stream: %d after %d", event.Seq, last)
+		}
+		last = event.Seq
+	}
+
+	again, err := fs.Subscribe(last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10-len(events); i++ {
+		event := <-again.Events()
+		if event.Seq != last+1 {
+			t.Fatalf("hole after resubscribing: %d after %d", event.Seq, last)
+		}
+		last = event.Seq
+	}
+	again.Close()
+}
+
+func TestPublishDecodeErrorClosesSubscribers(t *testing.T) {
+	t.Setenv("BLOB_THRESHOLD", "1")
+	fsys := NewFaultFS()
+	fs, err := OpenFS(fsys, "/db", "cdc", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	sub, err := fs.Subscribe(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// El valor queda en un blob que no se puede leer al publicar
+	fsys.FailWhen(func(op, name string) error {
+		if op == OpOpen && strings.HasSuffix(name, blobExt) {
+			return ErrInjectedFault
+		}
+		return nil
+	})
+	if err := fs.Put("large", strings.Repeat("x", 4096)); err != nil {
+		t.Fatal(err)
+	}
+
+	if events := drain(t, sub); len(events) != 0 {
+		t.Fatalf("undecodable event delivered: %v", events)
+	}
+	if sub.Err() == nil {
+		t.Fatal("event dropped without closing the subscription")
+	}
+}
+
+func TestReplayReleasesPinAfterReading(t *testing.T) {
+	fs, err := OpenMemory("cdc", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	n := replayBuffer + 10
+	for i := 0; i < n; i++ {
+		if err := fs.Put(fmt.Sprintf("k%d", i%10), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sub, err := fs.Subscribe(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	// Mientras se entrega el primer bloque los segmentos siguen fijados
+	for i := 0; i < replayBuffer-1; i++ {
+		<-sub.Events()
+	}
+	if fs.pins.Load() != 1 {
+		t.Fatal("segments released before the history was read")
+	}
+	<-sub.Events()
+
+	// El último bloque ya está en memoria, la compactación no espera a que el suscriptor lo lea
+	event := <-sub.Events()
+	if event.Seq != uint64(replayBuffer+1) {
+		t.Fatalf("event %d after the first block", event.Seq)
+	}
+	if fs.pins.Load() != 0 {
+		t.Fatal("segments pinned until the history is delivered")
+	}
+	if err := fs.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if fs.compactedSeq() != uint64(n) {
+		t.Fatal("compaction skipped by a slow subscriber")
+	}
+
+	for i := replayBuffer + 2; i <= n; i++ {
+		if event := <-sub.Events(); event.Seq != uint64(i) {
+			t.Fatalf("event %d, expected %d", event.Seq, i)
+		}
+	}
+}
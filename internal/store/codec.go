@@ -0,0 +1,444 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cgalvisleon/et/et"
+	"github.com/cgalvisleon/et/logs"
+	"github.com/cgalvisleon/josefina/pkg/msg"
+)
+
+const (
+	CodecJSON    byte = 0
+	CodecMsgpack byte = 1
+	CodecGob     byte = 2
+	CodecCustom  byte = 3 // libre para un codec de la aplicación
+
+	flagCodecMask  byte = 0x30
+	flagCodecShift      = 4
+)
+
+/**
+* Codec: Encoding of the values of the store, the code is recorded in the flags of each record
+**/
+type Codec interface {
+	Name() string
+	Code() byte
+	Marshal(value any) ([]byte, error)
+	Unmarshal(data []byte, dest any) error
+}
+
+var (
+	codecMu sync.RWMutex
+	codecs  = map[byte]Codec{
+		CodecJSON:    jsonCodec{},
+		CodecMsgpack: msgpackCodec{},
+		CodecGob:     gobCodec{},
+	}
+)
+
+func init() {
+	gob.Register(map[string]any{})
+	gob.Register([]any{})
+	gob.Register(et.Json{})
+	gob.Register([]et.Json{})
+	gob.Register(time.Time{})
+}
+
+/**
+* RegisterCodec: Registers an application codec, only CodecCustom is free
+* @param codec Codec
+* @return error
+**/
+func RegisterCodec(codec Codec) error {
+	if codec == nil || codec.Code() != CodecCustom {
+		return fmt.Errorf(msg.MSG_INVALID_CODEC, codecName(codec))
+	}
+
+	codecMu.Lock()
+	defer codecMu.Unlock()
+
+	codecs[CodecCustom] = codec
+	return nil
+}
+
+/**
+* codecName
+* @param codec Codec
+* @return string
+**/
+func codecName(codec Codec) string {
+	if codec == nil {
+		return "nil"
+	}
+
+	return codec.Name()
+}
+
+/**
+* CodecByName: Returns the registered codec with the name
+* @param name string
+* @return Codec, error
+**/
+func CodecByName(name string) (Codec, error) {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, codec := range codecs {
+		if codec.Name() == name {
+			return codec, nil
+		}
+	}
+
+	return nil, fmt.Errorf(msg.MSG_INVALID_CODEC, name)
+}
+
+/**
+* codecOf: Returns the codec of the flags of a record, the records without code are JSON
+* @param flags byte
+* @return Codec, error
+**/
+func codecOf(flags byte) (Codec, error) {
+	code := (flags & flagCodecMask) >> flagCodecShift
+
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+
+	result, ok := codecs[code]
+	if !ok {
+		return nil, fmt.Errorf(msg.MSG_INVALID_CODEC, fmt.Sprint(code))
+	}
+
+	return result, nil
+}
+
+/**
+* SetCodec: Sets the codec of the values written from now on, the records keep the codec they were written with
+* @param codec Codec
+**/
+func (s *FileStore) SetCodec(codec Codec) {
+	if codec == nil {
+		return
+	}
+
+	s.codecMu.Lock()
+	defer s.codecMu.Unlock()
+
+	s.codec = codec
+	s.Codec = codec.Name()
+}
+
+/**
+* currentCodec
+* @return Codec
+**/
+func (s *FileStore) currentCodec() Codec {
+	s.codecMu.RLock()
+	defer s.codecMu.RUnlock()
+
+	return s.codec
+}
+
+/**
+* encode: Marshals the value of the key with the codec of the store, returns the data and the data ready for the segment
+* @param id string, value any, expires int64
+* @return []byte, []byte, byte, error
+**/
+func (s *FileStore) encode(id string, value any, expires int64) ([]byte, []byte, byte, error) {
+	codec := s.currentCodec()
+	data, err := codec.Marshal(value)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	stored, flags, err := s.encodeValue(id, data, codec.Code()<<flagCodecShift, expires)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	return data, stored, flags, nil
+}
+
+/**
+* toJSON: Returns the data as JSON, the records of other codecs are transcoded
+* @param data []byte, codec Codec
+* @return []byte, error
+**/
+func toJSON(data []byte, codec Codec) ([]byte, error) {
+	if codec.Code() == CodecJSON {
+		return data, nil
+	}
+
+	var value any
+	if err := codec.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(value)
+}
+
+/**
+* decodeJSON: Returns the value of the stored data of the key as JSON
+* @param id string, data []byte, flags byte
+* @return []byte, error
+**/
+func (s *FileStore) decodeJSON(id string, data []byte, flags byte) ([]byte, error) {
+	codec, err := codecOf(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = s.decodeRecord(id, data, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	return toJSON(data, codec)
+}
+
+/**
+* firePut: Calls the put handlers with the data as JSON
+* @param id string, data []byte, flags byte
+**/
+func (s *FileStore) firePut(id string, data []byte, flags byte) {
+	if len(s.onPut) == 0 {
+		return
+	}
+
+	codec, err := codecOf(flags)
+	if err != nil {
+		logs.Error(err)
+		return
+	}
+
+	data, err = toJSON(data, codec)
+	if err != nil {
+		logs.Error(err)
+		return
+	}
+
+	for _, fn := range s.onPut {
+		fn(id, data)
+	}
+}
+
+type iterateFn func(id string, data []byte, codec Codec) (bool, error)
+
+/**
+* jsonFn: Adapts a function of the public iterators, the data is passed as JSON and is owned by fn
+* @param fn func(id string, data []byte) (bool, error)
+* @return iterateFn
+**/
+func jsonFn(fn func(id string, data []byte) (bool, error)) iterateFn {
+	return func(id string, data []byte, codec Codec) (bool, error) {
+		if codec.Code() == CodecJSON {
+			return fn(id, bytes.Clone(data)) // el valor puede estar en la caché
+		}
+
+		data, err := toJSON(data, codec)
+		if err != nil {
+			return false, err
+		}
+
+		return fn(id, data)
+	}
+}
+
+/**
+* objectFn: Adapts a function of the object iterators, the data is decoded with its codec
+* @param fn func(id string, item et.Json) (bool, error)
+* @return iterateFn
+**/
+func objectFn(fn func(id string, item et.Json) (bool, error)) iterateFn {
+	return func(id string, data []byte, codec Codec) (bool, error) {
+		item := et.Json{}
+		if err := codec.Unmarshal(data, &item); err != nil {
+			return false, err
+		}
+
+		return fn(id, item)
+	}
+}
+
+/**
+* assign: Sets the decoded value in dest, the types of the application are filled through JSON
+* @param dest any, value any
+* @return error
+**/
+func assign(dest any, value any) error {
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New(msg.MSG_INVALID_DESTINATION)
+	}
+
+	el := rv.Elem()
+	if value == nil {
+		el.Set(reflect.Zero(el.Type()))
+		return nil
+	}
+
+	vv := reflect.ValueOf(value)
+	if vv.Type().AssignableTo(el.Type()) {
+		el.Set(vv)
+		return nil
+	}
+
+	if convertible(vv, el.Type()) {
+		el.Set(vv.Convert(el.Type()))
+		return nil
+	}
+
+	bt, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bt, dest)
+}
+
+/**
+* convertible: Only maps, slices and the numbers that keep their value in the destination are converted directly,
+* the rest go through JSON that fails on a fraction or an overflow instead of truncating it
+* @param value reflect.Value, to reflect.Type
+* @return bool
+**/
+func convertible(value reflect.Value, to reflect.Type) bool {
+	from := value.Type()
+	if !from.ConvertibleTo(to) {
+		return false
+	}
+
+	switch {
+	case from.Kind() == to.Kind() && (from.Kind() == reflect.Map || from.Kind() == reflect.Slice):
+		return true
+	case isNumberKind(from.Kind()) && isNumberKind(to.Kind()):
+		result := value.Convert(to)
+		// Entre enteros con y sin signo la conversión de ida y vuelta no pierde bits, se valida el signo
+		if negative(value) != negative(result) {
+			return false
+		}
+		return result.Convert(from).Equal(value)
+	}
+
+	return false
+}
+
+/**
+* negative
+* @param value reflect.Value
+* @return bool
+**/
+func negative(value reflect.Value) bool {
+	switch {
+	case value.CanInt():
+		return value.Int() < 0
+	case value.CanFloat():
+		return value.Float() < 0
+	}
+
+	return false
+}
+
+/**
+* isNumberKind
+* @param kind reflect.Kind
+* @return bool
+**/
+func isNumberKind(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Float64
+}
+
+type jsonCodec struct{}
+
+/**
+* Name
+* @return string
+**/
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+/**
+* Code
+* @return byte
+**/
+func (jsonCodec) Code() byte {
+	return CodecJSON
+}
+
+/**
+* Marshal
+* @param value any
+* @return []byte, error
+**/
+func (jsonCodec) Marshal(value any) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+/**
+* Unmarshal
+* @param data []byte, dest any
+* @return error
+**/
+func (jsonCodec) Unmarshal(data []byte, dest any) error {
+	return json.Unmarshal(data, dest)
+}
+
+type gobValue struct {
+	V any
+}
+
+type gobCodec struct{}
+
+/**
+* Name
+* @return string
+**/
+func (gobCodec) Name() string {
+	return "gob"
+}
+
+/**
+* Code
+* @return byte
+**/
+func (gobCodec) Code() byte {
+	return CodecGob
+}
+
+/**
+* Marshal: The types of the application must be registered with gob.Register
+* @param value any
+* @return []byte, error
+**/
+func (gobCodec) Marshal(value any) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buf).Encode(&gobValue{V: value}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+/**
+* Unmarshal
+* @param data []byte, dest any
+* @return error
+**/
+func (gobCodec) Unmarshal(data []byte, dest any) error {
+	var result gobValue
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&result); err != nil {
+		return err
+	}
+
+	return assign(dest, result.V)
+}
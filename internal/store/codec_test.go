@@ -0,0 +1,195 @@
+package store
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type codecRecord struct {
+	Int    int            `json:"int"`
+	Neg    int64          `json:"neg"`
+	Big    uint64         `json:"big"`
+	Float  float64        `json:"float"`
+	At     time.Time      `json:"at"`
+	Nested map[string]any `json:"nested"`
+	List   []string       `json:"list"`
+}
+
+func init() {
+	gob.Register(codecRecord{})
+}
+
+/**
+* codecStore: Opens a store that writes with the codec
+* @param t *testing.T, name string
+* @return *FileStore
+**/
+func codecStore(t *testing.T, name string) *FileStore {
+	t.Helper()
+	result, err := Open(t.TempDir(), "codec", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { result.Close() })
+
+	codec, err := CodecByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result.SetCodec(codec)
+
+	return result
+}
+
+/**
+* sameJSON: Compares two values by their JSON form
+* @param t *testing.T, a, b any
+* @return bool
+**/
+func sameJSON(t *testing.T, a, b any) bool {
+	t.Helper()
+	ja, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jb, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(ja) == string(jb)
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	value := codecRecord{
+		Int:   42,
+		Neg:   math.MinInt64,
+		Big:   math.MaxUint64,
+		Float: 1.5,
+		At:    time.Date(2026, 3, 1, 10, 30, 0, 123456789, time.FixedZone("COT", -5*3600)),
+		Nested: map[string]any{
+			"name": "ana",
+			"deep": map[string]any{"ok": true, "tags": []any{"a", "b"}},
+		},
+		List: []string{"x", "y"},
+	}
+
+	for _, name := range []string{"json", "msgpack", "gob"} {
+		t.Run(name, func(t *testing.T) {
+			fs := codecStore(t, name)
+			if err := fs.Put("r", value); err != nil {
+				t.Fatal(err)
+			}
+
+			var result codecRecord
+			if exists, err := fs.Get("r", &result); err != nil || !exists {
+				t.Fatalf("%v %v", exists, err)
+			}
+			if result.Int != value.Int || result.Neg != value.Neg || result.Big != value.Big || result.Float != value.Float {
+				t.Fatalf("numbers %+v", result)
+			}
+			if !result.At.Equal(value.At) {
+				t.Fatalf("time %v, expected %v", result.At, value.At)
+			}
+			if !sameJSON(t, result.Nested, value.Nested) || !reflect.DeepEqual(result.List, value.List) {
+				t.Fatalf("nested %v %v", result.Nested, result.List)
+			}
+
+			// El JSON de los iteradores y del CDC es el mismo en todos los codecs
+			var item map[string]any
+			err := fs.IterateRange("", "", true, func(id string, data []byte) (bool, error) {
+				return false, json.Unmarshal(data, &item)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameJSON(t, item["nested"], value.Nested) || item["list"] == nil {
+				t.Fatalf("json of the record %v", item)
+			}
+		})
+	}
+}
+
+func TestCodecKeepsIntegers(t *testing.T) {
+	value := map[string]any{
+		"int":    int64(3),
+		"big":    uint64(math.MaxUint64),
+		"nested": map[string]any{"neg": int64(-7), "list": []any{int64(1), "a"}},
+		"at":     time.Unix(1700000000, 5),
+	}
+
+	for _, name := range []string{"msgpack", "gob"} {
+		t.Run(name, func(t *testing.T) {
+			fs := codecStore(t, name)
+			if err := fs.Put("m", value); err != nil {
+				t.Fatal(err)
+			}
+
+			result := map[string]any{}
+			if _, err := fs.Get("m", &result); err != nil {
+				t.Fatal(err)
+			}
+			if result["int"] != int64(3) || result["big"] != uint64(math.MaxUint64) {
+				t.Fatalf("integers %T %v, %T %v", result["int"], result["int"], result["big"], result["big"])
+			}
+			nested, ok := result["nested"].(map[string]any)
+			if !ok || nested["neg"] != int64(-7) || !reflect.DeepEqual(nested["list"], []any{int64(1), "a"}) {
+				t.Fatalf("nested %#v", result["nested"])
+			}
+			if at, ok := result["at"].(time.Time); !ok || !at.Equal(time.Unix(1700000000, 5)) {
+				t.Fatalf("time %#v", result["at"])
+			}
+		})
+	}
+}
+
+func TestAssignNumbers(t *testing.T) {
+	var i int
+	var i8 int8
+	var u uint
+	var i64 int64
+	var f32 float32
+	var f64 float64
+
+	for _, c := range []struct {
+		dest  any
+		value any
+		ok    bool
+	}{
+		{&i, float64(2), true},
+		{&i, float64(1.5), false},
+		{&i, math.Inf(1), false},
+		{&i8, int64(127), true},
+		{&i8, int64(300), false},
+		{&u, int64(-1), false},
+		{&u, float64(-1), false},
+		{&i64, uint64(math.MaxInt64), true},
+		{&i64, uint64(math.MaxUint64), false},
+		{&f32, float64(0.5), true},
+		{&f64, int64(3), true},
+	} {
+		err := assign(c.dest, c.value)
+		if (err == nil) != c.ok {
+			t.Fatalf("%T into %T: %v", c.value, c.dest, err)
+		}
+		if !c.ok {
+			continue
+		}
+
+		got := reflect.ValueOf(c.dest).Elem()
+		if !got.Convert(reflect.TypeOf(c.value)).Equal(reflect.ValueOf(c.value)) {
+			t.Fatalf("%v into %T: %v", c.value, c.dest, got)
+		}
+	}
+
+	// Un decimal guardado no se trunca al leerlo en un entero
+	fs := codecStore(t, "msgpack")
+	fs.Put("f", 1.5)
+	if _, err := fs.Get("f", &i); err == nil {
+		t.Fatalf("1.5 read as %d", i)
+	}
+}
@@ -0,0 +1,398 @@
+package store
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/cgalvisleon/et/logs"
+	"github.com/cgalvisleon/josefina/pkg/msg"
+)
+
+type commitResult struct {
+	refs []*RecordRef
+	err  error
+}
+
+type logRecord struct {
+	id        string
+	data      []byte
+	status    byte
+	flags     byte
+	seq       uint64
+	timestamp int64
+	expires   int64  // vencimiento, los datos ya llevan la fecha
+	check     bool   // el registro se escribe solo si la versión actual es expected
+	expected  uint64 // versión esperada, 0 si la clave no debe existir
+	header    []byte
+	ref       *RecordRef
+}
+
+/**
+* newLogRecord
+* @param id string, data []byte, status, flags byte
+* @return *logRecord
+**/
+func newLogRecord(id string, data []byte, status, flags byte) *logRecord {
+	return &logRecord{
+		id:     id,
+		data:   data,
+		status: status,
+		flags:  flags,
+	}
+}
+
+/**
+* size
+* @return int64
+**/
+func (s *logRecord) size() int64 {
+	return int64(len(s.header)) + int64(len(s.data))
+}
+
+type commitRequest struct {
+	records []*logRecord
+	done    chan commitResult
+}
+
+/**
+* newCommitRequest: The records of a request are written together in the same segment
+* @param records ...*logRecord
+* @return *commitRequest
+**/
+func newCommitRequest(records ...*logRecord) *commitRequest {
+	return &commitRequest{
+		records: records,
+		done:    make(chan commitResult, 1),
+	}
+}
+
+/**
+* prepare: Assigns the sequences and builds the headers of the records
+* @param seq *atomic.Uint64
+* @return int64, error
+**/
+func (s *commitRequest) prepare(seq *atomic.Uint64) (int64, error) {
+	result := int64(0)
+	now := time.Now().UnixNano()
+	for _, rec := range s.records {
+		if rec.status == Active || rec.status == Deleted {
+			rec.seq = seq.Add(1)
+			rec.timestamp = now
+		}
+
+		_, header, err := newRecordHeaderAt(rec)
+		if err != nil {
+			return 0, err
+		}
+		rec.header = header
+		result += rec.size()
+	}
+
+	return result, nil
+}
+
+/**
+* reply
+* @param err error
+**/
+func (s *commitRequest) reply(err error) {
+	if err != nil {
+		s.done <- commitResult{err: err}
+		return
+	}
+
+	refs := make([]*RecordRef, len(s.records))
+	for i, rec := range s.records {
+		refs[i] = rec.ref
+	}
+	s.done <- commitResult{refs: refs}
+}
+
+/**
+* startCommitter
+**/
+func (s *FileStore) startCommitter() {
+	s.commits = make(chan *commitRequest, s.MaxBatch)
+	s.commitWg.Add(1)
+	go s.commitLoop()
+}
+
+/**
+* stopCommitter: Drains the pending requests and stops the loop
+**/
+func (s *FileStore) stopCommitter() {
+	s.closeMu.Lock()
+	if s.closed {
+		s.closeMu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.commits)
+	s.closeMu.Unlock()
+
+	s.commitWg.Wait()
+}
+
+/**
+* isClosed
+* @return bool
+**/
+func (s *FileStore) isClosed() bool {
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+
+	return s.closed
+}
+
+/**
+* commitLoop: Groups the requests that arrive while a batch is being written
+**/
+func (s *FileStore) commitLoop() {
+	defer s.commitWg.Done()
+
+	for req := range s.commits {
+		batch := []*commitRequest{req}
+	drain:
+		for len(batch) < s.MaxBatch {
+			select {
+			case next, ok := <-s.commits:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+
+		s.commitBatch(batch)
+	}
+}
+
+/**
+* commitBatch: Writes the batch with one write and one fsync per segment, a batch that fails is removed from
+* the segment so it does not come back when the log is replayed
+* @param batch []*commitRequest
+**/
+func (s *FileStore) commitBatch(batch []*commitRequest) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	fail := func(from int, err error) {
+		for _, req := range batch[from:] {
+			req.reply(err)
+		}
+	}
+
+	if err := s.writable(); err != nil {
+		fail(0, err)
+		return
+	}
+
+	pending := make([]*commitRequest, 0, len(batch))
+	buf := make([]byte, 0)
+	versions := make(map[string]uint64) // versiones escritas por el grupo y aún no aplicadas
+
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+
+		start := s.active.size
+		err := s.active.Write(buf)
+		if err == nil {
+			// Solo los bytes escritos cuentan en el tamaño
+			s.active.size += int64(len(buf))
+			s.Size += int64(len(buf))
+			if s.SyncOnWrite {
+				err = s.active.Sync()
+			}
+		}
+		if err != nil {
+			s.discard(start)
+		}
+
+		for _, req := range pending {
+			if err != nil {
+				req.reply(err)
+				continue
+			}
+			s.applyRecords(req.records)
+			s.publish(req.records)
+			req.reply(nil)
+		}
+
+		pending = pending[:0]
+		buf = buf[:0]
+		return err
+	}
+
+	for i, req := range batch {
+		if err := s.checkVersions(req, versions); err != nil {
+			req.reply(err)
+			continue
+		}
+
+		requestSize, err := req.prepare(&s.seq)
+		if err != nil {
+			req.reply(err)
+			continue
+		}
+
+		totalSize := s.active.size + int64(len(buf)) + requestSize
+		if totalSize > s.MaxSegment {
+			if err := flush(); err != nil {
+				fail(i, err)
+				return
+			}
+
+			if err := s.newSegment(); err != nil {
+				fail(i, err)
+				return
+			}
+
+			if err := s.createSnapshot(); err != nil {
+				fail(i, err)
+				return
+			}
+		}
+
+		for _, rec := range req.records {
+			rec.ref = &RecordRef{
+				segment: len(s.segments) - 1,
+				offset:  s.active.size + int64(len(buf)),
+				length:  uint32(len(rec.data)),
+				seq:     rec.seq,
+				expires: rec.expires,
+			}
+			buf = append(buf, rec.header...)
+			buf = append(buf, rec.data...)
+			if rec.status == Active {
+				versions[rec.id] = rec.seq
+			} else if rec.status == Deleted {
+				versions[rec.id] = 0
+			}
+		}
+		pending = append(pending, req)
+	}
+
+	if err := flush(); err != nil {
+		return
+	}
+
+	n := s.Count()
+	threshold := int64(float64(n) * 0.1) // 10% del tamaño del índice
+	if s.tombStones.Load() > threshold {
+		s.compactor.notify()
+	}
+}
+
+/**
+* discard: Truncates the active segment to the start of a batch that did not become durable, the index was not
+* changed yet. When the truncation can not be made durable the store is marked failed and stops writing, the
+* caller holds writeMu
+* @param start int64
+**/
+func (s *FileStore) discard(start int64) {
+	s.Size -= s.active.size - start
+	s.active.size = start
+
+	err := s.active.file.Truncate(start)
+	if err == nil && s.SyncOnWrite {
+		err = s.active.Sync()
+	}
+	if err != nil {
+		s.failed.Store(true)
+		logs.Alertf("commit:%s:%s:%s %s: %v", s.Path, s.Name, s.active.name, msg.MSG_STORE_FAILED, err)
+	}
+}
+
+/**
+* applyRecords: Applies the durable records of a request, the readers see all or none
+* @param records []*logRecord
+**/
+func (s *FileStore) applyRecords(records []*logRecord) {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	for _, rec := range records {
+		s.applyRecord(rec.id, rec.status, rec.ref)
+	}
+}
+
+/**
+* applyRecord: Applies a durable record to the index, the caller holds indexMu
+* @param id string, status byte, ref *RecordRef
+**/
+func (s *FileStore) applyRecord(id string, status byte, ref *RecordRef) {
+	_, exists := s.index.Get(id)
+	switch status {
+	case Active:
+		if exists {
+			s.tombStones.Add(1)
+		} else {
+			s.WAL++
+		}
+		s.putIndex(id, ref)
+	case Deleted:
+		if exists {
+			s.tombStones.Add(1)
+		}
+		s.deleteIndex(id)
+	}
+}
+
+/**
+* writable: Returns an error when the store was opened read only or failed to undo a write
+* @return error
+**/
+func (s *FileStore) writable() error {
+	if s.mode != modeWrite {
+		return errors.New(msg.MSG_STORE_READ_ONLY)
+	}
+
+	if s.failed.Load() {
+		return errors.New(msg.MSG_STORE_FAILED)
+	}
+
+	return nil
+}
+
+/**
+* commit: Enqueues the records and waits until they are durable
+* @param records ...*logRecord
+* @return []*RecordRef, error
+**/
+func (s *FileStore) commit(records ...*logRecord) ([]*RecordRef, error) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+
+	req := newCommitRequest(records...)
+
+	s.closeMu.RLock()
+	if s.closed {
+		s.closeMu.RUnlock()
+		return nil, errors.New(msg.MSG_STORE_CLOSED)
+	}
+	s.commits <- req
+	s.closeMu.RUnlock()
+
+	result := <-req.done
+	return result.refs, result.err
+}
+
+/**
+* appendRecord: Enqueues a record and waits until it is durable
+* @param id string, data []byte, status, flags byte
+* @return *RecordRef, error
+**/
+func (s *FileStore) appendRecord(id string, data []byte, status, flags byte) (*RecordRef, error) {
+	refs, err := s.commit(newLogRecord(id, data, status, flags))
+	if err != nil {
+		return nil, err
+	}
+
+	return refs[0], nil
+}
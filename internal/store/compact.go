@@ -2,35 +2,62 @@ package store
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
-	"sort"
+	"time"
 
 	"github.com/cgalvisleon/et/logs"
 )
 
 /**
-* Compact
+* Compact: Rewrites the store with the live records and removes the orphaned blobs
 * @return error
 **/
 func (s *FileStore) Compact() error {
-	s.indexMu.RLock()
-	keys := make([]string, 0)
-	indexCopy := make(map[string]*RecordRef, len(s.index))
-	for k, v := range s.index {
-		keys = append(keys, k)
-		indexCopy[k] = v
+	if err := s.compact(); err != nil {
+		return err
+	}
+
+	_, err := s.CollectBlobs()
+	return err
+}
+
+/**
+* compact
+* @return error
+**/
+func (s *FileStore) compact() error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+
+	if !s.compacting.CompareAndSwap(false, true) {
+		return nil // ya hay una compactación en curso
+	}
+	defer s.compacting.Store(false)
+
+	// Los blobs reescritos con la llave actual no se recolectan hasta el swap
+	s.blobMu.RLock()
+	defer s.blobMu.RUnlock()
+
+	// Las escrituras esperan a que termine la compactación
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if s.isClosed() || s.pins.Load() > 0 {
+		return nil // hay lectores del log, se compacta en la siguiente oportunidad
 	}
-	s.indexMu.RUnlock()
 
 	// Orden determinista
-	sort.Strings(keys)
+	records := s.getRecords(true, 0, 0)
+
+	// Las secuencias hasta el horizonte ya no se pueden reproducir desde el log
+	horizon := s.seq.Load()
 
 	// Directorio temporal
 	name := fmt.Sprintf("segments-%s.tmp", s.Name)
 	tmpDir := filepath.Join(s.PathCompact, name)
-	os.RemoveAll(tmpDir)
-	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+	s.fs.RemoveAll(tmpDir)
+	if err := s.fs.MkdirAll(tmpDir, 0755); err != nil {
 		return err
 	}
 
@@ -43,12 +70,15 @@ func (s *FileStore) Compact() error {
 		name := fmt.Sprintf("segment-%06d.dat", len(newSegments)+1)
 		path := filepath.Join(tmpDir, name)
 
-		fd, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		seg, err := openSegment(s.fs, path, name, horizon)
 		if err != nil {
 			return err
 		}
+		if err := seg.markCompacted(); err != nil {
+			return err
+		}
 
-		current = newSegment(fd, 0, name)
+		current = seg
 		newSegments = append(newSegments, current)
 		return nil
 	}
@@ -57,43 +87,44 @@ func (s *FileStore) Compact() error {
 		return err
 	}
 
-	newIndex := make(map[string]*RecordRef, len(indexCopy))
+	compacted := newIndex()
 
 	n := 0
-	for _, id := range keys {
-		ref := indexCopy[id]
+	now := time.Now().UnixNano()
+	for _, item := range records {
+		id, ref := item.key, item.ref
+		if ref.expired(now) {
+			continue // los vencidos no se copian
+		}
 		oldSeg := s.segments[ref.segment]
 
-		// Leer header real
-		fixed := make([]byte, fixedHeaderSize)
-		if _, err := oldSeg.ReadAt(fixed, ref.offset); err != nil {
+		// Leer el registro, recomprimir con la compresión actual y cifrar con la llave actual
+		h, stored, err := oldSeg.readRecordAt(ref.offset, oldSeg.size)
+		if err != nil {
 			return err
 		}
-
-		idLen := getUint16(fixed[8:10])
-		payloadOffset := ref.offset + int64(fixedHeaderSize+idLen)
-
-		data := make([]byte, ref.length)
-		if ref.length > 0 {
-			if _, err := oldSeg.ReadAt(data, payloadOffset); err != nil {
-				return err
-			}
+		data, flags, err := s.rewrap(id, stored, h.Flags, true)
+		if err != nil {
+			return err
 		}
 
 		// Rotar segmento si es necesario
-		recordSize := int64(fixedHeaderSize) + int64(idLen) + int64(len(data))
+		recordSize := recordHeaderSize(segmentVersion, len(id)) + int64(len(data))
 		if current.size+recordSize > s.MaxSegment {
 			if err := createSegment(); err != nil {
 				return err
 			}
 		}
 
-		newRef, err := current.WriteRecord(id, data, Active)
+		rec := newLogRecord(id, data, Active, flags)
+		rec.seq, rec.timestamp, rec.expires = h.Seq, h.Timestamp, ref.expires
+		newRef, err := current.WriteRecord(rec)
 		if err != nil {
 			return err
 		}
 		newRef.segment = len(newSegments) - 1
-		newIndex[id] = newRef
+		current.live += recordSize
+		compacted.Set(id, newRef)
 		if s.isDebug {
 			logs.Debug("compacted:", s.Path, ":", s.Name, ":ID:", id, ":segment:", newRef.segment, ":offset:", newRef.offset, ":size:", newRef.length)
 		}
@@ -101,32 +132,98 @@ func (s *FileStore) Compact() error {
 		n++
 	}
 
-	// Swap atómico
-	s.writeMu.Lock()
-	defer s.writeMu.Unlock()
+	// Los segmentos nuevos deben ser durables antes de reemplazar a los anteriores
+	for _, seg := range newSegments {
+		if err := seg.Sync(); err != nil {
+			return err
+		}
+	}
 
-	for _, seg := range s.segments {
-		seg.file.Close()
+	// El snapshot tiene las posiciones anteriores, sin él la apertura reproduce el log
+	if err := s.fs.Remove(s.snapshotPath()); err != nil && s.fs.Exists(s.snapshotPath()) {
+		return err
 	}
 
-	oldDir := filepath.Join(s.Path, "segments.old")
-	os.RemoveAll(oldDir)
+	// Swap, los lectores mantienen indexMu mientras leen, si se interrumpe la apertura lo termina o lo deshace
+	s.indexMu.Lock()
+	oldDir := s.oldSegmentsPath()
+	s.fs.RemoveAll(oldDir)
 
-	if err := os.Rename(s.PathSegments, oldDir); err != nil {
+	if err := s.fs.Rename(s.PathSegments, oldDir); err != nil {
+		s.indexMu.Unlock()
 		return err
 	}
-	if err := os.Rename(tmpDir, s.PathSegments); err != nil {
+	if err := s.fs.Rename(tmpDir, s.PathSegments); err != nil {
+		// Los segmentos anteriores siguen abiertos y vuelven a su lugar
+		if err := s.fs.Rename(oldDir, s.PathSegments); err != nil {
+			logs.Alert(err)
+		}
+		s.indexMu.Unlock()
 		return err
 	}
 
+	for _, seg := range s.segments {
+		seg.file.Close()
+	}
+	if err := s.fs.RemoveAll(oldDir); err != nil {
+		logs.Alert(err)
+	}
+
 	// Activar nuevos segmentos
-	s.indexMu.Lock()
-	s.index = newIndex
-	s.keys = keys
+	s.index = compacted
+	s.cache.reset()
 	s.segments = newSegments
 	s.active = newSegments[len(newSegments)-1]
-	s.TombStones = 0
+	s.tombStones.Store(0)
+	s.Size = 0
+	for _, seg := range newSegments {
+		s.Size += seg.size
+	}
+	s.lastCompaction = time.Now()
 	s.indexMu.Unlock()
 
+	return s.createSnapshot()
+}
+
+/**
+* oldSegmentsPath: Returns the directory of the segments replaced by a compaction until the swap ends
+* @return string
+**/
+func (s *FileStore) oldSegmentsPath() string {
+	return filepath.Join(s.Path, s.Name, "segments.old")
+}
+
+/**
+* recoverSwap: Ends or undoes a compaction swap stopped by a crash and removes the unfinished copies,
+* the caller holds the lock of the directory
+* @return error
+**/
+func (s *FileStore) recoverSwap() error {
+	oldDir := s.oldSegmentsPath()
+	if s.fs.Exists(oldDir) {
+		if !s.fs.Exists(s.PathSegments) {
+			// Se detuvo entre los dos renames, los segmentos anteriores están completos
+			if err := s.fs.Rename(oldDir, s.PathSegments); err != nil {
+				return err
+			}
+		} else if err := s.fs.RemoveAll(oldDir); err != nil {
+			return err
+		}
+	}
+
+	if !s.fs.Exists(s.PathCompact) {
+		return nil
+	}
+
+	names, err := s.fs.ReadDir(s.PathCompact)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := s.fs.RemoveAll(filepath.Join(s.PathCompact, name)); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
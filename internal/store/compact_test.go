@@ -0,0 +1,133 @@
+package store
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/**
+* compactable: Opens the store of the fault tests with five batches and a deleted record
+* @param t *testing.T, fsys *FaultFS
+* @return *FileStore
+**/
+func compactable(t *testing.T, fsys *FaultFS) *FileStore {
+	t.Helper()
+	fs, err := OpenFS(fsys, "/db", "fault", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if acked := writeBatches(fs, 5); acked != 5 {
+		t.Fatalf("writes failed without faults: %d", acked)
+	}
+	if _, err := fs.Delete("b00-0"); err != nil {
+		t.Fatal(err)
+	}
+
+	return fs
+}
+
+/**
+* expectCompacted: Checks the records of compactable
+* @param t *testing.T, fs *FileStore
+**/
+func expectCompacted(t *testing.T, fs *FileStore) {
+	t.Helper()
+	if fs.IsExist("b00-0") {
+		t.Fatal("deleted record is back")
+	}
+	for _, id := range []string{"b00-1", "b02-0", "b04-2"} {
+		if !fs.IsExist(id) {
+			t.Fatalf("record %s lost", id)
+		}
+	}
+}
+
+func TestCompactSwapRollback(t *testing.T) {
+	fsys := NewFaultFS()
+	fs := compactable(t, fsys)
+	defer func() { fs.Close() }()
+
+	// La copia compactada no llega a su lugar, los segmentos anteriores vuelven al suyo
+	fsys.FailWhen(func(op, name string) error {
+		if op == OpRename && strings.HasSuffix(name, ".tmp") {
+			return ErrInjectedFault
+		}
+		return nil
+	})
+	if err := fs.Compact(); err == nil {
+		t.Fatal("compaction succeeded with the swap failing")
+	}
+	fsys.Heal()
+
+	expectCompacted(t, fs)
+	if err := fs.Put("after", "rollback"); err != nil {
+		t.Fatal(err)
+	}
+	if fsys.Exists(fs.oldSegmentsPath()) {
+		t.Fatal("old segments left after the rollback")
+	}
+
+	if err := fs.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if fsys.Exists(fs.oldSegmentsPath()) {
+		t.Fatal("old segments left after the swap")
+	}
+	expectCompacted(t, fs)
+}
+
+func TestCompactSwapCrash(t *testing.T) {
+	fsys := NewFaultFS()
+	fs := compactable(t, fsys)
+
+	// El proceso se detiene entre los dos renames del swap
+	moved := false
+	fsys.FailWhen(func(op, name string) error {
+		if op != OpRename {
+			return nil
+		}
+		if moved {
+			return ErrInjectedFault
+		}
+		moved = strings.HasSuffix(name, "segments")
+		return nil
+	})
+	if err := fs.Compact(); err == nil {
+		t.Fatal("compaction succeeded with the swap failing")
+	}
+	if fsys.Exists(fs.PathSegments) {
+		t.Fatal("the swap was not stopped between the renames")
+	}
+
+	fs = crashAndReopen(t, fsys, fs)
+	defer func() { fs.Close() }()
+	expectCompacted(t, fs)
+	if fsys.Exists(fs.oldSegmentsPath()) {
+		t.Fatal("old segments not moved back")
+	}
+
+	// Un swap terminado que no alcanzó a borrar los segmentos anteriores
+	if err := fs.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.MkdirAll(fs.oldSegmentsPath(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(fsys, filepath.Join(fs.oldSegmentsPath(), "000001.seg"), []byte("stale")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(fsys, filepath.Join(fs.PathCompact, "segments-fault.tmp"), []byte("stale")); err != nil {
+		t.Fatal(err)
+	}
+
+	fs = crashAndReopen(t, fsys, fs)
+	expectCompacted(t, fs)
+	if fsys.Exists(fs.oldSegmentsPath()) {
+		t.Fatal("old segments of a finished swap not removed")
+	}
+	if names, _ := fsys.ReadDir(fs.PathCompact); len(names) != 0 {
+		t.Fatalf("unfinished copies left: %v", names)
+	}
+}
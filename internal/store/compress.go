@@ -0,0 +1,126 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/cgalvisleon/josefina/pkg/msg"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressNone   byte = 0
+	CompressSnappy byte = 1
+	CompressZstd   byte = 2
+
+	flagCompressMask byte = 0x03
+	minCompressSize       = 128 // por debajo no vale la pena comprimir
+)
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+/**
+* initZstd: Creates the shared zstd encoder and decoder on first use
+* @return error
+**/
+func initZstd() error {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	})
+
+	return zstdErr
+}
+
+/**
+* compressionCode: Returns the code of the compression name, none if unknown
+* @param name string
+* @return byte
+**/
+func compressionCode(name string) byte {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "snappy":
+		return CompressSnappy
+	case "zstd":
+		return CompressZstd
+	default:
+		return CompressNone
+	}
+}
+
+/**
+* compressionName
+* @param code byte
+* @return string
+**/
+func compressionName(code byte) string {
+	switch code {
+	case CompressSnappy:
+		return "snappy"
+	case CompressZstd:
+		return "zstd"
+	default:
+		return "none"
+	}
+}
+
+/**
+* compress: Compresses data with the codec, returns the data as is when it does not get smaller
+* @param data []byte, code byte
+* @return []byte, byte
+**/
+func compress(data []byte, code byte) ([]byte, byte) {
+	if code == CompressNone || len(data) < minCompressSize {
+		return data, CompressNone
+	}
+
+	var result []byte
+	switch code {
+	case CompressSnappy:
+		result = snappy.Encode(nil, data)
+	case CompressZstd:
+		if err := initZstd(); err != nil {
+			return data, CompressNone
+		}
+		result = zstdEncoder.EncodeAll(data, make([]byte, 0, len(data)/2))
+	default:
+		return data, CompressNone
+	}
+
+	if len(result) >= len(data) {
+		return data, CompressNone
+	}
+
+	return result, code
+}
+
+/**
+* decompress: Returns the original data of a stored payload
+* @param data []byte, flags byte
+* @return []byte, error
+**/
+func decompress(data []byte, flags byte) ([]byte, error) {
+	switch flags & flagCompressMask {
+	case CompressNone:
+		return data, nil
+	case CompressSnappy:
+		return snappy.Decode(nil, data)
+	case CompressZstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdDecoder.DecodeAll(data, nil)
+	default:
+		return nil, errors.New(msg.MSG_UNKNOWN_COMPRESSION)
+	}
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+/**
+* indexRef: Returns the reference of the key in the index
+* @param t *testing.T, fs *FileStore, id string
+* @return *RecordRef
+**/
+func indexRef(t *testing.T, fs *FileStore, id string) *RecordRef {
+	t.Helper()
+	result, ok := fs.index.Get(id)
+	if !ok {
+		t.Fatalf("%s not in the index", id)
+	}
+
+	return result
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	for _, name := range []string{"none", "snappy", "zstd"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("STORE_COMPRESSION", name)
+			dir := t.TempDir()
+			fs, err := Open(dir, "compress", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Los valores cortos o que no se reducen se guardan sin comprimir
+			values := map[string]string{
+				"short": "abc",
+				"long":  strings.Repeat(name+" ", 100),
+			}
+			for id, value := range values {
+				if err := fs.Put(id, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			h, err := fs.segments[0].ReadHeader(indexRef(t, fs, "long"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Flags&flagCompressMask != compressionCode(name) {
+				t.Fatalf("long value stored with compression %d", h.Flags&flagCompressMask)
+			}
+			h, err = fs.segments[0].ReadHeader(indexRef(t, fs, "short"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Flags&flagCompressMask != CompressNone {
+				t.Fatal("short value compressed")
+			}
+
+			// Otra compresión al abrir no cambia la lectura de los registros escritos
+			if err := fs.Close(); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("STORE_COMPRESSION", "none")
+			fs, err = Open(dir, "compress", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fs.Close()
+
+			for id, value := range values {
+				var got string
+				exists, err := fs.Get(id, &got)
+				if err != nil || !exists || got != value {
+					t.Fatalf("%s: %q %v %v", id, got, exists, err)
+				}
+			}
+		})
+	}
+}
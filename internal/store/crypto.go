@@ -0,0 +1,414 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cgalvisleon/et/envar"
+	"github.com/cgalvisleon/josefina/pkg/msg"
+)
+
+const (
+	flagEncrypted      byte = 0x40 // los datos son un sobre cifrado
+	envelopeKeySize         = 4    // id de la llave
+	envelopeNonceSize       = 12   // nonce de AES-GCM
+	envelopeHeaderSize      = envelopeKeySize + envelopeNonceSize
+	snapshotSealed          = "SNPX" // cabecera de los snapshots cifrados
+)
+
+/**
+* Keyring: Encryption keys of the store by id, the current key encrypts the new data
+**/
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    map[uint32]cipher.AEAD
+	current uint32
+}
+
+/**
+* NewKeyring
+* @return *Keyring
+**/
+func NewKeyring() *Keyring {
+	return &Keyring{
+		keys: make(map[uint32]cipher.AEAD),
+	}
+}
+
+/**
+* Add: Adds an AES key of 16, 24 or 32 bytes, the first key added is the current one
+* @param id uint32, key []byte
+* @return error
+**/
+func (s *Keyring) Add(id uint32, key []byte) error {
+	if id == 0 {
+		return fmt.Errorf(msg.MSG_INVALID_KEY, "0")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf(msg.MSG_INVALID_KEY, strconv.FormatUint(uint64(id), 10))
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[id] = aead
+	if s.current == 0 {
+		s.current = id
+	}
+
+	return nil
+}
+
+/**
+* Use: Sets the key that encrypts the new data, the rest are kept to read the old data
+* @param id uint32
+* @return error
+**/
+func (s *Keyring) Use(id uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[id]; !ok {
+		return fmt.Errorf(msg.MSG_KEY_NOT_FOUND, id)
+	}
+
+	s.current = id
+	return nil
+}
+
+/**
+* Current: Returns the id of the current key, 0 when the keyring is empty
+* @return uint32
+**/
+func (s *Keyring) Current() uint32 {
+	if s == nil {
+		return 0
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.current
+}
+
+/**
+* Len
+* @return int
+**/
+func (s *Keyring) Len() int {
+	if s == nil {
+		return 0
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.keys)
+}
+
+/**
+* seal: Encrypts data with the current key, the envelope carries the key id and the nonce, aad is
+* authenticated with the data without being encrypted
+* @param data, aad []byte
+* @return []byte, error
+**/
+func (s *Keyring) seal(data, aad []byte) ([]byte, error) {
+	s.mu.RLock()
+	id := s.current
+	aead, ok := s.keys[id]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf(msg.MSG_KEY_NOT_FOUND, id)
+	}
+
+	result := make([]byte, envelopeHeaderSize, envelopeHeaderSize+len(data)+aead.Overhead())
+	putUint32(result[0:envelopeKeySize], id)
+	if _, err := rand.Read(result[envelopeKeySize:envelopeHeaderSize]); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(result, result[envelopeKeySize:envelopeHeaderSize], data, aad), nil
+}
+
+/**
+* open: Decrypts an envelope with the key of its id, aad must be the one it was sealed with
+* @param data, aad []byte
+* @return []byte, error
+**/
+func (s *Keyring) open(data, aad []byte) ([]byte, error) {
+	if len(data) < envelopeHeaderSize {
+		return nil, errors.New(msg.MSG_CORRUPTED_RECORD)
+	}
+
+	id := envelopeKey(data)
+	var aead cipher.AEAD
+	ok := false
+	if s != nil {
+		s.mu.RLock()
+		aead, ok = s.keys[id]
+		s.mu.RUnlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf(msg.MSG_KEY_NOT_FOUND, id)
+	}
+
+	result, err := aead.Open(nil, data[envelopeKeySize:envelopeHeaderSize], data[envelopeHeaderSize:], aad)
+	if err != nil {
+		return nil, errors.New(msg.MSG_DECRYPTION_FAILED)
+	}
+
+	return result, nil
+}
+
+/**
+* recordAAD: Returns the fields of the record authenticated with its payload, the id, the codec, the
+* compression and the expiration date, so an envelope can not be moved to another key or header. The ids
+* are not encrypted, the index is rebuilt from them when the segments are read
+* @param id string, flags byte, expires int64
+* @return []byte
+**/
+func recordAAD(id string, flags byte, expires int64) []byte {
+	result := make([]byte, 1+expiresSize, 1+expiresSize+len(id))
+	result[0] = flags & (flagCodecMask | flagCompressMask)
+	putUint64(result[1:], uint64(expires))
+	return append(result, id...)
+}
+
+/**
+* envelopeKey: Returns the key id of an envelope
+* @param data []byte
+* @return uint32
+**/
+func envelopeKey(data []byte) uint32 {
+	if len(data) < envelopeKeySize {
+		return 0
+	}
+
+	return getUint32(data[0:envelopeKeySize])
+}
+
+/**
+* parseKeys: Adds the keys of the lines "id:base64", the empty lines and the comments with # are skipped
+* @param keyring *Keyring, text string, sep string
+* @return error
+**/
+func parseKeys(keyring *Keyring, text, sep string) error {
+	for _, line := range strings.Split(text, sep) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return fmt.Errorf(msg.MSG_INVALID_KEY, line)
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSpace(name), 10, 32)
+		if err != nil {
+			return fmt.Errorf(msg.MSG_INVALID_KEY, name)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf(msg.MSG_INVALID_KEY, name)
+		}
+
+		if err := keyring.Add(uint32(id), key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/**
+* loadKeyring: Loads the keys of the STORE_KEYFILE file and of STORE_KEYS, STORE_KEY_ID selects the current key, nil without keys
+* @return *Keyring, error
+**/
+func loadKeyring() (*Keyring, error) {
+	result := NewKeyring()
+	keyfile := envar.GetStr("STORE_KEYFILE", "")
+	if keyfile != "" {
+		bt, err := os.ReadFile(keyfile)
+		if err != nil {
+			return nil, err
+		}
+		if err := parseKeys(result, string(bt), "\n"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := parseKeys(result, envar.GetStr("STORE_KEYS", ""), ","); err != nil {
+		return nil, err
+	}
+
+	if result.Len() == 0 {
+		return nil, nil
+	}
+
+	if id := envar.GetInt("STORE_KEY_ID", 0); id != 0 {
+		if err := result.Use(uint32(id)); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+/**
+* SetKeyring: Sets the keys of the store, the data written from now on is encrypted with the current key, nil disables the encryption.
+* Only the values are encrypted, the keys, the headers and the expiration dates stay in plain text and are authenticated with the value.
+* The keys keep their order for the ranges, so the keys of the indexes show the values of the indexed fields
+* @param keyring *Keyring
+**/
+func (s *FileStore) SetKeyring(keyring *Keyring) {
+	if keyring != nil && keyring.Len() == 0 {
+		keyring = nil
+	}
+
+	s.keyring.Store(keyring)
+	s.Encrypted = keyring != nil
+}
+
+/**
+* currentKeyring
+* @return *Keyring
+**/
+func (s *FileStore) currentKeyring() *Keyring {
+	return s.keyring.Load()
+}
+
+/**
+* seal: Encrypts the payload of a record when the store has keys
+* @param data []byte, flags byte, aad []byte
+* @return []byte, byte, error
+**/
+func (s *FileStore) seal(data []byte, flags byte, aad []byte) ([]byte, byte, error) {
+	keyring := s.currentKeyring()
+	if keyring == nil {
+		return data, flags, nil
+	}
+
+	sealed, err := keyring.seal(data, aad)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return sealed, flags | flagEncrypted, nil
+}
+
+/**
+* unseal: Decrypts the payload of a record and decompresses it
+* @param data []byte, flags byte, aad []byte
+* @return []byte, error
+**/
+func (s *FileStore) unseal(data []byte, flags byte, aad []byte) ([]byte, error) {
+	if flags&flagEncrypted != 0 {
+		var err error
+		data, err = s.currentKeyring().open(data, aad)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return decompress(data, flags)
+}
+
+/**
+* stale: Returns if the payload is not encrypted with the current key
+* @param data []byte, flags byte
+* @return bool
+**/
+func (s *FileStore) stale(data []byte, flags byte) bool {
+	current := s.currentKeyring().Current()
+	if flags&flagEncrypted == 0 {
+		return current != 0
+	}
+
+	return envelopeKey(data) != current
+}
+
+/**
+* rewrap: Returns the stored data of an active record encrypted with the current key, recompress applies the current compression, the caller holds blobMu
+* @param id string, stored []byte, flags byte, recompress bool
+* @return []byte, byte, error
+**/
+func (s *FileStore) rewrap(id string, stored []byte, flags byte, recompress bool) ([]byte, byte, error) {
+	payload, expires, err := splitExpiry(stored, flags)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	content := payload
+	if flags&flagBlob != 0 {
+		content, err = s.readBlob(payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		recompress = false // los blobs solo se reescriben al cambiar la llave
+	}
+
+	if !recompress && !s.stale(content, flags) {
+		return stored, flags, nil
+	}
+
+	raw, err := s.unseal(content, flags, recordAAD(id, flags, expires))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return s.encodeValue(id, raw, flags&flagCodecMask, expires)
+}
+
+/**
+* sealSnapshot: Encrypts the snapshot when the store has keys
+* @param data []byte
+* @return []byte, error
+**/
+func (s *FileStore) sealSnapshot(data []byte) ([]byte, error) {
+	keyring := s.currentKeyring()
+	if keyring == nil {
+		return data, nil
+	}
+
+	sealed, err := keyring.seal(data, []byte(snapshotSealed))
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(snapshotSealed), sealed...), nil
+}
+
+/**
+* unsealSnapshot: Decrypts the encrypted snapshots, the plain ones are returned as they are only when the store
+* has no keys, otherwise a replaced snapshot could point the index to any record
+* @param data []byte
+* @return []byte, error
+**/
+func (s *FileStore) unsealSnapshot(data []byte) ([]byte, error) {
+	keyring := s.currentKeyring()
+	if len(data) < len(snapshotSealed) || string(data[:len(snapshotSealed)]) != snapshotSealed {
+		if keyring != nil {
+			return nil, errors.New(msg.MSG_SNAPSHOT_NOT_SEALED)
+		}
+		return data, nil
+	}
+
+	return keyring.open(data[len(snapshotSealed):], []byte(snapshotSealed))
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+var testKey = bytes.Repeat([]byte{0x2a}, 32)
+
+/**
+* testKeyring: Returns a keyring with the key 1
+* @param t *testing.T
+* @return *Keyring
+**/
+func testKeyring(t *testing.T) *Keyring {
+	t.Helper()
+	result := NewKeyring()
+	if err := result.Add(1, testKey); err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+func TestCryptoEnvelopeBoundToRecord(t *testing.T) {
+	fs, err := OpenMemory("crypto", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	fs.SetKeyring(testKeyring(t))
+
+	expires := time.Now().Add(time.Hour).UnixNano()
+	stored, flags, err := fs.encodeValue("a", []byte(`"secret"`), CodecJSON<<flagCodecShift, expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags&flagEncrypted == 0 || bytes.Contains(stored, []byte("secret")) {
+		t.Fatal("value not encrypted")
+	}
+
+	data, err := fs.decodeRecord("a", stored, flags)
+	if err != nil || string(data) != `"secret"` {
+		t.Fatalf("record not decrypted: %q %v", data, err)
+	}
+
+	// El sobre no sirve con otra clave, otra fecha de vencimiento u otro codec
+	if _, err := fs.decodeRecord("b", stored, flags); err == nil {
+		t.Fatal("envelope accepted under another key")
+	}
+
+	moved := append([]byte{}, stored...)
+	putUint64(moved[0:expiresSize], uint64(expires+int64(time.Hour)))
+	if _, err := fs.decodeRecord("a", moved, flags); err == nil {
+		t.Fatal("envelope accepted with another expiration date")
+	}
+
+	if _, err := fs.decodeRecord("a", stored, flags&^flagCodecMask|CodecGob<<flagCodecShift); err == nil {
+		t.Fatal("envelope accepted with another codec")
+	}
+}
+
+func TestCryptoRoundTrip(t *testing.T) {
+	t.Setenv("STORE_KEYS", "1:"+base64.StdEncoding.EncodeToString(testKey))
+	fsys := NewMemFS()
+	fs, err := OpenFS(fsys, "/db", "crypto", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fs.Encrypted {
+		t.Fatal("keys of the environment not loaded")
+	}
+
+	if err := fs.Put("a", "plain"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.PutWithTTL("b", "expiring", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err = OpenFS(fsys, "/db", "crypto", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	// La compactación vuelve a cifrar cada valor con su clave
+	if err := fs.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, expected := range map[string]string{"a": "plain", "b": "expiring"} {
+		var value string
+		exists, err := fs.Get(id, &value)
+		if err != nil || !exists || value != expected {
+			t.Fatalf("%s: %q %v %v", id, value, exists, err)
+		}
+	}
+}
+
+func TestCryptoPlainSnapshotReplaysLog(t *testing.T) {
+	t.Setenv("STORE_KEYS", "1:"+base64.StdEncoding.EncodeToString(testKey))
+	fsys := NewMemFS()
+	fs, err := OpenFS(fsys, "/db", "crypto", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Put("a", "one")
+	fs.Put("b", "two")
+	if err := fs.CreateSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Un snapshot en texto plano que cruza las entradas reemplaza al cifrado
+	data, err := readFile(fsys, fs.snapshotPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = fs.unsealSnapshot(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := decodeSnapshot(data, len(fs.segments))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range state.entries {
+		state.entries[i].key = map[string]string{"a": "b", "b": "a"}[state.entries[i].key]
+	}
+	path := fs.snapshotPath()
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(fsys, path, encodeSnapshot(state)); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err = OpenFS(fsys, "/db", "crypto", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	for id, expected := range map[string]string{"a": "one", "b": "two"} {
+		var value string
+		exists, err := fs.Get(id, &value)
+		if err != nil || !exists || value != expected {
+			t.Fatalf("%s: %q %v %v", id, value, exists, err)
+		}
+	}
+}
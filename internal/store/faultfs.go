@@ -0,0 +1,226 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/cgalvisleon/josefina/pkg/msg"
+)
+
+const (
+	OpOpen     = "open"
+	OpWrite    = "write"
+	OpSync     = "sync"
+	OpTruncate = "truncate"
+	OpRename   = "rename"
+	OpRemove   = "remove"
+	OpMkdir    = "mkdir"
+)
+
+var ErrInjectedFault = errors.New(msg.MSG_INJECTED_FAULT)
+
+type FaultFn func(op, name string) error
+
+/**
+* FaultFS: Filesystem in memory that fails at the chosen points and drops the unsynced writes on a crash
+**/
+type FaultFS struct {
+	*MemFS
+	mu    sync.Mutex
+	fault FaultFn
+	count map[string]int
+}
+
+/**
+* NewFaultFS
+* @return *FaultFS
+**/
+func NewFaultFS() *FaultFS {
+	return &FaultFS{
+		MemFS: NewMemFS(),
+		count: make(map[string]int),
+	}
+}
+
+/**
+* FailWhen: Sets the function that decides the failures, nil removes it
+* @param fn FaultFn
+**/
+func (s *FaultFS) FailWhen(fn FaultFn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fault = fn
+}
+
+/**
+* FailAfter: The first n operations op succeed and the following fail
+* @param op string, n int
+**/
+func (s *FaultFS) FailAfter(op string, n int) {
+	s.mu.Lock()
+	s.count = make(map[string]int)
+	s.mu.Unlock()
+
+	s.FailWhen(func(o, name string) error {
+		if o != op {
+			return nil
+		}
+
+		s.count[op]++
+		if s.count[op] > n {
+			return ErrInjectedFault
+		}
+		return nil
+	})
+}
+
+/**
+* Heal: Removes the failures
+**/
+func (s *FaultFS) Heal() {
+	s.FailWhen(nil)
+}
+
+/**
+* Crash: Simulates a power loss, the unsynced writes are lost, the open handles are invalidated and the failures removed
+**/
+func (s *FaultFS) Crash() {
+	s.Heal()
+	s.crash()
+}
+
+/**
+* check
+* @param op, name string
+* @return error
+**/
+func (s *FaultFS) check(op, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fault == nil {
+		return nil
+	}
+
+	if err := s.fault(op, name); err != nil {
+		return pathError(op, name, err)
+	}
+
+	return nil
+}
+
+/**
+* OpenFile
+* @param name string, flag int, perm os.FileMode
+* @return File, error
+**/
+func (s *FaultFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if err := s.check(OpOpen, name); err != nil {
+		return nil, err
+	}
+
+	f, err := s.MemFS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &faultFile{File: f, fs: s}, nil
+}
+
+/**
+* MkdirAll
+* @param path string, perm os.FileMode
+* @return error
+**/
+func (s *FaultFS) MkdirAll(path string, perm os.FileMode) error {
+	if err := s.check(OpMkdir, path); err != nil {
+		return err
+	}
+
+	return s.MemFS.MkdirAll(path, perm)
+}
+
+/**
+* Rename
+* @param oldpath, newpath string
+* @return error
+**/
+func (s *FaultFS) Rename(oldpath, newpath string) error {
+	if err := s.check(OpRename, oldpath); err != nil {
+		return err
+	}
+
+	return s.MemFS.Rename(oldpath, newpath)
+}
+
+/**
+* Remove
+* @param name string
+* @return error
+**/
+func (s *FaultFS) Remove(name string) error {
+	if err := s.check(OpRemove, name); err != nil {
+		return err
+	}
+
+	return s.MemFS.Remove(name)
+}
+
+/**
+* RemoveAll
+* @param path string
+* @return error
+**/
+func (s *FaultFS) RemoveAll(path string) error {
+	if err := s.check(OpRemove, path); err != nil {
+		return err
+	}
+
+	return s.MemFS.RemoveAll(path)
+}
+
+type faultFile struct {
+	File
+	fs *FaultFS
+}
+
+/**
+* WriteAt: A failed write leaves the first half of b, like a torn write
+* @param b []byte, off int64
+* @return int, error
+**/
+func (s *faultFile) WriteAt(b []byte, off int64) (int, error) {
+	if err := s.fs.check(OpWrite, s.Name()); err != nil {
+		n, _ := s.File.WriteAt(b[:len(b)/2], off)
+		return n, err
+	}
+
+	return s.File.WriteAt(b, off)
+}
+
+/**
+* Sync
+* @return error
+**/
+func (s *faultFile) Sync() error {
+	if err := s.fs.check(OpSync, s.Name()); err != nil {
+		return err
+	}
+
+	return s.File.Sync()
+}
+
+/**
+* Truncate
+* @param size int64
+* @return error
+**/
+func (s *faultFile) Truncate(size int64) error {
+	if err := s.fs.check(OpTruncate, s.Name()); err != nil {
+		return err
+	}
+
+	return s.File.Truncate(size)
+}
@@ -0,0 +1,233 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cgalvisleon/josefina/pkg/msg"
+)
+
+/**
+* crashAndReopen: Simulates a power loss while the store is open and opens it again, the old store
+* can not write anything after the crash
+* @param t *testing.T, fsys *FaultFS, fs *FileStore
+* @return *FileStore
+**/
+func crashAndReopen(t *testing.T, fsys *FaultFS, fs *FileStore) *FileStore {
+	t.Helper()
+	fsys.Crash()
+	fsys.FailWhen(func(op, name string) error {
+		return ErrInjectedFault
+	})
+	fs.Close()
+	fsys.Heal()
+
+	result, err := OpenFS(fsys, "/db", "fault", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+/**
+* writeBatches: Writes batches of three keys until one fails, returns the number of acknowledged batches
+* @param fs *FileStore, n int
+* @return int
+**/
+func writeBatches(fs *FileStore, n int) int {
+	for i := 0; i < n; i++ {
+		batch := NewWriteBatch()
+		for j := 0; j < 3; j++ {
+			batch.Put(fmt.Sprintf("b%02d-%d", i, j), fmt.Sprintf("v%02d", i))
+		}
+		if err := fs.Write(batch); err != nil {
+			return i
+		}
+	}
+
+	return n
+}
+
+/**
+* expectBatches: Checks that the acknowledged batches survived and that every batch is all or nothing
+* @param t *testing.T, fs *FileStore, acked, n int
+**/
+func expectBatches(t *testing.T, fs *FileStore, acked, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		found := 0
+		for j := 0; j < 3; j++ {
+			var value string
+			exists, err := fs.Get(fmt.Sprintf("b%02d-%d", i, j), &value)
+			if err != nil {
+				t.Fatalf("batch %d: %v", i, err)
+			}
+			if exists {
+				found++
+			}
+		}
+
+		if i < acked && found != 3 {
+			t.Fatalf("acknowledged batch %d lost: %d of 3 keys", i, found)
+		}
+		if found != 0 && found != 3 {
+			t.Fatalf("batch %d partially applied: %d of 3 keys", i, found)
+		}
+	}
+}
+
+func TestFaultAcknowledgedWritesSurviveCrash(t *testing.T) {
+	fsys := NewFaultFS()
+	fs, err := OpenFS(fsys, "/db", "fault", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acked := writeBatches(fs, 20)
+	if acked != 20 {
+		t.Fatalf("writes failed without faults: %d", acked)
+	}
+
+	fs = crashAndReopen(t, fsys, fs)
+	defer fs.Close()
+	expectBatches(t, fs, acked, 20)
+}
+
+func TestFaultCrashConsistency(t *testing.T) {
+	for _, op := range []string{OpWrite, OpSync} {
+		for n := 0; n < 12; n++ {
+			t.Run(fmt.Sprintf("%s-%d", op, n), func(t *testing.T) {
+				fsys := NewFaultFS()
+				fs, err := OpenFS(fsys, "/db", "fault", false)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				fsys.FailAfter(op, n)
+				acked := writeBatches(fs, 10)
+
+				fs = crashAndReopen(t, fsys, fs)
+				defer fs.Close()
+				expectBatches(t, fs, acked, 10)
+
+				// El store reabierto acepta escrituras y las conserva
+				if err := fs.Put("after", "crash"); err != nil {
+					t.Fatal(err)
+				}
+				fs = crashAndReopen(t, fsys, fs)
+				if !fs.IsExist("after") {
+					t.Fatal("write after recovery lost")
+				}
+				expectBatches(t, fs, acked, 10)
+			})
+		}
+	}
+}
+
+func TestFaultSnapshotRename(t *testing.T) {
+	fsys := NewFaultFS()
+	fs, err := OpenFS(fsys, "/db", "fault", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acked := writeBatches(fs, 5)
+
+	// El snapshot del cierre no llega a reemplazar al anterior
+	fsys.FailAfter(OpRename, 0)
+	if err := fs.Close(); err == nil {
+		t.Fatal("close succeeded with the snapshot rename failing")
+	}
+	fsys.Crash()
+
+	fs, err = OpenFS(fsys, "/db", "fault", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	expectBatches(t, fs, acked, 5)
+}
+
+func TestFaultSyncFailureDiscardsBatch(t *testing.T) {
+	fsys := NewFaultFS()
+	fs, err := OpenFS(fsys, "/db", "fault", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// El fsync del lote falla una vez, el siguiente lote sincroniza el segmento
+	failed := false
+	fsys.FailWhen(func(op, name string) error {
+		if op == OpSync && !failed {
+			failed = true
+			return ErrInjectedFault
+		}
+		return nil
+	})
+	if err := fs.Put("lost", "value"); err == nil {
+		t.Fatal("put acknowledged with the fsync failing")
+	}
+	fsys.Heal()
+	if err := fs.Put("kept", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	fs = crashAndReopen(t, fsys, fs)
+	defer fs.Close()
+	if fs.IsExist("lost") {
+		t.Fatal("failed batch replayed after the crash")
+	}
+	if !fs.IsExist("kept") {
+		t.Fatal("acknowledged write lost")
+	}
+}
+
+func TestFaultTruncateFailureMarksStoreFailed(t *testing.T) {
+	fsys := NewFaultFS()
+	fs, err := OpenFS(fsys, "/db", "fault", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Put("kept", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ni el fsync ni el truncado del lote fallido funcionan
+	fsys.FailWhen(func(op, name string) error {
+		if op == OpSync || op == OpTruncate {
+			return ErrInjectedFault
+		}
+		return nil
+	})
+	if err := fs.Put("lost", "value"); err == nil {
+		t.Fatal("put acknowledged with the fsync failing")
+	}
+	fsys.Heal()
+
+	if err := fs.Put("after", "value"); err == nil || err.Error() != msg.MSG_STORE_FAILED {
+		t.Fatalf("failed store accepted a write: %v", err)
+	}
+
+	snapshots, err := fsys.ReadDir(fs.PathSnapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+	if after, _ := fsys.ReadDir(fs.PathSnapshot); len(after) != len(snapshots) {
+		t.Fatalf("failed store wrote a snapshot: %v", after)
+	}
+
+	fsys.Crash()
+	fs, err = OpenFS(fsys, "/db", "fault", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if fs.IsExist("lost") || !fs.IsExist("kept") {
+		t.Fatal("reopened store does not match the acknowledged writes")
+	}
+	if err := fs.Put("after", "value"); err != nil {
+		t.Fatal(err)
+	}
+}
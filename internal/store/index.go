@@ -0,0 +1,447 @@
+package store
+
+import (
+	"slices"
+	"sort"
+)
+
+const (
+	maxLeafItems   = 128
+	maxNodeEntries = 64
+)
+
+type indexItem struct {
+	key string
+	ref *RecordRef
+}
+
+/**
+* bnode: Node of the B+tree, the leaves are linked to iterate in order
+**/
+type bnode struct {
+	items    []indexItem // solo hojas
+	keys     []string    // separadores, keys[i] es el mínimo de children[i+1]
+	children []*bnode    // solo nodos internos
+	size     int         // cantidad de items en el subárbol
+	next     *bnode      // hoja siguiente
+	prev     *bnode      // hoja anterior
+}
+
+/**
+* isLeaf
+* @return bool
+**/
+func (s *bnode) isLeaf() bool {
+	return s.children == nil
+}
+
+/**
+* childIndex
+* @param key string
+* @return int
+**/
+func (s *bnode) childIndex(key string) int {
+	return sort.Search(len(s.keys), func(i int) bool {
+		return key < s.keys[i]
+	})
+}
+
+/**
+* itemIndex
+* @param key string
+* @return int, bool
+**/
+func (s *bnode) itemIndex(key string) (int, bool) {
+	i := sort.Search(len(s.items), func(i int) bool {
+		return s.items[i].key >= key
+	})
+	return i, i < len(s.items) && s.items[i].key == key
+}
+
+/**
+* insert
+* @param key string, ref *RecordRef
+* @return bool, *bnode, string
+**/
+func (s *bnode) insert(key string, ref *RecordRef) (bool, *bnode, string) {
+	if s.isLeaf() {
+		i, found := s.itemIndex(key)
+		if found {
+			s.items[i].ref = ref
+			return true, nil, ""
+		}
+
+		s.items = slices.Insert(s.items, i, indexItem{key: key, ref: ref})
+		s.size++
+		if len(s.items) <= maxLeafItems {
+			return false, nil, ""
+		}
+
+		mid := len(s.items) / 2
+		right := &bnode{
+			items: slices.Clone(s.items[mid:]),
+			prev:  s,
+			next:  s.next,
+		}
+		right.size = len(right.items)
+		s.items = s.items[:mid]
+		s.size = mid
+		if s.next != nil {
+			s.next.prev = right
+		}
+		s.next = right
+		return false, right, right.items[0].key
+	}
+
+	i := s.childIndex(key)
+	replaced, split, sep := s.children[i].insert(key, ref)
+	if !replaced {
+		s.size++
+	}
+	if split == nil {
+		return replaced, nil, ""
+	}
+
+	s.keys = slices.Insert(s.keys, i, sep)
+	s.children = slices.Insert(s.children, i+1, split)
+	if len(s.children) <= maxNodeEntries {
+		return replaced, nil, ""
+	}
+
+	mid := len(s.keys) / 2
+	up := s.keys[mid]
+	right := &bnode{
+		keys:     slices.Clone(s.keys[mid+1:]),
+		children: slices.Clone(s.children[mid+1:]),
+	}
+	for _, child := range right.children {
+		right.size += child.size
+	}
+	s.keys = s.keys[:mid]
+	s.children = s.children[:mid+1]
+	s.size -= right.size
+	return replaced, right, up
+}
+
+/**
+* remove: Removes the key, the empty nodes are released without merging
+* @param key string
+* @return bool
+**/
+func (s *bnode) remove(key string) bool {
+	if s.isLeaf() {
+		i, found := s.itemIndex(key)
+		if !found {
+			return false
+		}
+
+		s.items = slices.Delete(s.items, i, i+1)
+		s.size--
+		return true
+	}
+
+	i := s.childIndex(key)
+	child := s.children[i]
+	if !child.remove(key) {
+		return false
+	}
+
+	s.size--
+	if child.size > 0 {
+		return true
+	}
+
+	if child.isLeaf() {
+		if child.prev != nil {
+			child.prev.next = child.next
+		}
+		if child.next != nil {
+			child.next.prev = child.prev
+		}
+	}
+
+	s.children = slices.Delete(s.children, i, i+1)
+	if i > 0 {
+		s.keys = slices.Delete(s.keys, i-1, i)
+	} else if len(s.keys) > 0 {
+		s.keys = slices.Delete(s.keys, 0, 1)
+	}
+
+	return true
+}
+
+/**
+* index: Ordered in-memory index of the store
+**/
+type index struct {
+	root     *bnode
+	expiries *index // claves con vencimiento ordenadas por fecha
+}
+
+/**
+* newIndex
+* @return *index
+**/
+func newIndex() *index {
+	return &index{
+		root: &bnode{},
+	}
+}
+
+/**
+* Len
+* @return int
+**/
+func (s *index) Len() int {
+	return s.root.size
+}
+
+/**
+* Get
+* @param key string
+* @return *RecordRef, bool
+**/
+func (s *index) Get(key string) (*RecordRef, bool) {
+	n := s.root
+	for !n.isLeaf() {
+		n = n.children[n.childIndex(key)]
+	}
+
+	i, found := n.itemIndex(key)
+	if !found {
+		return nil, false
+	}
+
+	return n.items[i].ref, true
+}
+
+/**
+* Set
+* @param key string, ref *RecordRef
+* @return bool, true if the key already existed
+**/
+func (s *index) Set(key string, ref *RecordRef) bool {
+	if s.expiries != nil {
+		if old, ok := s.Get(key); ok && old.expires != 0 {
+			s.expiries.remove(expiryKey(old.expires, key))
+		}
+	}
+	if ref.expires != 0 {
+		if s.expiries == nil {
+			s.expiries = newIndex()
+		}
+		s.expiries.insert(expiryKey(ref.expires, key), ref)
+	}
+
+	return s.insert(key, ref)
+}
+
+/**
+* insert: Inserts the key in the tree without tracking its expiration
+* @param key string, ref *RecordRef
+* @return bool
+**/
+func (s *index) insert(key string, ref *RecordRef) bool {
+	replaced, split, sep := s.root.insert(key, ref)
+	if split != nil {
+		s.root = &bnode{
+			keys:     []string{sep},
+			children: []*bnode{s.root, split},
+			size:     s.root.size + split.size,
+		}
+	}
+
+	return replaced
+}
+
+/**
+* Delete
+* @param key string
+* @return bool
+**/
+func (s *index) Delete(key string) bool {
+	if s.expiries != nil {
+		if old, ok := s.Get(key); ok && old.expires != 0 {
+			s.expiries.remove(expiryKey(old.expires, key))
+		}
+	}
+
+	return s.remove(key)
+}
+
+/**
+* remove: Removes the key of the tree without tracking its expiration
+* @param key string
+* @return bool
+**/
+func (s *index) remove(key string) bool {
+	if !s.root.remove(key) {
+		return false
+	}
+
+	for !s.root.isLeaf() && len(s.root.children) == 1 {
+		s.root = s.root.children[0]
+	}
+	if !s.root.isLeaf() && len(s.root.children) == 0 {
+		s.root = &bnode{}
+	}
+
+	return true
+}
+
+/**
+* Expired: Walks the keys expired at now, the oldest first
+* @param now int64, fn func(key string, ref *RecordRef) bool
+**/
+func (s *index) Expired(now int64, fn func(key string, ref *RecordRef) bool) {
+	if s.expiries == nil {
+		return
+	}
+
+	s.expiries.Ascend(0, func(key string, ref *RecordRef) bool {
+		if !ref.expired(now) {
+			return false
+		}
+		return fn(key[expiryKeyLen:], ref)
+	})
+}
+
+/**
+* ExpiredCount: Returns the number of keys expired at now
+* @param now int64
+* @return int
+**/
+func (s *index) ExpiredCount(now int64) int {
+	if s.expiries == nil {
+		return 0
+	}
+
+	return s.expiries.Rank(expiryKey(now+1, ""))
+}
+
+/**
+* Rank: Returns the number of keys lower than key
+* @param key string
+* @return int
+**/
+func (s *index) Rank(key string) int {
+	result := 0
+	n := s.root
+	for !n.isLeaf() {
+		i := n.childIndex(key)
+		for _, child := range n.children[:i] {
+			result += child.size
+		}
+		n = n.children[i]
+	}
+
+	i, _ := n.itemIndex(key)
+	return result + i
+}
+
+/**
+* seekAt: Returns the leaf and position of the item with the given rank
+* @param rank int
+* @return *bnode, int
+**/
+func (s *index) seekAt(rank int) (*bnode, int) {
+	if rank < 0 || rank >= s.root.size {
+		return nil, 0
+	}
+
+	n := s.root
+	for !n.isLeaf() {
+		for _, child := range n.children {
+			if rank < child.size {
+				n = child
+				break
+			}
+			rank -= child.size
+		}
+	}
+
+	return n, rank
+}
+
+/**
+* Ascend: Walks the keys in ascending order starting at offset
+* @param offset int, fn func(key string, ref *RecordRef) bool
+**/
+func (s *index) Ascend(offset int, fn func(key string, ref *RecordRef) bool) {
+	n, i := s.seekAt(offset)
+	for n != nil {
+		for ; i < len(n.items); i++ {
+			if !fn(n.items[i].key, n.items[i].ref) {
+				return
+			}
+		}
+		n, i = n.next, 0
+	}
+}
+
+/**
+* Descend: Walks the keys in descending order skipping offset keys from the end
+* @param offset int, fn func(key string, ref *RecordRef) bool
+**/
+func (s *index) Descend(offset int, fn func(key string, ref *RecordRef) bool) {
+	n, i := s.seekAt(s.root.size - 1 - offset)
+	for n != nil {
+		for ; i >= 0; i-- {
+			if !fn(n.items[i].key, n.items[i].ref) {
+				return
+			}
+		}
+		n = n.prev
+		if n != nil {
+			i = len(n.items) - 1
+		}
+	}
+}
+
+/**
+* AscendRange: Walks the keys in [start, end) in ascending order, end "" is unbounded
+* @param start, end string, fn func(key string, ref *RecordRef) bool
+**/
+func (s *index) AscendRange(start, end string, fn func(key string, ref *RecordRef) bool) {
+	s.Ascend(s.Rank(start), func(key string, ref *RecordRef) bool {
+		if end != "" && key >= end {
+			return false
+		}
+		return fn(key, ref)
+	})
+}
+
+/**
+* DescendRange: Walks the keys in [start, end) in descending order, end "" is unbounded
+* @param start, end string, fn func(key string, ref *RecordRef) bool
+**/
+func (s *index) DescendRange(start, end string, fn func(key string, ref *RecordRef) bool) {
+	offset := 0
+	if end != "" {
+		offset = s.root.size - s.Rank(end)
+	}
+
+	s.Descend(offset, func(key string, ref *RecordRef) bool {
+		if key < start {
+			return false
+		}
+		return fn(key, ref)
+	})
+}
+
+/**
+* PrefixEnd: Returns the first key greater than every key with the prefix
+* @param prefix string
+* @return string
+**/
+func PrefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+
+	return ""
+}
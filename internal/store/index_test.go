@@ -0,0 +1,293 @@
+package store
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+/**
+* indexKey
+* @param i int
+* @return string
+**/
+func indexKey(i int) string {
+	return fmt.Sprintf("k%06d", i)
+}
+
+/**
+* checkNode: Validates the order, the separators and the sizes of the subtree, returns its leaves in order
+* @param t *testing.T, n *bnode, low, high string
+* @return []*bnode
+**/
+func checkNode(t *testing.T, n *bnode, low, high string) []*bnode {
+	t.Helper()
+	if n.isLeaf() {
+		if n.size != len(n.items) {
+			t.Fatalf("leaf size %d with %d items", n.size, len(n.items))
+		}
+		for i, item := range n.items {
+			if i > 0 && item.key <= n.items[i-1].key {
+				t.Fatalf("leaf out of order: %s after %s", item.key, n.items[i-1].key)
+			}
+			if item.key < low || (high != "" && item.key >= high) {
+				t.Fatalf("key %s out of [%s, %s)", item.key, low, high)
+			}
+		}
+		return []*bnode{n}
+	}
+
+	if len(n.keys) != len(n.children)-1 {
+		t.Fatalf("node with %d keys and %d children", len(n.keys), len(n.children))
+	}
+
+	result := []*bnode{}
+	size := 0
+	for i, child := range n.children {
+		from, to := low, high
+		if i > 0 {
+			from = n.keys[i-1]
+		}
+		if i < len(n.keys) {
+			to = n.keys[i]
+		}
+		result = append(result, checkNode(t, child, from, to)...)
+		size += child.size
+	}
+	if n.size != size {
+		t.Fatalf("node size %d with %d items in its children", n.size, size)
+	}
+
+	return result
+}
+
+/**
+* checkIndex: Validates the tree and the links of its leaves against the expected keys
+* @param t *testing.T, idx *index, expected []string
+**/
+func checkIndex(t *testing.T, idx *index, expected []string) {
+	t.Helper()
+	leaves := checkNode(t, idx.root, "", "")
+	for i, leaf := range leaves {
+		var prev, next *bnode
+		if i > 0 {
+			prev = leaves[i-1]
+		}
+		if i < len(leaves)-1 {
+			next = leaves[i+1]
+		}
+		if leaf.prev != prev || leaf.next != next {
+			t.Fatalf("leaf %d linked out of the tree", i)
+		}
+	}
+
+	if idx.Len() != len(expected) {
+		t.Fatalf("%d keys, expected %d", idx.Len(), len(expected))
+	}
+
+	keys := []string{}
+	idx.Ascend(0, func(key string, ref *RecordRef) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if !slices.Equal(keys, expected) {
+		t.Fatalf("ascend returned %d keys out of order", len(keys))
+	}
+
+	keys = keys[:0]
+	idx.Descend(0, func(key string, ref *RecordRef) bool {
+		keys = append(keys, key)
+		return true
+	})
+	slices.Reverse(keys)
+	if !slices.Equal(keys, expected) {
+		t.Fatalf("descend returned %d keys out of order", len(keys))
+	}
+}
+
+/**
+* height
+* @param idx *index
+* @return int
+**/
+func height(idx *index) int {
+	result := 1
+	for n := idx.root; !n.isLeaf(); n = n.children[0] {
+		result++
+	}
+
+	return result
+}
+
+func TestIndexInsertDelete(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	n := maxLeafItems * maxNodeEntries * 2
+	idx := newIndex()
+	for _, i := range rnd.Perm(n) {
+		if idx.Set(indexKey(i), &RecordRef{offset: int64(i)}) {
+			t.Fatalf("%s existed", indexKey(i))
+		}
+	}
+
+	// Las hojas y los nodos internos se dividieron
+	if h := height(idx); h < 3 {
+		t.Fatalf("height %d after %d keys", h, n)
+	}
+	expected := make([]string, n)
+	for i := range expected {
+		expected[i] = indexKey(i)
+	}
+	checkIndex(t, idx, expected)
+
+	// Reemplazar no cambia el tamaño
+	if !idx.Set(indexKey(7), &RecordRef{offset: 70}) {
+		t.Fatal("existing key inserted again")
+	}
+	if ref, ok := idx.Get(indexKey(7)); !ok || ref.offset != 70 {
+		t.Fatalf("key not replaced: %v", ref)
+	}
+	checkIndex(t, idx, expected)
+
+	removed := map[string]bool{}
+	for _, i := range rnd.Perm(n)[:n/2] {
+		if !idx.Delete(indexKey(i)) {
+			t.Fatalf("%s not deleted", indexKey(i))
+		}
+		removed[indexKey(i)] = true
+	}
+	if idx.Delete(indexKey(n)) {
+		t.Fatal("missing key deleted")
+	}
+	expected = slices.DeleteFunc(expected, func(key string) bool {
+		return removed[key]
+	})
+	checkIndex(t, idx, expected)
+
+	for _, key := range expected {
+		if _, ok := idx.Get(key); !ok {
+			t.Fatalf("%s lost", key)
+		}
+		idx.Delete(key)
+	}
+	checkIndex(t, idx, []string{})
+	if !idx.root.isLeaf() {
+		t.Fatal("empty tree with internal nodes")
+	}
+
+	idx.Set("a", &RecordRef{})
+	checkIndex(t, idx, []string{"a"})
+}
+
+func TestIndexAscendDescendOffset(t *testing.T) {
+	n := maxLeafItems * 5
+	idx := newIndex()
+	for i := 0; i < n; i++ {
+		idx.Set(indexKey(i), &RecordRef{})
+	}
+
+	for _, offset := range []int{0, 1, maxLeafItems - 1, maxLeafItems, n/2 + 3, n - 1, n, n + 10} {
+		asc := []string{}
+		idx.Ascend(offset, func(key string, ref *RecordRef) bool {
+			asc = append(asc, key)
+			return len(asc) < 3
+		})
+		desc := []string{}
+		idx.Descend(offset, func(key string, ref *RecordRef) bool {
+			desc = append(desc, key)
+			return len(desc) < 3
+		})
+
+		expectedAsc, expectedDesc := []string{}, []string{}
+		for i := offset; i < min(offset+3, n); i++ {
+			expectedAsc = append(expectedAsc, indexKey(i))
+			expectedDesc = append(expectedDesc, indexKey(n-1-i))
+		}
+		if !slices.Equal(asc, expectedAsc) {
+			t.Fatalf("ascend from %d: %v", offset, asc)
+		}
+		if !slices.Equal(desc, expectedDesc) {
+			t.Fatalf("descend from %d: %v", offset, desc)
+		}
+	}
+}
+
+func TestIndexRank(t *testing.T) {
+	idx := newIndex()
+	for i := 0; i < maxLeafItems*4; i += 2 {
+		idx.Set(indexKey(i), &RecordRef{})
+	}
+
+	for _, c := range []struct {
+		key  string
+		rank int
+	}{
+		{"", 0},
+		{indexKey(0), 0},
+		{indexKey(1), 1},
+		{indexKey(2), 1},
+		{indexKey(maxLeafItems + 1), maxLeafItems/2 + 1},
+		{indexKey(maxLeafItems*4 - 2), maxLeafItems*2 - 1},
+		{"z", maxLeafItems * 2},
+	} {
+		if rank := idx.Rank(c.key); rank != c.rank {
+			t.Fatalf("rank of %q %d, expected %d", c.key, rank, c.rank)
+		}
+	}
+}
+
+func TestIndexEmptiedLeaf(t *testing.T) {
+	n := maxLeafItems * 4
+	for _, at := range []string{"first", "middle", "last"} {
+		t.Run(at, func(t *testing.T) {
+			idx := newIndex()
+			for i := 0; i < n; i++ {
+				idx.Set(indexKey(i), &RecordRef{})
+			}
+
+			// Vaciar una hoja la quita del árbol y de la lista de hojas
+			leaves := checkNode(t, idx.root, "", "")
+			leaf := map[string]*bnode{"first": leaves[0], "middle": leaves[len(leaves)/2], "last": leaves[len(leaves)-1]}[at]
+			emptied := []string{}
+			for _, item := range leaf.items {
+				emptied = append(emptied, item.key)
+			}
+			for _, key := range emptied {
+				idx.Delete(key)
+			}
+
+			expected := []string{}
+			for i := 0; i < n; i++ {
+				if !slices.Contains(emptied, indexKey(i)) {
+					expected = append(expected, indexKey(i))
+				}
+			}
+			checkIndex(t, idx, expected)
+			if len(checkNode(t, idx.root, "", "")) != len(leaves)-1 {
+				t.Fatal("empty leaf kept in the tree")
+			}
+
+			// Los recorridos que cruzan la hoja vaciada siguen en la vecina
+			rank := idx.Rank(emptied[0])
+			if rank > 0 && rank < len(expected) {
+				keys := []string{}
+				idx.Ascend(rank-1, func(key string, ref *RecordRef) bool {
+					keys = append(keys, key)
+					return len(keys) < 2
+				})
+				if keys[0] != expected[rank-1] || keys[1] != expected[rank] {
+					t.Fatalf("ascend across the emptied leaf: %v", keys)
+				}
+			}
+
+			for _, key := range emptied {
+				idx.Set(key, &RecordRef{})
+			}
+			expected = expected[:0]
+			for i := 0; i < n; i++ {
+				expected = append(expected, indexKey(i))
+			}
+			checkIndex(t, idx, expected)
+		})
+	}
+}
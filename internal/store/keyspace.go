@@ -0,0 +1,347 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/cgalvisleon/et/et"
+	"github.com/cgalvisleon/josefina/pkg/msg"
+)
+
+/**
+* Keyspace: Named view of the keys of a store, several keyspaces share the log and can be written in the same batch
+**/
+type Keyspace struct {
+	store  *FileStore
+	name   string
+	prefix string
+}
+
+/**
+* Keyspace: Returns the keyspace with the given name
+* @param name string
+* @return *Keyspace
+**/
+func (s *FileStore) Keyspace(name string) *Keyspace {
+	return &Keyspace{
+		store:  s,
+		name:   name,
+		prefix: name + "\x00",
+	}
+}
+
+/**
+* Name
+* @return string
+**/
+func (s *Keyspace) Name() string {
+	return s.name
+}
+
+/**
+* Store
+* @return *FileStore
+**/
+func (s *Keyspace) Store() *FileStore {
+	return s.store
+}
+
+/**
+* Key: Returns the key of id in the store
+* @param id string
+* @return string
+**/
+func (s *Keyspace) Key(id string) string {
+	return s.prefix + id
+}
+
+/**
+* bounds: Returns the range of the store for [start, end) of the keyspace
+* @param start, end string
+* @return string, string
+**/
+func (s *Keyspace) bounds(start, end string) (string, string) {
+	if end == "" {
+		return s.prefix + start, PrefixEnd(s.prefix)
+	}
+
+	return s.prefix + start, s.prefix + end
+}
+
+/**
+* Put
+* @param id string, value any
+* @return error
+**/
+func (s *Keyspace) Put(id string, value any) error {
+	if id == "" {
+		return errors.New(msg.MSG_ID_IS_REQUIRED)
+	}
+
+	return s.store.Put(s.Key(id), value)
+}
+
+/**
+* Delete
+* @param id string
+* @return bool, error
+**/
+func (s *Keyspace) Delete(id string) (bool, error) {
+	return s.store.Delete(s.Key(id))
+}
+
+/**
+* Get
+* @param id string, dest any
+* @return bool, error
+**/
+func (s *Keyspace) Get(id string, dest any) (bool, error) {
+	return s.store.Get(s.Key(id), dest)
+}
+
+/**
+* PutWithTTL: Writes the value, it stops being visible after ttl
+* @param id string, value any, ttl time.Duration
+* @return error
+**/
+func (s *Keyspace) PutWithTTL(id string, value any, ttl time.Duration) error {
+	if id == "" {
+		return errors.New(msg.MSG_ID_IS_REQUIRED)
+	}
+
+	return s.store.PutWithTTL(s.Key(id), value, ttl)
+}
+
+/**
+* PutIf: Writes the value only if the version of the key is expected, 0 when the key must not exist
+* @param id string, value any, expected uint64
+* @return uint64, error
+**/
+func (s *Keyspace) PutIf(id string, value any, expected uint64) (uint64, error) {
+	if id == "" {
+		return 0, errors.New(msg.MSG_ID_IS_REQUIRED)
+	}
+
+	return s.store.PutIf(s.Key(id), value, expected)
+}
+
+/**
+* DeleteIf: Deletes the key only if its version is expected
+* @param id string, expected uint64
+* @return bool, error
+**/
+func (s *Keyspace) DeleteIf(id string, expected uint64) (bool, error) {
+	return s.store.DeleteIf(s.Key(id), expected)
+}
+
+/**
+* Version
+* @param id string
+* @return uint64, bool, error
+**/
+func (s *Keyspace) Version(id string) (uint64, bool, error) {
+	return s.store.Version(s.Key(id))
+}
+
+/**
+* GetVersion: Reads the value and its version in one step
+* @param id string, dest any
+* @return uint64, bool, error
+**/
+func (s *Keyspace) GetVersion(id string, dest any) (uint64, bool, error) {
+	return s.store.GetVersion(s.Key(id), dest)
+}
+
+/**
+* IsExist
+* @param id string
+* @return bool
+**/
+func (s *Keyspace) IsExist(id string) bool {
+	return s.store.IsExist(s.Key(id))
+}
+
+/**
+* Count
+* @return int
+**/
+func (s *Keyspace) Count() int {
+	start, end := s.bounds("", "")
+	return s.store.CountRange(start, end)
+}
+
+/**
+* CountRange: Counts the keys in [start, end), end "" is unbounded
+* @param start, end string
+* @return int
+**/
+func (s *Keyspace) CountRange(start, end string) int {
+	start, end = s.bounds(start, end)
+	return s.store.CountRange(start, end)
+}
+
+/**
+* Keys
+* @param asc bool, offset, limit int
+* @return []string
+**/
+func (s *Keyspace) Keys(asc bool, offset, limit int) []string {
+	return s.KeysRange("", "", asc, offset, limit)
+}
+
+/**
+* KeysRange: Returns the keys in [start, end), end "" is unbounded
+* @param start, end string, asc bool, offset, limit int
+* @return []string
+**/
+func (s *Keyspace) KeysRange(start, end string, asc bool, offset, limit int) []string {
+	start, end = s.bounds(start, end)
+	result := s.store.KeysRange(start, end, asc, offset, limit)
+	for i, key := range result {
+		result[i] = strings.TrimPrefix(key, s.prefix)
+	}
+
+	return result
+}
+
+/**
+* KeysPrefix: Returns the keys that start with prefix
+* @param prefix string, asc bool, offset, limit int
+* @return []string
+**/
+func (s *Keyspace) KeysPrefix(prefix string, asc bool, offset, limit int) []string {
+	start, end := s.bounds(prefix, "")
+	if prefix != "" {
+		end = PrefixEnd(start)
+	}
+
+	result := s.store.KeysRange(start, end, asc, offset, limit)
+	for i, key := range result {
+		result[i] = strings.TrimPrefix(key, s.prefix)
+	}
+
+	return result
+}
+
+/**
+* Iterate
+* @param fn func(id string, data []byte) (bool, error), asc bool, offset, limit, workers int
+* @return error
+**/
+func (s *Keyspace) Iterate(fn func(id string, data []byte) (bool, error), asc bool, offset, limit, workers int) error {
+	start, end := s.bounds("", "")
+	records := s.store.getRange(start, end, asc, offset, limit)
+	return s.store.iterate(records, jsonFn(func(id string, data []byte) (bool, error) {
+		return fn(strings.TrimPrefix(id, s.prefix), data)
+	}), workers)
+}
+
+/**
+* IterateObjects: Iterates the records decoded with their codec
+* @param fn func(id string, item et.Json) (bool, error), asc bool, offset, limit, workers int
+* @return error
+**/
+func (s *Keyspace) IterateObjects(fn func(id string, item et.Json) (bool, error), asc bool, offset, limit, workers int) error {
+	start, end := s.bounds("", "")
+	records := s.store.getRange(start, end, asc, offset, limit)
+	return s.store.iterate(records, objectFn(func(id string, item et.Json) (bool, error) {
+		return fn(strings.TrimPrefix(id, s.prefix), item)
+	}), workers)
+}
+
+/**
+* IterateRange: Iterates the records in [start, end), end "" is unbounded, fn returns false to stop
+* @param start, end string, asc bool, fn func(id string, data []byte) (bool, error)
+* @return error
+**/
+func (s *Keyspace) IterateRange(start, end string, asc bool, fn func(id string, data []byte) (bool, error)) error {
+	start, end = s.bounds(start, end)
+	return s.store.IterateRange(start, end, asc, func(id string, data []byte) (bool, error) {
+		return fn(strings.TrimPrefix(id, s.prefix), data)
+	})
+}
+
+/**
+* IteratePrefix: Iterates the records whose id starts with prefix, fn returns false to stop
+* @param prefix string, asc bool, fn func(id string, data []byte) (bool, error)
+* @return error
+**/
+func (s *Keyspace) IteratePrefix(prefix string, asc bool, fn func(id string, data []byte) (bool, error)) error {
+	start, end := s.bounds(prefix, "")
+	if prefix != "" {
+		end = PrefixEnd(start)
+	}
+
+	return s.store.IterateRange(start, end, asc, func(id string, data []byte) (bool, error) {
+		return fn(strings.TrimPrefix(id, s.prefix), data)
+	})
+}
+
+/**
+* BatchPut: Adds a put of the keyspace to the batch
+* @param batch *WriteBatch, id string, value any
+* @return error
+**/
+func (s *Keyspace) BatchPut(batch *WriteBatch, id string, value any) error {
+	if id == "" {
+		return errors.New(msg.MSG_ID_IS_REQUIRED)
+	}
+
+	return batch.Put(s.Key(id), value)
+}
+
+/**
+* BatchPutWithTTL: Adds a put of the keyspace that expires after ttl to the batch
+* @param batch *WriteBatch, id string, value any, ttl time.Duration
+* @return error
+**/
+func (s *Keyspace) BatchPutWithTTL(batch *WriteBatch, id string, value any, ttl time.Duration) error {
+	if id == "" {
+		return errors.New(msg.MSG_ID_IS_REQUIRED)
+	}
+
+	return batch.PutWithTTL(s.Key(id), value, ttl)
+}
+
+/**
+* BatchPutIfWithTTL: Adds a conditional put of the keyspace that expires after ttl to the batch
+* @param batch *WriteBatch, id string, value any, expected uint64, ttl time.Duration
+* @return error
+**/
+func (s *Keyspace) BatchPutIfWithTTL(batch *WriteBatch, id string, value any, expected uint64, ttl time.Duration) error {
+	if id == "" {
+		return errors.New(msg.MSG_ID_IS_REQUIRED)
+	}
+
+	return batch.PutIfWithTTL(s.Key(id), value, expected, ttl)
+}
+
+/**
+* BatchPutIf: Adds a conditional put of the keyspace to the batch
+* @param batch *WriteBatch, id string, value any, expected uint64
+* @return error
+**/
+func (s *Keyspace) BatchPutIf(batch *WriteBatch, id string, value any, expected uint64) error {
+	if id == "" {
+		return errors.New(msg.MSG_ID_IS_REQUIRED)
+	}
+
+	return batch.PutIf(s.Key(id), value, expected)
+}
+
+/**
+* BatchDelete: Adds a delete of the keyspace to the batch
+* @param batch *WriteBatch, id string
+**/
+func (s *Keyspace) BatchDelete(batch *WriteBatch, id string) {
+	batch.Delete(s.Key(id))
+}
+
+/**
+* BatchDeleteIf: Adds a conditional delete of the keyspace to the batch
+* @param batch *WriteBatch, id string, expected uint64
+**/
+func (s *Keyspace) BatchDeleteIf(batch *WriteBatch, id string, expected uint64) {
+	batch.DeleteIf(s.Key(id), expected)
+}
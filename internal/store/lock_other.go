@@ -0,0 +1,14 @@
+//go:build !unix
+
+package store
+
+import "os"
+
+/**
+* lockFile: Without advisory locks the directory is not protected between processes
+* @param f *os.File, exclusive bool
+* @return bool, error
+**/
+func lockFile(f *os.File, exclusive bool) (bool, error) {
+	return true, nil
+}
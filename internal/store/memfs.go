@@ -0,0 +1,542 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cgalvisleon/josefina/pkg/msg"
+)
+
+type memNode struct {
+	data   []byte
+	synced []byte // contenido durable, es lo que sobrevive a un crash
+}
+
+/**
+* MemFS: Filesystem in memory, the creations, renames and removes are durable at once
+**/
+type MemFS struct {
+	mu    sync.RWMutex
+	files map[string]*memNode
+	dirs  map[string]bool
+	locks map[string]*memLock
+	gen   int // los handles de una generación anterior fueron invalidados por un crash
+}
+
+type memLock struct {
+	shared    int
+	exclusive bool
+}
+
+/**
+* NewMemFS
+* @return *MemFS
+**/
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files: make(map[string]*memNode),
+		locks: make(map[string]*memLock),
+		dirs:  map[string]bool{".": true, string(filepath.Separator): true},
+	}
+}
+
+/**
+* pathError
+* @param op, name string, err error
+* @return error
+**/
+func pathError(op, name string, err error) error {
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+/**
+* isChild: Returns true when name is inside dir
+* @param dir, name string
+* @return bool
+**/
+func isChild(dir, name string) bool {
+	if dir == "." {
+		return !filepath.IsAbs(name)
+	}
+
+	return strings.HasPrefix(name, dir+string(filepath.Separator))
+}
+
+/**
+* OpenFile
+* @param name string, flag int, perm os.FileMode
+* @return File, error
+**/
+func (s *MemFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	name = filepath.Clean(name)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dirs[name] {
+		return nil, pathError("open", name, errors.New(msg.MSG_IS_DIRECTORY))
+	}
+
+	node, ok := s.files[name]
+	switch {
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, pathError("open", name, os.ErrExist)
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, pathError("open", name, os.ErrNotExist)
+	case !ok:
+		if !s.dirs[filepath.Dir(name)] {
+			return nil, pathError("open", name, os.ErrNotExist)
+		}
+		node = &memNode{}
+		s.files[name] = node
+	}
+
+	if flag&os.O_TRUNC != 0 {
+		node.data = nil
+	}
+
+	return &memFile{
+		fs:       s,
+		node:     node,
+		name:     name,
+		gen:      s.gen,
+		readOnly: flag&(os.O_WRONLY|os.O_RDWR) == 0,
+	}, nil
+}
+
+/**
+* ReadDir: Returns the names of the directory ordered by name
+* @param name string
+* @return []string, error
+**/
+func (s *MemFS) ReadDir(name string) ([]string, error) {
+	name = filepath.Clean(name)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.dirs[name] {
+		return nil, pathError("readdir", name, os.ErrNotExist)
+	}
+
+	result := []string{}
+	for path := range s.files {
+		if filepath.Dir(path) == name {
+			result = append(result, filepath.Base(path))
+		}
+	}
+	for path := range s.dirs {
+		if path != name && filepath.Dir(path) == name {
+			result = append(result, filepath.Base(path))
+		}
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+/**
+* MkdirAll
+* @param path string, perm os.FileMode
+* @return error
+**/
+func (s *MemFS) MkdirAll(path string, perm os.FileMode) error {
+	path = filepath.Clean(path)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for dir := path; !s.dirs[dir]; dir = filepath.Dir(dir) {
+		if _, ok := s.files[dir]; ok {
+			return pathError("mkdir", dir, errors.New(msg.MSG_NOT_DIRECTORY))
+		}
+		s.dirs[dir] = true
+	}
+
+	return nil
+}
+
+/**
+* hasChildren: The caller holds mu
+* @param dir string
+* @return bool
+**/
+func (s *MemFS) hasChildren(dir string) bool {
+	for path := range s.files {
+		if isChild(dir, path) {
+			return true
+		}
+	}
+	for path := range s.dirs {
+		if path != dir && isChild(dir, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/**
+* Rename: Moves a file or a directory, the target directory must be empty
+* @param oldpath, newpath string
+* @return error
+**/
+func (s *MemFS) Rename(oldpath, newpath string) error {
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dirs[filepath.Dir(newpath)] {
+		return pathError("rename", newpath, os.ErrNotExist)
+	}
+
+	if node, ok := s.files[oldpath]; ok {
+		if s.dirs[newpath] {
+			return pathError("rename", newpath, errors.New(msg.MSG_IS_DIRECTORY))
+		}
+		delete(s.files, oldpath)
+		s.files[newpath] = node
+		return nil
+	}
+
+	if !s.dirs[oldpath] {
+		return pathError("rename", oldpath, os.ErrNotExist)
+	}
+	if _, ok := s.files[newpath]; ok {
+		return pathError("rename", newpath, errors.New(msg.MSG_NOT_DIRECTORY))
+	}
+	if s.dirs[newpath] && s.hasChildren(newpath) {
+		return pathError("rename", newpath, errors.New(msg.MSG_DIRECTORY_NOT_EMPTY))
+	}
+
+	move := func(path string) string {
+		return newpath + strings.TrimPrefix(path, oldpath)
+	}
+	for path, node := range s.files {
+		if isChild(oldpath, path) {
+			delete(s.files, path)
+			s.files[move(path)] = node
+		}
+	}
+	for path := range s.dirs {
+		if path == oldpath || isChild(oldpath, path) {
+			delete(s.dirs, path)
+			s.dirs[move(path)] = true
+		}
+	}
+
+	return nil
+}
+
+/**
+* Remove: Removes a file or an empty directory
+* @param name string
+* @return error
+**/
+func (s *MemFS) Remove(name string) error {
+	name = filepath.Clean(name)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.files[name]; ok {
+		delete(s.files, name)
+		return nil
+	}
+
+	if !s.dirs[name] {
+		return pathError("remove", name, os.ErrNotExist)
+	}
+	if s.hasChildren(name) {
+		return pathError("remove", name, errors.New(msg.MSG_DIRECTORY_NOT_EMPTY))
+	}
+	delete(s.dirs, name)
+
+	return nil
+}
+
+/**
+* RemoveAll
+* @param path string
+* @return error
+**/
+func (s *MemFS) RemoveAll(path string) error {
+	path = filepath.Clean(path)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.files, path)
+	for name := range s.files {
+		if isChild(path, name) {
+			delete(s.files, name)
+		}
+	}
+	for name := range s.dirs {
+		if name == path || isChild(path, name) {
+			delete(s.dirs, name)
+		}
+	}
+
+	// La raíz siempre existe
+	s.dirs["."] = true
+	s.dirs[string(filepath.Separator)] = true
+
+	return nil
+}
+
+/**
+* Exists
+* @param name string
+* @return bool
+**/
+func (s *MemFS) Exists(name string) bool {
+	name = filepath.Clean(name)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.files[name]
+	return ok || s.dirs[name]
+}
+
+/**
+* Lock: Takes the lock of the file, exclusive for the writers and shared for the readers, it fails when it is held
+* @param name string, exclusive bool
+* @return io.Closer, error
+**/
+func (s *MemFS) Lock(name string, exclusive bool) (io.Closer, error) {
+	// Los lectores no crean el archivo
+	if exclusive {
+		f, err := s.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+	}
+
+	name = filepath.Clean(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lock, ok := s.locks[name]
+	if !ok {
+		lock = &memLock{}
+		s.locks[name] = lock
+	}
+
+	if lock.exclusive || (exclusive && lock.shared > 0) {
+		return nil, fmt.Errorf(msg.MSG_STORE_LOCKED, name)
+	}
+
+	if exclusive {
+		lock.exclusive = true
+	} else {
+		lock.shared++
+	}
+
+	return &memUnlock{fs: s, lock: lock, exclusive: exclusive, gen: s.gen}, nil
+}
+
+/**
+* crash: Discards the unsynced writes, invalidates the open handles and releases the locks
+**/
+func (s *MemFS) crash() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, node := range s.files {
+		node.data = append([]byte{}, node.synced...)
+	}
+	s.locks = make(map[string]*memLock)
+	s.gen++
+}
+
+type memUnlock struct {
+	fs        *MemFS
+	lock      *memLock
+	exclusive bool
+	gen       int
+	released  bool
+}
+
+/**
+* Close: Releases the lock
+* @return error
+**/
+func (s *memUnlock) Close() error {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+
+	if s.released || s.gen != s.fs.gen {
+		return nil // el crash ya liberó el lock
+	}
+
+	s.released = true
+	if s.exclusive {
+		s.lock.exclusive = false
+	} else {
+		s.lock.shared--
+	}
+
+	return nil
+}
+
+type memFile struct {
+	fs       *MemFS
+	node     *memNode
+	name     string
+	gen      int
+	readOnly bool
+	closed   bool
+}
+
+/**
+* check: The caller holds the lock of the filesystem
+* @param op string
+* @return error
+**/
+func (s *memFile) check(op string) error {
+	if s.closed {
+		return pathError(op, s.name, os.ErrClosed)
+	}
+	if s.gen != s.fs.gen {
+		return pathError(op, s.name, errors.New(msg.MSG_FILE_CRASHED))
+	}
+
+	return nil
+}
+
+/**
+* Name
+* @return string
+**/
+func (s *memFile) Name() string {
+	return s.name
+}
+
+/**
+* ReadAt
+* @param b []byte, off int64
+* @return int, error
+**/
+func (s *memFile) ReadAt(b []byte, off int64) (int, error) {
+	s.fs.mu.RLock()
+	defer s.fs.mu.RUnlock()
+
+	if err := s.check("read"); err != nil {
+		return 0, err
+	}
+	if off >= int64(len(s.node.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(b, s.node.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+/**
+* WriteAt
+* @param b []byte, off int64
+* @return int, error
+**/
+func (s *memFile) WriteAt(b []byte, off int64) (int, error) {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+
+	if err := s.check("write"); err != nil {
+		return 0, err
+	}
+	if s.readOnly {
+		return 0, pathError("write", s.name, os.ErrPermission)
+	}
+
+	end := off + int64(len(b))
+	if end > int64(len(s.node.data)) {
+		data := make([]byte, end)
+		copy(data, s.node.data)
+		s.node.data = data
+	}
+
+	return copy(s.node.data[off:], b), nil
+}
+
+/**
+* Size
+* @return int64, error
+**/
+func (s *memFile) Size() (int64, error) {
+	s.fs.mu.RLock()
+	defer s.fs.mu.RUnlock()
+
+	if err := s.check("stat"); err != nil {
+		return 0, err
+	}
+
+	return int64(len(s.node.data)), nil
+}
+
+/**
+* Sync: Makes the content durable
+* @return error
+**/
+func (s *memFile) Sync() error {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+
+	if err := s.check("sync"); err != nil {
+		return err
+	}
+
+	s.node.synced = append(s.node.synced[:0], s.node.data...)
+	return nil
+}
+
+/**
+* Truncate
+* @param size int64
+* @return error
+**/
+func (s *memFile) Truncate(size int64) error {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+
+	if err := s.check("truncate"); err != nil {
+		return err
+	}
+	if s.readOnly {
+		return pathError("truncate", s.name, os.ErrPermission)
+	}
+
+	data := make([]byte, size)
+	copy(data, s.node.data)
+	s.node.data = data
+	return nil
+}
+
+/**
+* Close
+* @return error
+**/
+func (s *memFile) Close() error {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+
+	if s.closed {
+		return pathError("close", s.name, os.ErrClosed)
+	}
+
+	s.closed = true
+	return nil
+}
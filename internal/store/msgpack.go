@@ -0,0 +1,621 @@
+package store
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cgalvisleon/josefina/pkg/msg"
+)
+
+const (
+	mpNil      byte = 0xc0
+	mpFalse    byte = 0xc2
+	mpTrue     byte = 0xc3
+	mpBin8     byte = 0xc4
+	mpBin16    byte = 0xc5
+	mpBin32    byte = 0xc6
+	mpExt8     byte = 0xc7
+	mpFloat32  byte = 0xca
+	mpFloat64  byte = 0xcb
+	mpUint8    byte = 0xcc
+	mpUint16   byte = 0xcd
+	mpUint32   byte = 0xce
+	mpUint64   byte = 0xcf
+	mpInt8     byte = 0xd0
+	mpInt16    byte = 0xd1
+	mpInt32    byte = 0xd2
+	mpInt64    byte = 0xd3
+	mpStr8     byte = 0xd9
+	mpStr16    byte = 0xda
+	mpStr32    byte = 0xdb
+	mpArray16  byte = 0xdc
+	mpArray32  byte = 0xdd
+	mpMap16    byte = 0xde
+	mpMap32    byte = 0xdf
+	mpTimeExt  byte = 0xff // tipo de extensión del timestamp
+	mpTimeSize      = 12   // nanosegundos y segundos
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type msgpackCodec struct{}
+
+/**
+* Name
+* @return string
+**/
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+/**
+* Code
+* @return byte
+**/
+func (msgpackCodec) Code() byte {
+	return CodecMsgpack
+}
+
+/**
+* Marshal: Encodes the value in the MessagePack format, the integers keep their type
+* @param value any
+* @return []byte, error
+**/
+func (msgpackCodec) Marshal(value any) ([]byte, error) {
+	e := &mpEncoder{buf: make([]byte, 0, 256)}
+	if err := e.encode(reflect.ValueOf(value)); err != nil {
+		return nil, err
+	}
+
+	return e.buf, nil
+}
+
+/**
+* Unmarshal: Decodes to maps, slices, int64, uint64, float64, string, []byte, bool and time.Time and sets dest
+* @param data []byte, dest any
+* @return error
+**/
+func (msgpackCodec) Unmarshal(data []byte, dest any) error {
+	d := &mpDecoder{buf: data}
+	value, err := d.decode()
+	if err != nil {
+		return err
+	}
+
+	if d.pos != len(d.buf) {
+		return errors.New(msg.MSG_CORRUPTED_RECORD)
+	}
+
+	return assign(dest, value)
+}
+
+type mpEncoder struct {
+	buf []byte
+}
+
+/**
+* byte
+* @param b byte
+**/
+func (s *mpEncoder) byte(b byte) {
+	s.buf = append(s.buf, b)
+}
+
+/**
+* uint: Writes the tag followed by v in n bytes
+* @param tag byte, v uint64, n int
+**/
+func (s *mpEncoder) uint(tag byte, v uint64, n int) {
+	s.buf = append(s.buf, tag)
+	for i := n - 1; i >= 0; i-- {
+		s.buf = append(s.buf, byte(v>>(8*i)))
+	}
+}
+
+/**
+* length: Writes the header of a string, binary, array or map
+* @param n int, fix, max byte, tag8, tag16, tag32 byte
+**/
+func (s *mpEncoder) length(n int, fix, max byte, tag8, tag16, tag32 byte) {
+	switch {
+	case fix != 0 && n <= int(max):
+		s.byte(fix | byte(n))
+	case tag8 != 0 && n <= math.MaxUint8:
+		s.uint(tag8, uint64(n), 1)
+	case n <= math.MaxUint16:
+		s.uint(tag16, uint64(n), 2)
+	default:
+		s.uint(tag32, uint64(n), 4)
+	}
+}
+
+/**
+* int
+* @param v int64
+**/
+func (s *mpEncoder) int(v int64) {
+	switch {
+	case v >= 0 && v <= 0x7f:
+		s.byte(byte(v))
+	case v < 0 && v >= -32:
+		s.byte(byte(v))
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		s.uint(mpInt8, uint64(v), 1)
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		s.uint(mpInt16, uint64(v), 2)
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		s.uint(mpInt32, uint64(v), 4)
+	default:
+		s.uint(mpInt64, uint64(v), 8)
+	}
+}
+
+/**
+* string
+* @param v string
+**/
+func (s *mpEncoder) string(v string) {
+	s.length(len(v), 0xa0, 31, mpStr8, mpStr16, mpStr32)
+	s.buf = append(s.buf, v...)
+}
+
+/**
+* encode
+* @param v reflect.Value
+* @return error
+**/
+func (s *mpEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		s.byte(mpNil)
+		return nil
+	}
+
+	// Los valores de una interfaz, como los de un map[string]any, se codifican con su tipo
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		return s.encode(v.Elem())
+	}
+
+	if v.Type() == timeType {
+		t := v.Interface().(time.Time)
+		s.buf = append(s.buf, mpExt8, mpTimeSize, mpTimeExt)
+		s.buf = append(s.buf, make([]byte, mpTimeSize)...)
+		putUint32(s.buf[len(s.buf)-mpTimeSize:], uint32(t.Nanosecond()))
+		putUint64(s.buf[len(s.buf)-8:], uint64(t.Unix()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			s.byte(mpNil)
+			return nil
+		}
+	}
+
+	// Los tipos con su propio JSON se codifican con su forma genérica
+	if m, ok := v.Interface().(json.Marshaler); ok && v.Kind() != reflect.Map && v.Kind() != reflect.Slice {
+		return s.encodeJSON(m)
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return s.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			s.byte(mpTrue)
+		} else {
+			s.byte(mpFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		s.int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() <= math.MaxInt64 {
+			s.int(int64(v.Uint()))
+		} else {
+			s.uint(mpUint64, v.Uint(), 8)
+		}
+	case reflect.Float32, reflect.Float64:
+		s.uint(mpFloat64, math.Float64bits(v.Float()), 8)
+	case reflect.String:
+		s.string(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			s.byte(mpNil)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			s.length(len(b), 0, 0, mpBin8, mpBin16, mpBin32)
+			s.buf = append(s.buf, b...)
+			return nil
+		}
+		s.length(v.Len(), 0x90, 15, 0, mpArray16, mpArray32)
+		for i := 0; i < v.Len(); i++ {
+			if err := s.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			s.byte(mpNil)
+			return nil
+		}
+		return s.encodeMap(v)
+	case reflect.Struct:
+		return s.encodeStruct(v)
+	default:
+		return fmt.Errorf(msg.MSG_UNSUPPORTED_TYPE, v.Type().String())
+	}
+
+	return nil
+}
+
+/**
+* encodeJSON: Encodes the generic form of the JSON of m
+* @param m json.Marshaler
+* @return error
+**/
+func (s *mpEncoder) encodeJSON(m json.Marshaler) error {
+	bt, err := m.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	var value any
+	if err := json.Unmarshal(bt, &value); err != nil {
+		return err
+	}
+
+	return s.encode(reflect.ValueOf(value))
+}
+
+/**
+* mapKey: The keys are written as strings like in JSON
+* @param k reflect.Value
+* @return string, error
+**/
+func mapKey(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+
+	if m, ok := k.Interface().(encoding.TextMarshaler); ok {
+		bt, err := m.MarshalText()
+		return string(bt), err
+	}
+
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return fmt.Sprint(k.Interface()), nil
+	}
+
+	return "", fmt.Errorf(msg.MSG_UNSUPPORTED_TYPE, k.Type().String())
+}
+
+/**
+* encodeMap: The keys are sorted, the same map gives the same bytes
+* @param v reflect.Value
+* @return error
+**/
+func (s *mpEncoder) encodeMap(v reflect.Value) error {
+	keys := make([]string, 0, v.Len())
+	values := make(map[string]reflect.Value, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := mapKey(iter.Key())
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		values[key] = iter.Value()
+	}
+	sort.Strings(keys)
+
+	s.length(len(keys), 0x80, 15, 0, mpMap16, mpMap32)
+	for _, key := range keys {
+		s.string(key)
+		if err := s.encode(values[key]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type mpField struct {
+	name  string
+	value reflect.Value
+}
+
+/**
+* structFields: Returns the exported fields with the names and rules of their json tags
+* @param v reflect.Value, result []mpField
+* @return []mpField
+**/
+func structFields(v reflect.Value, result []mpField) []mpField {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		value := v.Field(i)
+		if field.Anonymous && name == "" {
+			if value.Kind() == reflect.Pointer {
+				if value.IsNil() {
+					continue
+				}
+				value = value.Elem()
+			}
+			if value.Kind() == reflect.Struct {
+				result = structFields(value, result)
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+		if strings.Contains(opts, "omitempty") && value.IsZero() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		result = append(result, mpField{name: name, value: value})
+	}
+
+	return result
+}
+
+/**
+* encodeStruct
+* @param v reflect.Value
+* @return error
+**/
+func (s *mpEncoder) encodeStruct(v reflect.Value) error {
+	fields := structFields(v, nil)
+	s.length(len(fields), 0x80, 15, 0, mpMap16, mpMap32)
+	for _, field := range fields {
+		s.string(field.name)
+		if err := s.encode(field.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type mpDecoder struct {
+	buf []byte
+	pos int
+}
+
+/**
+* next: Returns the next n bytes
+* @param n int
+* @return []byte, error
+**/
+func (s *mpDecoder) next(n int) ([]byte, error) {
+	if n < 0 || s.pos+n > len(s.buf) {
+		return nil, errors.New(msg.MSG_CORRUPTED_RECORD)
+	}
+
+	result := s.buf[s.pos : s.pos+n]
+	s.pos += n
+	return result, nil
+}
+
+/**
+* uint: Reads an unsigned integer of n bytes
+* @param n int
+* @return uint64, error
+**/
+func (s *mpDecoder) uint(n int) (uint64, error) {
+	b, err := s.next(n)
+	if err != nil {
+		return 0, err
+	}
+
+	result := uint64(0)
+	for _, c := range b {
+		result = result<<8 | uint64(c)
+	}
+
+	return result, nil
+}
+
+/**
+* decode
+* @return any, error
+**/
+func (s *mpDecoder) decode() (any, error) {
+	b, err := s.next(1)
+	if err != nil {
+		return nil, err
+	}
+
+	tag := b[0]
+	switch {
+	case tag <= 0x7f:
+		return int64(tag), nil
+	case tag >= 0xe0:
+		return int64(int8(tag)), nil
+	case tag&0xf0 == 0x80:
+		return s.decodeMap(int(tag & 0x0f))
+	case tag&0xf0 == 0x90:
+		return s.decodeArray(int(tag & 0x0f))
+	case tag&0xe0 == 0xa0:
+		return s.decodeString(int(tag & 0x1f))
+	}
+
+	switch tag {
+	case mpNil:
+		return nil, nil
+	case mpFalse:
+		return false, nil
+	case mpTrue:
+		return true, nil
+	case mpBin8, mpBin16, mpBin32:
+		n, err := s.uint(1 << (tag - mpBin8))
+		if err != nil {
+			return nil, err
+		}
+		data, err := s.next(int(n))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, data...), nil
+	case mpExt8:
+		return s.decodeTime()
+	case mpFloat32:
+		v, err := s.uint(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case mpFloat64:
+		v, err := s.uint(8)
+		return math.Float64frombits(v), err
+	case mpUint8, mpUint16, mpUint32, mpUint64:
+		v, err := s.uint(1 << (tag - mpUint8))
+		if err != nil {
+			return nil, err
+		}
+		if v > math.MaxInt64 {
+			return v, nil
+		}
+		return int64(v), nil
+	case mpInt8:
+		v, err := s.uint(1)
+		return int64(int8(v)), err
+	case mpInt16:
+		v, err := s.uint(2)
+		return int64(int16(v)), err
+	case mpInt32:
+		v, err := s.uint(4)
+		return int64(int32(v)), err
+	case mpInt64:
+		v, err := s.uint(8)
+		return int64(v), err
+	case mpStr8, mpStr16, mpStr32:
+		n, err := s.uint(1 << (tag - mpStr8))
+		if err != nil {
+			return nil, err
+		}
+		return s.decodeString(int(n))
+	case mpArray16, mpArray32:
+		n, err := s.uint(2 << (tag - mpArray16))
+		if err != nil {
+			return nil, err
+		}
+		return s.decodeArray(int(n))
+	case mpMap16, mpMap32:
+		n, err := s.uint(2 << (tag - mpMap16))
+		if err != nil {
+			return nil, err
+		}
+		return s.decodeMap(int(n))
+	}
+
+	return nil, errors.New(msg.MSG_CORRUPTED_RECORD)
+}
+
+/**
+* decodeString
+* @param n int
+* @return any, error
+**/
+func (s *mpDecoder) decodeString(n int) (any, error) {
+	b, err := s.next(n)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
+
+/**
+* decodeArray
+* @param n int
+* @return any, error
+**/
+func (s *mpDecoder) decodeArray(n int) (any, error) {
+	if n > len(s.buf)-s.pos {
+		return nil, errors.New(msg.MSG_CORRUPTED_RECORD)
+	}
+
+	result := make([]any, n)
+	for i := range result {
+		value, err := s.decode()
+		if err != nil {
+			return nil, err
+		}
+		result[i] = value
+	}
+
+	return result, nil
+}
+
+/**
+* decodeMap
+* @param n int
+* @return any, error
+**/
+func (s *mpDecoder) decodeMap(n int) (any, error) {
+	if n > len(s.buf)-s.pos {
+		return nil, errors.New(msg.MSG_CORRUPTED_RECORD)
+	}
+
+	result := make(map[string]any, n)
+	for i := 0; i < n; i++ {
+		key, err := s.decode()
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, errors.New(msg.MSG_CORRUPTED_RECORD)
+		}
+
+		value, err := s.decode()
+		if err != nil {
+			return nil, err
+		}
+		result[name] = value
+	}
+
+	return result, nil
+}
+
+/**
+* decodeTime
+* @return any, error
+**/
+func (s *mpDecoder) decodeTime() (any, error) {
+	b, err := s.next(2)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] != mpTimeSize || b[1] != mpTimeExt {
+		return nil, errors.New(msg.MSG_CORRUPTED_RECORD)
+	}
+
+	data, err := s.next(mpTimeSize)
+	if err != nil {
+		return nil, err
+	}
+
+	nsec := getUint32(data[0:4])
+	sec := int64(getUint64(data[4:12]))
+	return time.Unix(sec, int64(nsec)), nil
+}
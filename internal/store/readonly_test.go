@@ -0,0 +1,97 @@
+package store
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+/**
+* tree: Returns the files under the directory with their content
+* @param t *testing.T, dir string
+* @return map[string]string
+**/
+func tree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	result := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		result[path] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+func TestReadOnlyWritesNothing(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := Open(dir, "reader", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Put("a", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sin el archivo del lock ningún escritor tiene el store abierto
+	if err := os.Remove(filepath.Join(dir, "reader", lockName)); err != nil {
+		t.Fatal(err)
+	}
+	before := tree(t, dir)
+
+	reader, err := ReadOnly(dir, "reader", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value string
+	if exists, err := reader.Get("a", &value); err != nil || !exists || value != "value" {
+		t.Fatalf("read %q %v %v", value, exists, err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if after := tree(t, dir); !reflect.DeepEqual(before, after) {
+		t.Fatalf("read only open changed the files:\n%v\n%v", before, after)
+	}
+}
+
+func TestReadOnlyEmptySegment(t *testing.T) {
+	dir := t.TempDir()
+	segments := filepath.Join(dir, "empty", "segments")
+	if err := os.MkdirAll(segments, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Un directorio sin segmentos no se inicializa
+	if _, err := ReadOnly(dir, "empty", false); err == nil {
+		t.Fatal("store without segments opened read only")
+	}
+	if names, _ := os.ReadDir(segments); len(names) != 0 {
+		t.Fatalf("read only open created %v", names)
+	}
+
+	// Un segmento vacío no recibe su cabecera
+	name := filepath.Join(segments, "segment-000001.dat")
+	if err := os.WriteFile(name, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadOnly(dir, "empty", false); err == nil {
+		t.Fatal("empty segment opened read only")
+	}
+	if info, err := os.Stat(name); err != nil || info.Size() != 0 {
+		t.Fatalf("read only open wrote the segment: %v %v", info, err)
+	}
+}
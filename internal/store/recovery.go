@@ -0,0 +1,264 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/cgalvisleon/et/et"
+	"github.com/cgalvisleon/et/logs"
+	"github.com/cgalvisleon/josefina/pkg/msg"
+)
+
+const resyncWindow = 64 * 1024
+
+type CorruptRange struct {
+	Segment string `json:"segment"`
+	Start   int64  `json:"start"`
+	End     int64  `json:"end"`
+	Reason  string `json:"reason"`
+}
+
+/**
+* ToJson
+* @return et.Json
+**/
+func (s *CorruptRange) ToJson() et.Json {
+	return et.Json{
+		"segment": s.Segment,
+		"start":   s.Start,
+		"end":     s.End,
+		"reason":  s.Reason,
+	}
+}
+
+/**
+* ToString
+* @return string
+ */
+func (s *CorruptRange) ToString() string {
+	return s.ToJson().ToString()
+}
+
+type SegmentReport struct {
+	Segment string          `json:"segment"`
+	Size    int64           `json:"size"`
+	Records int             `json:"records"`
+	Corrupt []*CorruptRange `json:"corrupt"`
+}
+
+/**
+* ToJson
+* @return et.Json
+**/
+func (s *SegmentReport) ToJson() et.Json {
+	corrupt := []et.Json{}
+	for _, r := range s.Corrupt {
+		corrupt = append(corrupt, r.ToJson())
+	}
+
+	return et.Json{
+		"segment": s.Segment,
+		"size":    s.Size,
+		"records": s.Records,
+		"corrupt": corrupt,
+	}
+}
+
+/**
+* validRecord: Validates the fields that the checksum does not cover
+* @param h recordHeader, offset, limit int64
+* @return bool
+**/
+func validRecord(h recordHeader, offset, limit int64) bool {
+	switch h.Status {
+	case Active, Deleted:
+	case BatchBegin, BatchCommit:
+		if h.ID != batchMarker || h.DataLen != 4 {
+			return false
+		}
+	default:
+		return false
+	}
+	if h.Flags&flagCompressMask > CompressZstd {
+		return false
+	}
+
+	return offset+h.RecordSize() <= limit
+}
+
+/**
+* scan: Walks the records of the segment up to limit, the corrupt ranges are skipped and returned
+* @param limit int64, fn func(h recordHeader, data []byte, offset int64)
+* @return []*CorruptRange
+**/
+func (s *segment) scan(limit int64, fn func(h recordHeader, data []byte, offset int64)) []*CorruptRange {
+	return s.scanFrom(s.start(), limit, fn)
+}
+
+/**
+* scanFrom: Walks the records of the segment from start up to limit, start must be the offset of a record
+* @param start, limit int64, fn func(h recordHeader, data []byte, offset int64)
+* @return []*CorruptRange
+**/
+func (s *segment) scanFrom(start, limit int64, fn func(h recordHeader, data []byte, offset int64)) []*CorruptRange {
+	result := []*CorruptRange{}
+	offset := start
+	for offset < limit {
+		h, data, err := s.readRecordAt(offset, limit)
+		if err == nil && validRecord(h, offset, limit) {
+			fn(h, data, offset)
+			offset += h.RecordSize()
+			continue
+		}
+
+		reason := msg.MSG_CORRUPTED_RECORD
+		if err == nil || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			reason = msg.MSG_INCOMPLETE_RECORD
+		} else if err.Error() != msg.MSG_CORRUPTED_RECORD {
+			reason = err.Error()
+		}
+
+		next := s.resync(offset+1, limit)
+		result = append(result, &CorruptRange{
+			Segment: s.name,
+			Start:   offset,
+			End:     next,
+			Reason:  reason,
+		})
+		offset = next
+	}
+
+	return result
+}
+
+/**
+* resync: Searches the next valid record starting at from, returns limit when there is none
+* @param from, limit int64
+* @return int64
+**/
+func (s *segment) resync(from, limit int64) int64 {
+	buf := make([]byte, resyncWindow)
+	pos := from
+	for pos < limit {
+		n, _ := s.ReadAt(buf, pos)
+		if int64(n) > limit-pos {
+			n = int(limit - pos)
+		}
+		if n < 10 {
+			break
+		}
+
+		for i := 0; i+10 <= n; i++ {
+			// Descartar candidatos sin leer el disco
+			at := pos + int64(i)
+			dataLen := getUint32(buf[i : i+4])
+			idLen := getUint16(buf[i+8 : i+10])
+			if idLen == 0 || at+recordHeaderSize(s.version, int(idLen))+int64(dataLen) > limit {
+				continue
+			}
+
+			h, _, err := s.readRecordAt(at, limit)
+			if err == nil && validRecord(h, at, limit) {
+				return at
+			}
+		}
+
+		pos += int64(n - 9)
+	}
+
+	return limit
+}
+
+/**
+* Verify: Checks every record of the segments and reports the corrupt ranges, the compaction waits until it ends
+* @return []*SegmentReport, error
+**/
+func (s *FileStore) Verify() ([]*SegmentReport, error) {
+	s.writeMu.Lock()
+	segments := append([]*segment{}, s.segments...)
+	sizes := make([]int64, len(segments))
+	for i, seg := range segments {
+		sizes[i] = seg.size
+	}
+
+	// Como en el backup, los segmentos leídos no se cierran ni se reemplazan mientras se leen
+	s.pins.Add(1)
+	defer s.pins.Add(-1)
+	s.writeMu.Unlock()
+
+	result := []*SegmentReport{}
+	for i, seg := range segments {
+		report := &SegmentReport{
+			Segment: seg.name,
+			Size:    sizes[i],
+		}
+		report.Corrupt = seg.replay(sizes[i], func(h recordHeader, offset int64) {
+			report.Records++
+		})
+		if len(report.Corrupt) > 0 {
+			logs.Alertf("verify:%s:%s:%s corrupt ranges:%d", s.Path, s.Name, seg.name, len(report.Corrupt))
+		}
+		result = append(result, report)
+	}
+
+	return result, nil
+}
+
+/**
+* quarantine: Copies the bytes of the corrupt range to the quarantine directory
+* @param seg *segment, r *CorruptRange
+* @return error
+**/
+func (s *FileStore) quarantine(seg *segment, r *CorruptRange) error {
+	if err := s.fs.MkdirAll(s.PathQuarantine, 0755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%d-%d.bad", seg.name, r.Start, r.End)
+	path := filepath.Join(s.PathQuarantine, name)
+	if s.fs.Exists(path) {
+		return nil // ya está en cuarentena
+	}
+
+	data := make([]byte, r.End-r.Start)
+	n, err := seg.ReadAt(data, r.Start)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	return writeFile(s.fs, path, data[:n])
+}
+
+/**
+* repairSegment: Quarantines the corrupt ranges and truncates the torn tail of the active segment
+* @param segIndex int, corrupt []*CorruptRange
+* @return error
+**/
+func (s *FileStore) repairSegment(segIndex int, corrupt []*CorruptRange) error {
+	seg := s.segments[segIndex]
+	for _, r := range corrupt {
+		if err := s.quarantine(seg, r); err != nil {
+			return err
+		}
+		logs.Alertf("recover:%s:%s:%s", s.Path, s.Name, r.ToString())
+	}
+
+	last := corrupt[len(corrupt)-1]
+	if segIndex != len(s.segments)-1 || last.End != seg.size {
+		return nil
+	}
+
+	// Cola rota: las escrituras siguientes continúan desde el último registro válido
+	if err := seg.file.Truncate(last.Start); err != nil {
+		return err
+	}
+	if err := seg.Sync(); err != nil {
+		return err
+	}
+	s.Size -= seg.size - last.Start
+	seg.size = last.Start
+
+	return nil
+}
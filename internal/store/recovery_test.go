@@ -0,0 +1,281 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+/**
+* segmentFile: Returns the path of the only segment of the store
+* @param t *testing.T, fs *FileStore
+* @return string
+**/
+func segmentFile(t *testing.T, fs *FileStore) string {
+	t.Helper()
+	entries, err := os.ReadDir(fs.PathSegments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected one segment, got %d", len(entries))
+	}
+
+	return filepath.Join(fs.PathSegments, entries[0].Name())
+}
+
+/**
+* loadRecords: Opens the store in dir and puts n records, the store is left open
+* @param t *testing.T, dir string, n int
+* @return *FileStore
+**/
+func loadRecords(t *testing.T, dir string, n int) *FileStore {
+	t.Helper()
+	fs, err := Open(dir, "recovery", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < n; i++ {
+		if err := fs.Put(fmt.Sprintf("k%d", i), fmt.Sprintf("value-%03d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return fs
+}
+
+/**
+* rewrite: Applies fn to the content of the file
+* @param t *testing.T, name string, fn func(data []byte) []byte
+**/
+func rewrite(t *testing.T, name string, fn func(data []byte) []byte) {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(name, fn(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/**
+* reopen: Closes the store without its snapshot, as a process that stopped after the last sync, and opens it again
+* @param t *testing.T, dir string, fs *FileStore
+* @return *FileStore
+**/
+func reopen(t *testing.T, dir string, fs *FileStore) *FileStore {
+	t.Helper()
+	fs.Close()
+	if err := os.RemoveAll(fs.PathSnapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Open(dir, "recovery", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+/**
+* expectValues: Checks the values of the records from..to-1 and that the following do not exist
+* @param t *testing.T, fs *FileStore, to, n int
+**/
+func expectValues(t *testing.T, fs *FileStore, to, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		var value string
+		exists, err := fs.Get(fmt.Sprintf("k%d", i), &value)
+		if err != nil {
+			t.Fatalf("k%d: %v", i, err)
+		}
+		if i < to && (!exists || value != fmt.Sprintf("value-%03d", i)) {
+			t.Fatalf("k%d lost after recovery: %v %q", i, exists, value)
+		}
+		if i >= to && exists {
+			t.Fatalf("k%d should be lost with the torn tail", i)
+		}
+	}
+}
+
+func TestRecoveryTruncatedTail(t *testing.T) {
+	dir := t.TempDir()
+	fs := loadRecords(t, dir, 10)
+	name := segmentFile(t, fs)
+
+	// El último registro queda a medio escribir
+	rewrite(t, name, func(data []byte) []byte {
+		return data[:len(data)-5]
+	})
+
+	fs = reopen(t, dir, fs)
+	defer func() { fs.Close() }()
+	expectValues(t, fs, 9, 10)
+
+	// El log sigue aceptando escrituras después de la reparación
+	if err := fs.Put("k9", "value-009"); err != nil {
+		t.Fatal(err)
+	}
+	fs = reopen(t, dir, fs)
+	expectValues(t, fs, 10, 10)
+
+	reports, err := fs.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, report := range reports {
+		if len(report.Corrupt) > 0 {
+			t.Fatalf("segment still corrupt after repair: %v", report.ToJson())
+		}
+	}
+}
+
+func TestRecoveryPartialHeader(t *testing.T) {
+	dir := t.TempDir()
+	fs := loadRecords(t, dir, 10)
+	name := segmentFile(t, fs)
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Solo llegaron algunos bytes de la cabecera del siguiente registro
+	rewrite(t, name, func(data []byte) []byte {
+		return append(data, 0x01, 0x00, 0x00)
+	})
+
+	fs = reopen(t, dir, fs)
+	defer fs.Close()
+	expectValues(t, fs, 10, 10)
+
+	current := segmentFile(t, fs)
+	if now, err := os.Stat(current); err != nil || now.Size() != info.Size() {
+		t.Fatalf("torn tail not truncated: %v, expected size %d", err, info.Size())
+	}
+}
+
+func TestRecoveryCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	fs := loadRecords(t, dir, 10)
+	name := segmentFile(t, fs)
+
+	// Un bit cambiado dentro del valor de k5 invalida su checksum
+	rewrite(t, name, func(data []byte) []byte {
+		i := bytes.Index(data, []byte("value-005"))
+		if i < 0 {
+			t.Fatal("value not found in the segment")
+		}
+		data[i+len("value-")] ^= 0x01
+		return data
+	})
+
+	reports, err := fs.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || len(reports[0].Corrupt) != 1 {
+		t.Fatalf("corrupt record not reported: %v", reports)
+	}
+
+	fs = reopen(t, dir, fs)
+	defer fs.Close()
+	for i := 0; i < 10; i++ {
+		var value string
+		exists, err := fs.Get(fmt.Sprintf("k%d", i), &value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != (i != 5) {
+			t.Fatalf("k%d exists %v after skipping the corrupt record", i, exists)
+		}
+	}
+
+	quarantined, err := os.ReadDir(fs.PathQuarantine)
+	if err != nil || len(quarantined) == 0 {
+		t.Fatalf("corrupt range not quarantined: %v %v", quarantined, err)
+	}
+}
+
+func TestVerifyDuringCompaction(t *testing.T) {
+	dir := t.TempDir()
+	fs := loadRecords(t, dir, 50)
+	defer fs.Close()
+
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 100; i++ {
+			if err := fs.Put(fmt.Sprintf("k%d", i%50), fmt.Sprintf("value-%03d", i%50)); err != nil {
+				done <- err
+				return
+			}
+			if err := fs.Compact(); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	// Los segmentos que lee Verify no se cierran por la compactación
+	for running := true; running; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			running = false
+		default:
+		}
+
+		reports, err := fs.Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, report := range reports {
+			if len(report.Corrupt) > 0 {
+				t.Fatalf("segment read while compacted: %v", report.ToJson())
+			}
+		}
+	}
+}
+
+func TestRecoveryHugeDataLen(t *testing.T) {
+	dir := t.TempDir()
+	fs := loadRecords(t, dir, 10)
+	name := segmentFile(t, fs)
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Una cabecera rota al final declara casi 4 GiB de datos
+	header := make([]byte, recordHeaderSize(segmentVersion, 2))
+	putUint32(header[0:4], 0xFFFFFFF0)
+	putUint16(header[8:10], 2)
+	copy(header[10:12], "kx")
+	header[12] = Active
+	rewrite(t, name, func(data []byte) []byte {
+		return append(data, header...)
+	})
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	fs = reopen(t, dir, fs)
+	defer fs.Close()
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 64<<20 {
+		t.Fatalf("open allocated %d bytes for a corrupt header", n)
+	}
+
+	expectValues(t, fs, 10, 10)
+	if now, err := os.Stat(name); err != nil || now.Size() != info.Size() {
+		t.Fatalf("corrupt header not truncated: %v, expected size %d", err, info.Size())
+	}
+}
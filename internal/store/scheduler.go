@@ -0,0 +1,706 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cgalvisleon/et/envar"
+	"github.com/cgalvisleon/et/logs"
+)
+
+/**
+* SegmentStats: Live and dead bytes of a segment
+**/
+type SegmentStats struct {
+	Name      string  `json:"name"`
+	Size      int64   `json:"size"`
+	Live      int64   `json:"live"`
+	Dead      int64   `json:"dead"`
+	Garbage   float64 `json:"garbage"`
+	Active    bool    `json:"active"`
+	Compacted bool    `json:"compacted"`
+}
+
+/**
+* CompactionProgress: State of the compaction pass in course
+**/
+type CompactionProgress struct {
+	Running    bool   `json:"running"`
+	Paused     bool   `json:"paused"`
+	Segment    string `json:"segment"`
+	Segments   int    `json:"segments"`
+	Done       int    `json:"done"`
+	BytesTotal int64  `json:"bytes_total"`
+	BytesDone  int64  `json:"bytes_done"`
+}
+
+/**
+* CompactionStats: Totals of the compaction passes since the store was opened
+**/
+type CompactionStats struct {
+	Runs      int64     `json:"runs"`
+	Segments  int64     `json:"segments"`
+	BytesRead int64     `json:"bytes_read"`
+	Written   int64     `json:"written"`
+	Reclaimed int64     `json:"reclaimed"`
+	Canceled  int64     `json:"canceled"`
+	LastRun   time.Time `json:"last_run"`
+	LastError string    `json:"last_error"`
+}
+
+/**
+* Compactor: Background compaction of the segments with more garbage than the ratio, throttled to rate bytes per second
+**/
+type Compactor struct {
+	store    *FileStore
+	mu       sync.Mutex
+	ratio    float64            // fracción de basura desde la que se compacta un segmento
+	rate     int64              // bytes por segundo, 0 sin límite
+	interval time.Duration      // revisión periódica de los segmentos
+	paused   bool               // la pasada en curso espera a Resume
+	resume   chan struct{}      // se cierra con Resume
+	cancel   context.CancelFunc // cancela la pasada en curso
+	quit     context.CancelFunc // detiene el loop
+	wake     chan struct{}      // aviso de escrituras con basura
+	wg       sync.WaitGroup     // espera el loop
+	progress CompactionProgress
+	stats    CompactionStats
+}
+
+/**
+* newCompactor
+* @param store *FileStore
+* @return *Compactor
+**/
+func newCompactor(store *FileStore) *Compactor {
+	ratio := envar.GetNumber("COMPACT_GARBAGE_RATIO", 0.5)
+	if ratio <= 0 || ratio > 1 {
+		ratio = 0.5
+	}
+	rate := envar.GetInt64("COMPACT_RATE_MB", 16) * 1024 * 1024
+	interval := time.Duration(envar.GetInt64("COMPACT_INTERVAL", 60)) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	return &Compactor{
+		store:    store,
+		ratio:    ratio,
+		rate:     max(rate, 0),
+		interval: interval,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+/**
+* Compactor: Returns the compaction scheduler of the store
+* @return *Compactor
+**/
+func (s *FileStore) Compactor() *Compactor {
+	return s.compactor
+}
+
+/**
+* SegmentStats: Returns the live and dead bytes of each segment
+* @return []SegmentStats
+**/
+func (s *FileStore) SegmentStats() []SegmentStats {
+	s.reapExpired()
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.indexMu.RLock()
+	defer s.indexMu.RUnlock()
+
+	result := make([]SegmentStats, 0, len(s.segments))
+	for i, seg := range s.segments {
+		item := SegmentStats{
+			Name:      seg.name,
+			Size:      seg.size,
+			Live:      seg.live,
+			Dead:      seg.garbage(),
+			Active:    i == len(s.segments)-1,
+			Compacted: seg.compacted,
+		}
+		if size := seg.size - seg.start(); size > 0 {
+			item.Garbage = float64(item.Dead) / float64(size)
+		}
+		result = append(result, item)
+	}
+
+	return result
+}
+
+/**
+* SetRatio: Sets the fraction of garbage from which a segment is compacted
+* @param ratio float64
+**/
+func (s *Compactor) SetRatio(ratio float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ratio > 0 && ratio <= 1 {
+		s.ratio = ratio
+	}
+}
+
+/**
+* SetRate: Sets the bytes per second that the compaction can read, 0 is unlimited
+* @param rate int64
+**/
+func (s *Compactor) SetRate(rate int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rate = max(rate, 0)
+}
+
+/**
+* Pause: The pass in course stops at the next record until Resume
+**/
+func (s *Compactor) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.paused {
+		return
+	}
+
+	s.paused = true
+	s.resume = make(chan struct{})
+	s.progress.Paused = true
+}
+
+/**
+* Resume
+**/
+func (s *Compactor) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.paused {
+		return
+	}
+
+	s.paused = false
+	close(s.resume)
+	s.progress.Paused = false
+}
+
+/**
+* Cancel: Cancels the pass in course, the segments already compacted are kept
+**/
+func (s *Compactor) Cancel() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+/**
+* Progress
+* @return CompactionProgress
+**/
+func (s *Compactor) Progress() CompactionProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.progress
+}
+
+/**
+* Stats
+* @return CompactionStats
+**/
+func (s *Compactor) Stats() CompactionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stats
+}
+
+/**
+* Run: Compacts the segments above the garbage ratio, ctx cancels the pass
+* @param ctx context.Context
+* @return error
+**/
+func (s *Compactor) Run(ctx context.Context) error {
+	st := s.store
+	if err := st.writable(); err != nil {
+		return err
+	}
+
+	if !st.compacting.CompareAndSwap(false, true) {
+		return nil // ya hay una compactación en curso
+	}
+	defer st.compacting.Store(false)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.mu.Lock()
+	ratio := s.ratio
+	s.mu.Unlock()
+
+	victims, total := st.victims(ratio)
+	if len(victims) == 0 {
+		return nil
+	}
+
+	s.begin(cancel, len(victims), total)
+	throttle := newThrottle()
+
+	var err error
+	prefix := true
+	compacted := 0
+	for n, i := range victims {
+		// Sin segmentos anteriores pendientes los borrados ya no ocultan registros y se descartan
+		prefix = prefix && i == n
+		var done bool
+		done, err = st.compactSegment(ctx, i, prefix, func(read int64) error {
+			return s.advance(ctx, throttle, read)
+		})
+		if err != nil {
+			break
+		}
+		prefix = prefix && done
+		s.segmentDone(done)
+		if done {
+			compacted++
+		}
+	}
+
+	// Los punteros descartados pueden dejar blobs huérfanos
+	if err == nil && compacted > 0 {
+		_, err = st.CollectBlobs()
+	}
+
+	s.end(err)
+	return err
+}
+
+/**
+* wait: Blocks while the compactor is paused
+* @param ctx context.Context
+* @return error
+**/
+func (s *Compactor) wait(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		if !s.paused {
+			s.mu.Unlock()
+			return ctx.Err()
+		}
+		resume := s.resume
+		s.mu.Unlock()
+
+		select {
+		case <-resume:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+/**
+* advance: Accounts the bytes read, waits while paused and throttles to the rate
+* @param ctx context.Context, throttle *throttle, read int64
+* @return error
+**/
+func (s *Compactor) advance(ctx context.Context, throttle *throttle, read int64) error {
+	s.mu.Lock()
+	s.progress.BytesDone += read
+	s.stats.BytesRead += read
+	rate := s.rate
+	s.mu.Unlock()
+
+	if err := s.wait(ctx); err != nil {
+		return err
+	}
+
+	return throttle.wait(ctx, read, rate)
+}
+
+/**
+* begin
+* @param cancel context.CancelFunc, segments int, total int64
+**/
+func (s *Compactor) begin(cancel context.CancelFunc, segments int, total int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cancel = cancel
+	s.progress = CompactionProgress{
+		Running:    true,
+		Paused:     s.paused,
+		Segments:   segments,
+		BytesTotal: total,
+	}
+	s.stats.Runs++
+	s.stats.LastRun = time.Now()
+}
+
+/**
+* segmentDone
+* @param done bool
+**/
+func (s *Compactor) segmentDone(done bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.progress.Done++
+	if done {
+		s.stats.Segments++
+	}
+}
+
+/**
+* end
+* @param err error
+**/
+func (s *Compactor) end(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cancel = nil
+	s.progress.Running = false
+	s.progress.Segment = ""
+	s.stats.LastError = ""
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		s.stats.Canceled++
+	} else if err != nil {
+		s.stats.LastError = err.Error()
+	}
+}
+
+/**
+* reclaimed: Accounts a segment swapped by the compaction
+* @param read, written int64
+**/
+func (s *Compactor) reclaimed(read, written int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.Written += written
+	s.stats.Reclaimed += max(read-written, 0)
+}
+
+/**
+* current: Sets the segment in course
+* @param name string
+**/
+func (s *Compactor) current(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.progress.Segment = name
+}
+
+/**
+* start: Starts the loop that runs a pass on each notification or interval
+**/
+func (s *Compactor) start() {
+	ctx, quit := context.WithCancel(context.Background())
+	s.quit = quit
+	s.wg.Add(1)
+	go s.loop(ctx)
+}
+
+/**
+* stop: Cancels the pass in course and waits the loop
+**/
+func (s *Compactor) stop() {
+	if s.quit == nil {
+		return
+	}
+
+	s.quit()
+	s.wg.Wait()
+	s.quit = nil
+}
+
+/**
+* notify: Wakes up the loop without blocking the writer
+**/
+func (s *Compactor) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+/**
+* loop
+* @param ctx context.Context
+**/
+func (s *Compactor) loop(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-ticker.C:
+		}
+
+		if err := s.Run(ctx); err != nil && ctx.Err() == nil {
+			logs.Error(err)
+		}
+	}
+}
+
+type throttle struct {
+	start time.Time
+	bytes int64
+}
+
+/**
+* newThrottle
+* @return *throttle
+**/
+func newThrottle() *throttle {
+	return &throttle{start: time.Now()}
+}
+
+/**
+* wait: Sleeps until the bytes read fit in the rate
+* @param ctx context.Context, n, rate int64
+* @return error
+**/
+func (s *throttle) wait(ctx context.Context, n, rate int64) error {
+	s.bytes += n
+	if rate <= 0 {
+		return nil
+	}
+
+	expected := time.Duration(float64(s.bytes) / float64(rate) * float64(time.Second))
+	delay := expected - time.Since(s.start)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/**
+* victims: Returns the sealed segments with garbage over the ratio and the bytes to read
+* @param ratio float64
+* @return []int, int64
+**/
+func (s *FileStore) victims(ratio float64) ([]int, int64) {
+	s.reapExpired()
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.indexMu.RLock()
+	defer s.indexMu.RUnlock()
+
+	result := []int{}
+	total := int64(0)
+	for i, seg := range s.segments[:len(s.segments)-1] {
+		size := seg.size - seg.start()
+		garbage := seg.garbage()
+		if size <= 0 || garbage == 0 {
+			continue
+		}
+
+		if float64(garbage)/float64(size) >= ratio {
+			result = append(result, i)
+			total += size
+		}
+	}
+
+	return result, total
+}
+
+type movedRecord struct {
+	id     string
+	offset int64
+	ref    *RecordRef
+}
+
+/**
+* recordState: Returns if the record is the current one of its key and if it marks a deletion or an expiration
+* @param seg *segment, segIndex int, h recordHeader, offset, now int64
+* @return bool, bool
+**/
+func (s *FileStore) recordState(seg *segment, segIndex int, h recordHeader, offset, now int64) (bool, bool) {
+	if h.Status == Deleted {
+		return false, true
+	}
+
+	s.indexMu.RLock()
+	ref, ok := s.index.Get(h.ID)
+	s.indexMu.RUnlock()
+
+	if ok && ref.segment == segIndex && ref.offset == offset {
+		return !ref.expired(now), ref.expired(now)
+	}
+	if ok {
+		return false, false // hay una versión posterior
+	}
+
+	expires, _ := seg.readExpiry(h, offset)
+	return false, expires != 0 && expires <= now
+}
+
+/**
+* compactSegment: Rewrites the sealed segment with its live records, the deletions and expirations are kept unless dropMarkers
+* @param ctx context.Context, segIndex int, dropMarkers bool, advance func(read int64) error
+* @return bool, error
+**/
+func (s *FileStore) compactSegment(ctx context.Context, segIndex int, dropMarkers bool, advance func(read int64) error) (bool, error) {
+	// Los blobs reescritos con la llave actual se referencian recién en el swap
+	s.rewrapping.Add(1)
+	defer s.rewrapping.Add(-1)
+
+	s.writeMu.Lock()
+	old := s.segments[segIndex]
+	limit := old.size
+	horizon := s.seq.Load()
+	s.writeMu.Unlock()
+	s.compactor.current(old.name)
+
+	// Registros confirmados en el orden del log
+	records := []replayRecord{}
+	old.replay(limit, func(h recordHeader, offset int64) {
+		records = append(records, replayRecord{header: h, offset: offset})
+	})
+
+	tmp := filepath.Join(s.PathCompact, old.name)
+	s.fs.Remove(tmp)
+	seg, err := openSegment(s.fs, tmp, old.name, horizon)
+	if err != nil {
+		return false, err
+	}
+
+	abort := func(err error) (bool, error) {
+		seg.Close()
+		s.fs.Remove(tmp)
+		return false, err
+	}
+
+	if err := seg.markCompacted(); err != nil {
+		return abort(err)
+	}
+
+	moved := []movedRecord{}
+	dropped := 0
+	now := time.Now().UnixNano()
+	for _, item := range records {
+		if err := ctx.Err(); err != nil {
+			return abort(err)
+		}
+
+		h, offset := item.header, item.offset
+		live, marker := s.recordState(old, segIndex, h, offset, now)
+		if live || (marker && !dropMarkers) {
+			_, stored, err := old.readRecordAt(offset, limit)
+			if err != nil {
+				return abort(err)
+			}
+			_, expires, err := splitExpiry(stored, h.Flags)
+			if err != nil {
+				return abort(err)
+			}
+
+			flags := h.Flags
+			if h.Status == Active {
+				s.blobMu.RLock()
+				stored, flags, err = s.rewrap(h.ID, stored, flags, false)
+				s.blobMu.RUnlock()
+				if err != nil {
+					return abort(err)
+				}
+			}
+
+			rec := newLogRecord(h.ID, stored, h.Status, flags)
+			rec.seq, rec.timestamp, rec.expires = h.Seq, h.Timestamp, expires
+			ref, err := seg.WriteRecord(rec)
+			if err != nil {
+				return abort(err)
+			}
+			ref.segment = segIndex
+
+			if live {
+				moved = append(moved, movedRecord{id: h.ID, offset: offset, ref: ref})
+			} else {
+				seg.retained += recordHeaderSize(segmentVersion, len(h.ID)) + int64(ref.length)
+			}
+		} else {
+			dropped++
+		}
+
+		if err := advance(h.RecordSize()); err != nil {
+			return abort(err)
+		}
+	}
+
+	if err := seg.Sync(); err != nil {
+		return abort(err)
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if s.isClosed() || s.pins.Load() > 0 || s.segments[segIndex] != old {
+		return abort(nil) // hay lectores del log, se compacta en la siguiente oportunidad
+	}
+
+	// El snapshot tiene los offsets anteriores, sin él la apertura reproduce el log
+	if err := s.fs.Remove(s.snapshotPath()); err != nil && s.fs.Exists(s.snapshotPath()) {
+		return abort(err)
+	}
+
+	s.indexMu.Lock()
+	if err := s.fs.Rename(tmp, filepath.Join(s.PathSegments, old.name)); err != nil {
+		s.indexMu.Unlock()
+		return abort(err)
+	}
+
+	old.file.Close()
+	s.cache.removeSegment(old)
+	s.segments[segIndex] = seg
+	for _, m := range moved {
+		ref, ok := s.index.Get(m.id)
+		if !ok || ref.segment != segIndex || ref.offset != m.offset {
+			continue // reescrito después de la copia, queda como basura
+		}
+
+		m.ref.expires = ref.expires
+		s.index.Set(m.id, m.ref)
+		seg.live += recordHeaderSize(segmentVersion, len(m.id)) + int64(m.ref.length)
+	}
+	s.Size += seg.size - old.size
+	s.lastCompaction = time.Now()
+	for {
+		current := s.tombStones.Load()
+		if s.tombStones.CompareAndSwap(current, max(current-int64(dropped), 0)) {
+			break
+		}
+	}
+	s.indexMu.Unlock()
+
+	s.compactor.reclaimed(old.size, seg.size)
+	if s.isDebug {
+		logs.Debug("compacted:", s.Path, ":", s.Name, ":segment:", old.name, ":size:", old.size, ":", seg.size)
+	}
+
+	return true, s.createSnapshot()
+}
@@ -1,23 +1,41 @@
 package store
 
 import (
-	"bytes"
-	"encoding/binary"
-	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"os"
-	"sync"
 
 	"github.com/cgalvisleon/et/et"
 	"github.com/cgalvisleon/josefina/pkg/msg"
 )
 
 type recordHeader struct {
-	DataLen uint32 `json:"data_len"`
-	CRC     uint32 `json:"crc"`
-	IDLen   uint16 `json:"id_len"`
-	ID      string `json:"id"`
-	Status  byte   `json:"status"`
+	DataLen   uint32 `json:"data_len"`
+	CRC       uint32 `json:"crc"`
+	IDLen     uint16 `json:"id_len"`
+	ID        string `json:"id"`
+	Status    byte   `json:"status"`
+	Flags     byte   `json:"flags"`
+	Seq       uint64 `json:"seq"`
+	Timestamp int64  `json:"timestamp"`
+	version   uint16
+}
+
+/**
+* recordHeaderSize: Returns the header length of a record for the segment version
+* @param version uint16, idLen int
+* @return int64
+**/
+func recordHeaderSize(version uint16, idLen int) int64 {
+	switch version {
+	case segmentV1:
+		return int64(fixedHeaderSize) + int64(idLen)
+	case segmentV2:
+		return int64(fixedHeaderSize) + 1 + int64(idLen)
+	default:
+		return int64(fixedHeaderSize) + 17 + int64(idLen)
+	}
 }
 
 /**
@@ -25,7 +43,7 @@ type recordHeader struct {
 * @return int64
 **/
 func (s *recordHeader) HeaderSize() int64 {
-	return int64(fixedHeaderSize) + int64(s.IDLen)
+	return recordHeaderSize(s.version, int(s.IDLen))
 }
 
 /**
@@ -40,6 +58,8 @@ type RecordRef struct {
 	segment int
 	offset  int64
 	length  uint32
+	seq     uint64 // versión del registro, 0 si aún no se leyó del segmento
+	expires int64  // vencimiento en nanosegundos, 0 si no vence
 }
 
 /**
@@ -63,40 +83,185 @@ func (s *RecordRef) ToString() string {
 }
 
 type segment struct {
-	file *os.File
-	size int64
-	name string
-	ch   chan []byte
-	wg   sync.WaitGroup
+	file      File
+	size      int64
+	name      string
+	version   uint16
+	baseSeq   uint64 // las secuencias hasta baseSeq están en segmentos anteriores o fueron compactadas
+	compacted bool   // reescrito por la compactación, el historial hasta baseSeq se perdió
+	live      int64  // bytes de los registros a los que apunta el índice
+	retained  int64  // bytes de borrados y vencidos que la compactación debe conservar
+	readOnly  bool   // abierto sin escritura, no se sincroniza
 }
 
 /**
 * newSegment
-* @param file *os.File, size int64, name string
+* @param file File, size int64, name string, version uint16, baseSeq uint64
 * @return *segment
 **/
-func newSegment(file *os.File, size int64, name string) *segment {
-	result := &segment{
-		file: file,
-		size: size,
-		name: name,
-		ch:   make(chan []byte),
+func newSegment(file File, size int64, name string, version uint16, baseSeq uint64) *segment {
+	return &segment{
+		file:    file,
+		size:    size,
+		name:    name,
+		version: version,
+		baseSeq: baseSeq,
+	}
+}
+
+/**
+* segmentHeaderLen: Returns the length of the file header for the version
+* @param version uint16
+* @return int64
+**/
+func segmentHeaderLen(version uint16) int64 {
+	switch version {
+	case segmentV1:
+		return 0
+	case segmentV2:
+		return segmentHeaderSize
+	default:
+		return segmentHeaderSize + 8
+	}
+}
+
+/**
+* openSegment: Opens the segment file, the empty files are initialized with the current version
+* @param fsys FS, path, name string, baseSeq uint64
+* @return *segment, error
+**/
+func openSegment(fsys FS, path, name string, baseSeq uint64) (*segment, error) {
+	fd, err := fsys.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := fd.Size()
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	if size == 0 {
+		headerLen := segmentHeaderLen(segmentVersion)
+		header := make([]byte, headerLen)
+		copy(header[0:4], segmentMagic)
+		putUint16(header[4:6], segmentVersion)
+		putUint64(header[8:16], baseSeq)
+		if _, err := fd.WriteAt(header, 0); err != nil {
+			fd.Close()
+			return nil, err
+		}
+
+		return newSegment(fd, headerLen, name, segmentVersion, baseSeq), nil
+	}
+
+	result, err := readSegment(fd, size, name)
+	if err != nil {
+		fd.Close()
+		return nil, err
 	}
 
-	result.wg.Add(1)
-	go result.loop()
-	return result
+	return result, nil
 }
 
 /**
-* loop
-* @return void
+* openSegmentReadOnly: Opens the segment file without writing it, a file without header is an error
+* @param fsys FS, path, name string
+* @return *segment, error
 **/
-func (s *segment) loop() {
-	defer s.wg.Done()
-	for data := range s.ch {
-		s.file.Write(data)
+func openSegmentReadOnly(fsys FS, path, name string) (*segment, error) {
+	fd, err := fsys.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
 	}
+
+	size, err := fd.Size()
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	if size == 0 {
+		fd.Close()
+		return nil, fmt.Errorf(msg.MSG_SEGMENT_EMPTY, name)
+	}
+
+	result, err := readSegment(fd, size, name)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	result.readOnly = true
+	return result, nil
+}
+
+/**
+* readSegment: Reads the header of a segment file that is not empty
+* @param fd File, size int64, name string
+* @return *segment, error
+**/
+func readSegment(fd File, size int64, name string) (*segment, error) {
+	// Los segmentos sin cabecera son del formato original
+	version := segmentV1
+	baseSeq := uint64(0)
+	compacted := false
+	if size >= segmentHeaderSize {
+		header := make([]byte, segmentHeaderSize)
+		if _, err := fd.ReadAt(header, 0); err != nil {
+			return nil, err
+		}
+
+		if string(header[0:4]) == segmentMagic {
+			version = getUint16(header[4:6])
+			if version <= segmentV1 || version > segmentVersion {
+				return nil, errors.New(msg.MSG_INVALID_SEGMENT_VERSION)
+			}
+			compacted = header[6]&segmentCompacted != 0
+		}
+	}
+
+	if version >= segmentV3 {
+		seq := make([]byte, 8)
+		if _, err := fd.ReadAt(seq, segmentHeaderSize); err != nil {
+			return nil, err
+		}
+		baseSeq = getUint64(seq)
+	}
+
+	result := newSegment(fd, size, name, version, baseSeq)
+	result.compacted = compacted
+	return result, nil
+}
+
+/**
+* markCompacted: Flags in the file header that the segment was rewritten by the compaction
+* @return error
+**/
+func (s *segment) markCompacted() error {
+	if _, err := s.file.WriteAt([]byte{segmentCompacted}, 6); err != nil {
+		return err
+	}
+
+	s.compacted = true
+	return nil
+}
+
+/**
+* garbage: Returns the bytes that a compaction of the segment would release
+* @return int64
+**/
+func (s *segment) garbage() int64 {
+	return max(s.size-s.start()-s.live-s.retained, 0)
+}
+
+/**
+* start: Returns the offset of the first record
+* @return int64
+**/
+func (s *segment) start() int64 {
+	return segmentHeaderLen(s.version)
 }
 
 /**
@@ -115,8 +280,10 @@ func (s *segment) Sync() error {
 * @return error
 **/
 func (s *segment) Close() error {
-	close(s.ch)
-	s.wg.Wait()
+	if s.readOnly {
+		return s.file.Close()
+	}
+
 	err := s.Sync()
 	if err != nil {
 		return err
@@ -130,9 +297,13 @@ func (s *segment) Close() error {
 **/
 func (s *segment) ToJson() et.Json {
 	return et.Json{
-		"file": s.file.Name(),
-		"size": s.size,
-		"name": s.name,
+		"file":      s.file.Name(),
+		"size":      s.size,
+		"name":      s.name,
+		"version":   s.version,
+		"base_seq":  s.baseSeq,
+		"compacted": s.compacted,
+		"live":      s.live,
 	}
 }
 
@@ -157,132 +328,172 @@ func (s *segment) ReadAt(b []byte, off int64) (int, error) {
 }
 
 /**
-* Write
+* Write: Writes b at the end of the segment, the caller advances size
 * @param b []byte
+* @return error
 **/
-func (s *segment) Write(b []byte) {
+func (s *segment) Write(b []byte) error {
 	if s.file == nil {
-		return
-	}
-	s.ch <- b
-}
-
-/**
-* WriteHeader
-* @param id string, data []byte, status byte
-* @return *RecordRef, error
-**/
-func (s *segment) WriteHeader(id string, data []byte, status byte) (*RecordRef, error) {
-	h, header, err := newRecordHeaderAt(id, data, status)
-	if err != nil {
-		return nil, err
+		return errors.New(msg.MSG_FILE_IS_NIL)
 	}
 
-	offset := s.size
-
-	s.Write(header)
-	s.size += h.HeaderSize()
-
-	return &RecordRef{
-		offset: offset,
-		length: h.DataLen,
-	}, nil
+	_, err := s.file.WriteAt(b, s.size)
+	return err
 }
 
 /**
 * WriteRecord
-* @param seg *segment, id string, data []byte, status byte
+* @param rec *logRecord
 * @return *RecordRef, error
 **/
-func (s *segment) WriteRecord(id string, data []byte, status byte) (*RecordRef, error) {
-	h, header, err := newRecordHeaderAt(id, data, status)
+func (s *segment) WriteRecord(rec *logRecord) (*RecordRef, error) {
+	h, header, err := newRecordHeaderAt(rec)
 	if err != nil {
 		return nil, err
 	}
 
 	offset := s.size
 
-	s.Write(header)
-	if len(data) > 0 {
-		s.Write(data)
+	record := make([]byte, 0, h.RecordSize())
+	record = append(record, header...)
+	record = append(record, rec.data...)
+	if err := s.Write(record); err != nil {
+		return nil, err
 	}
 	s.size += h.RecordSize()
 
 	return &RecordRef{
-		offset: offset,
-		length: h.DataLen,
+		offset:  offset,
+		length:  h.DataLen,
+		seq:     h.Seq,
+		expires: rec.expires,
 	}, nil
 }
 
 /**
-* ReadHeader
-* @param ref *RecordRef
+* readHeaderAt: Reads the record header at offset according to the segment version
+* @param offset int64
 * @return recordHeader, error
 **/
-func (s *segment) ReadHeader(ref *RecordRef) (recordHeader, error) {
-	var header recordHeader
-	buf := make([]byte, fixedHeaderSize)
-	_, err := s.ReadAt(buf, ref.offset)
+func (s *segment) readHeaderAt(offset int64) (recordHeader, error) {
+	fixed := make([]byte, 10)
+	if _, err := s.ReadAt(fixed, offset); err != nil {
+		return recordHeader{version: s.version}, err
+	}
+
+	header, err := s.parseFixed(fixed)
 	if err != nil {
 		return header, err
 	}
 
-	reader := bytes.NewReader(buf)
-	binary.Read(reader, binary.BigEndian, &header.DataLen)
-	binary.Read(reader, binary.BigEndian, &header.CRC)
-	binary.Read(reader, binary.BigEndian, &header.IDLen)
-
-	idBytes := make([]byte, header.IDLen)
-	if _, err := s.ReadAt(idBytes, ref.offset+10); err != nil {
+	rest := make([]byte, header.HeaderSize()-10)
+	if _, err := s.ReadAt(rest, offset+10); err != nil {
 		return header, err
 	}
-	header.ID = string(idBytes)
+	s.parseRest(&header, rest)
 
-	statusLen := int64(1)
-	statusByte := make([]byte, statusLen)
-	if _, err := s.ReadAt(statusByte, ref.offset+10+int64(header.IDLen)); err != nil {
-		return header, err
+	return header, nil
+}
+
+/**
+* parseFixed: Parses the lengths and the CRC of the header
+* @param fixed []byte
+* @return recordHeader, error
+**/
+func (s *segment) parseFixed(fixed []byte) (recordHeader, error) {
+	header := recordHeader{version: s.version}
+	header.DataLen = getUint32(fixed[0:4])
+	header.CRC = getUint32(fixed[4:8])
+	header.IDLen = getUint16(fixed[8:10])
+	if header.IDLen == 0 {
+		return header, errors.New(msg.MSG_CORRUPTED_RECORD)
 	}
-	header.Status = statusByte[0]
 
 	return header, nil
 }
 
 /**
-* Read
-* @param ref *RecordRef
-* @return []byte, error
+* parseRest: Parses the ID, the status and the fields of the version of the segment
+* @param header *recordHeader, rest []byte
+**/
+func (s *segment) parseRest(header *recordHeader, rest []byte) {
+	// ID, status, flags (desde la versión 2), secuencia y timestamp (desde la versión 3)
+	header.ID = string(rest[:header.IDLen])
+	header.Status = rest[header.IDLen]
+	if s.version >= segmentV2 {
+		header.Flags = rest[header.IDLen+1]
+	}
+	if s.version >= segmentV3 {
+		header.Seq = getUint64(rest[header.IDLen+2 : header.IDLen+10])
+		header.Timestamp = int64(getUint64(rest[header.IDLen+10 : header.IDLen+18]))
+	}
+}
+
+/**
+* readRecordAt: Reads the record at offset and validates the checksum, the data is returned as stored
+* @param offset, limit int64
+* @return recordHeader, []byte, error
 **/
-func (s *segment) read(ref *RecordRef) ([]byte, error) {
-	header, err := s.ReadHeader(ref)
+func (s *segment) readRecordAt(offset, limit int64) (recordHeader, []byte, error) {
+	header, err := s.readHeaderAt(offset)
 	if err != nil {
-		return nil, err
+		return header, nil, err
+	}
+
+	// Un encabezado roto puede declarar hasta 4 GiB, no se reserva lo que no cabe antes de limit
+	if offset+header.RecordSize() > limit {
+		return header, nil, io.ErrUnexpectedEOF
 	}
 
-	headerLen := fixedHeaderSize + int64(header.IDLen)
 	data := make([]byte, header.DataLen)
-	_, err = s.ReadAt(data, ref.offset+headerLen)
-	if err != nil {
-		return nil, err
+	if header.DataLen > 0 {
+		if _, err := s.ReadAt(data, offset+header.HeaderSize()); err != nil {
+			return header, nil, err
+		}
 	}
 
 	if checksum(data) != header.CRC {
-		return nil, errors.New(msg.MSG_CORRUPTED_RECORD)
+		return header, nil, errors.New(msg.MSG_CORRUPTED_RECORD)
 	}
 
-	return data, nil
+	return header, data, nil
 }
 
 /**
-* Read
-* @param ref *RecordRef, dest any
-* @return error
+* readRecord: Reads the record of the key with one read, the index knows the length of the header and the data
+* @param id string, ref *RecordRef
+* @return recordHeader, []byte, error
 **/
-func (s *segment) Read(ref *RecordRef, dest any) error {
-	data, err := s.read(ref)
+func (s *segment) readRecord(id string, ref *RecordRef) (recordHeader, []byte, error) {
+	size := recordHeaderSize(s.version, len(id)) + int64(ref.length)
+	buf := make([]byte, size)
+	if _, err := s.ReadAt(buf, ref.offset); err != nil {
+		return recordHeader{version: s.version}, nil, err
+	}
+
+	header, err := s.parseFixed(buf[0:10])
 	if err != nil {
-		return err
+		return header, nil, err
+	}
+	if header.DataLen != ref.length || int(header.IDLen) != len(id) {
+		return header, nil, errors.New(msg.MSG_CORRUPTED_RECORD)
+	}
+
+	headerSize := header.HeaderSize()
+	s.parseRest(&header, buf[10:headerSize])
+	data := buf[headerSize:]
+	if header.ID != id || checksum(data) != header.CRC {
+		return header, nil, errors.New(msg.MSG_CORRUPTED_RECORD)
 	}
 
-	return json.Unmarshal(data, dest)
+	return header, data, nil
+}
+
+/**
+* ReadHeader
+* @param ref *RecordRef
+* @return recordHeader, error
+**/
+func (s *segment) ReadHeader(ref *RecordRef) (recordHeader, error) {
+	return s.readHeaderAt(ref.offset)
 }
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/**
+* copyFixture: Copies the store of testdata/dir to a temporary directory, opening it upgrades the files
+* @param t *testing.T, dir string
+* @return string
+**/
+func copyFixture(t *testing.T, dir string) string {
+	t.Helper()
+	result := t.TempDir()
+	src := filepath.Join("testdata", dir)
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(result, strings.TrimPrefix(path, src))
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+/**
+* expectFixture: Checks the records that the old versions wrote in the fixtures
+* @param t *testing.T, fs *FileStore, compressed bool
+**/
+func expectFixture(t *testing.T, fs *FileStore, compressed bool) {
+	t.Helper()
+	var object map[string]any
+	for id, n := range map[string]float64{"a": 1, "b": 3} {
+		exists, err := fs.Get(id, &object)
+		if err != nil || !exists || object["n"] != n {
+			t.Fatalf("%s: %v %v %v", id, object, exists, err)
+		}
+	}
+	if fs.IsExist("c") {
+		t.Fatal("deleted record is back")
+	}
+
+	expected := map[string]string{"long": strings.Repeat("josefina ", 200)}
+	if compressed {
+		expected["snappy"] = strings.Repeat("snappy ", 200)
+		expected["zstd"] = strings.Repeat("zstd ", 200)
+	}
+	for id, value := range expected {
+		var got string
+		exists, err := fs.Get(id, &got)
+		if err != nil || !exists || got != value {
+			t.Fatalf("%s: %v %v", id, exists, err)
+		}
+	}
+}
+
+func TestSegmentFormats(t *testing.T) {
+	cases := []struct {
+		dir        string
+		version    uint16
+		compressed bool
+	}{
+		{"v1", segmentV1, false},
+		{"v2", segmentV2, true},
+		{"v3", segmentV3, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.dir, func(t *testing.T) {
+			dir := copyFixture(t, c.dir)
+			fs, err := Open(dir, "fixture", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fs.segments[0].version != c.version {
+				t.Fatalf("segment read as version %d", fs.segments[0].version)
+			}
+			expectFixture(t, fs, c.compressed)
+
+			// Las escrituras nuevas van a un segmento del formato actual
+			if err := fs.Put("new", "value"); err != nil {
+				t.Fatal(err)
+			}
+			if fs.active.version != segmentVersion {
+				t.Fatalf("active segment in version %d", fs.active.version)
+			}
+			if err := fs.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			fs, err = Open(dir, "fixture", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fs.Close()
+			expectFixture(t, fs, c.compressed)
+			if !fs.IsExist("new") {
+				t.Fatal("record of the current format lost")
+			}
+		})
+	}
+}
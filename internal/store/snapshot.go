@@ -5,92 +5,162 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"os"
 	"path/filepath"
+	"sync/atomic"
+	"time"
 
 	"github.com/cgalvisleon/et/logs"
 	"github.com/cgalvisleon/josefina/pkg/msg"
 )
 
+const (
+	snapshotV2      uint16 = 2 // vencimiento por entrada, sin posición del log
+	snapshotV3      uint16 = 3 // posición del log y secuencia por entrada
+	snapshotVersion        = snapshotV3
+)
+
+/**
+* snapshotEntry: Copy of an entry of the index, the refs are not shared with the index
+**/
+type snapshotEntry struct {
+	key string
+	ref RecordRef
+}
+
+/**
+* snapshotState: Entries of the index and the position of the log they cover
+**/
+type snapshotState struct {
+	segment int    // segmento de la posición
+	offset  int64  // el snapshot cubre el log hasta aquí
+	seq     uint64 // última secuencia cubierta
+	entries []snapshotEntry
+}
+
 /**
-* CreateSnapshot
+* CreateSnapshot: Writes the index with the position of the log it covers
 * @return error
 **/
 func (s *FileStore) CreateSnapshot() error {
-	s.indexMu.RLock()
-	defer s.indexMu.RUnlock()
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
 
-	name := fmt.Sprintf("state-%s.snap", s.Name)
-	path := filepath.Join(s.PathSnapshot, name)
-	tmp := path + ".tmp"
+	return s.createSnapshot()
+}
 
-	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+/**
+* createSnapshot: The caller holds writeMu, the log does not move while the index is copied
+* @return error
+**/
+func (s *FileStore) createSnapshot() error {
+	state := s.captureSnapshot()
+	data, err := s.sealSnapshot(encodeSnapshot(state))
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	buf := bytes.NewBuffer(nil)
+	path := s.snapshotPath()
+	tmp := path + ".tmp"
+	if err := writeFile(s.fs, tmp, data); err != nil {
+		return err
+	}
+
+	// atomic swap
+	if err := s.fs.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	s.lastSnapshot = time.Now()
+	return nil
+}
+
+/**
+* snapshotPath
+* @return string
+**/
+func (s *FileStore) snapshotPath() string {
+	name := fmt.Sprintf("state-%s.snap", s.Name)
+	return filepath.Join(s.PathSnapshot, name)
+}
+
+/**
+* captureSnapshot: Copies the index and the end of the log, indexMu is held only during the copy
+* @return *snapshotState
+**/
+func (s *FileStore) captureSnapshot() *snapshotState {
+	s.indexMu.RLock()
+	defer s.indexMu.RUnlock()
+
+	result := &snapshotState{
+		segment: len(s.segments) - 1,
+		offset:  s.active.size,
+		seq:     s.seq.Load(),
+		entries: make([]snapshotEntry, 0, s.index.Len()),
+	}
+	s.index.Ascend(0, func(id string, ref *RecordRef) bool {
+		// version guarda la secuencia con solo el bloqueo de lectura
+		result.entries = append(result.entries, snapshotEntry{key: id, ref: RecordRef{
+			segment: ref.segment,
+			offset:  ref.offset,
+			length:  ref.length,
+			seq:     atomic.LoadUint64(&ref.seq),
+			expires: ref.expires,
+		}})
+		return true
+	})
+
+	return result
+}
 
+/**
+* encodeSnapshot: Encodes the position of the log and the entries in key order
+* @param state *snapshotState
+* @return []byte
+**/
+func encodeSnapshot(state *snapshotState) []byte {
 	// ---- Header ----
+	buf := bytes.NewBuffer(nil)
 	buf.WriteString("SNAP")
-	binary.Write(buf, binary.BigEndian, uint16(1))
-	binary.Write(buf, binary.BigEndian, uint64(len(s.index)))
+	binary.Write(buf, binary.BigEndian, snapshotVersion)
+	binary.Write(buf, binary.BigEndian, uint32(state.segment))
+	binary.Write(buf, binary.BigEndian, state.offset)
+	binary.Write(buf, binary.BigEndian, state.seq)
+	binary.Write(buf, binary.BigEndian, uint64(len(state.entries)))
 
-	// ---- Entries ----
-	currentSegment := len(s.segments) - 1
-	for id, ref := range s.index {
-		if ref.segment == currentSegment {
-			continue
-		}
-		idBytes := []byte(id)
+	// ---- Entries (en orden de clave) ----
+	for _, entry := range state.entries {
+		idBytes := []byte(entry.key)
 		binary.Write(buf, binary.BigEndian, uint16(len(idBytes)))
 		buf.Write(idBytes)
-		binary.Write(buf, binary.BigEndian, uint32(ref.segment))
-		binary.Write(buf, binary.BigEndian, ref.offset)
-		binary.Write(buf, binary.BigEndian, ref.length)
-		if s.isDebug {
-			logs.Debug("snapshot:", s.Path, ":", s.Name, ":ID:", id, "seg:", ref.segment, ":offset:", ref.offset, ":len:", ref.length)
-		}
+		binary.Write(buf, binary.BigEndian, uint32(entry.ref.segment))
+		binary.Write(buf, binary.BigEndian, entry.ref.offset)
+		binary.Write(buf, binary.BigEndian, entry.ref.length)
+		binary.Write(buf, binary.BigEndian, entry.ref.expires)
+		binary.Write(buf, binary.BigEndian, entry.ref.seq)
 	}
 
 	// ---- CRC ----
 	crc := checksum(buf.Bytes())
 	binary.Write(buf, binary.BigEndian, crc)
 
-	if _, err := f.Write(buf.Bytes()); err != nil {
-		return err
-	}
-
-	if err := f.Sync(); err != nil {
-		return err
-	}
-
-	// atomic swap
-	return os.Rename(tmp, path)
+	return buf.Bytes()
 }
 
 /**
-* tryLoadSnapshot
-* @return error
+* decodeSnapshot: The snapshots before the version 3 cover all the segments but the last one
+* @param data []byte, segments int
+* @return *snapshotState, error
 **/
-func (s *FileStore) tryLoadSnapshot() error {
-	name := fmt.Sprintf("state-%s.snap", s.Name)
-	path := filepath.Join(s.PathSnapshot, name)
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil // snapshot opcional
-	}
-
+func decodeSnapshot(data []byte, segments int) (*snapshotState, error) {
 	if len(data) < 10 {
-		return errors.New(msg.MSG_INVALID_SNAPSHOT)
+		return nil, errors.New(msg.MSG_INVALID_SNAPSHOT)
 	}
 
 	// CRC check
 	payload := data[:len(data)-4]
 	storedCRC := getUint32(data[len(data)-4:])
 	if checksum(payload) != storedCRC {
-		return errors.New(msg.MSG_SNAPSHOT_CORRUPTED)
+		return nil, errors.New(msg.MSG_SNAPSHOT_CORRUPTED)
 	}
 
 	buf := bytes.NewReader(payload)
@@ -99,35 +169,149 @@ func (s *FileStore) tryLoadSnapshot() error {
 	magic := make([]byte, 4)
 	buf.Read(magic)
 	if string(magic) != "SNAP" {
-		return errors.New(msg.MSG_INVALID_SNAPSHOT_MAGIC)
+		return nil, errors.New(msg.MSG_INVALID_SNAPSHOT_MAGIC)
 	}
 
 	var version uint16
 	binary.Read(buf, binary.BigEndian, &version)
+	if version > snapshotVersion {
+		return nil, errors.New(msg.MSG_INVALID_SNAPSHOT)
+	}
+
+	result := &snapshotState{
+		segment: segments - 1,
+		offset:  -1, // inicio del segmento
+	}
+	if version >= snapshotV3 {
+		var segIndex uint32
+		binary.Read(buf, binary.BigEndian, &segIndex)
+		binary.Read(buf, binary.BigEndian, &result.offset)
+		binary.Read(buf, binary.BigEndian, &result.seq)
+		result.segment = int(segIndex)
+	}
 
 	var count uint64
-	binary.Read(buf, binary.BigEndian, &count)
+	if err := binary.Read(buf, binary.BigEndian, &count); err != nil {
+		return nil, errors.New(msg.MSG_INVALID_SNAPSHOT)
+	}
 
 	// ---- Entries ----
-	s.index = make(map[string]*RecordRef, count)
+	result.entries = make([]snapshotEntry, 0, min(count, uint64(buf.Len())))
 	for i := uint64(0); i < count; i++ {
 		var idLen uint16
-		binary.Read(buf, binary.BigEndian, &idLen)
+		if err := binary.Read(buf, binary.BigEndian, &idLen); err != nil {
+			return nil, errors.New(msg.MSG_INVALID_SNAPSHOT)
+		}
 
 		idBytes := make([]byte, idLen)
 		buf.Read(idBytes)
 
-		var segIndex uint32
-		var offset int64
-		var dataLen uint32
-
+		var (
+			segIndex uint32
+			ref      RecordRef
+		)
 		binary.Read(buf, binary.BigEndian, &segIndex)
-		binary.Read(buf, binary.BigEndian, &offset)
-		binary.Read(buf, binary.BigEndian, &dataLen)
+		binary.Read(buf, binary.BigEndian, &ref.offset)
+		binary.Read(buf, binary.BigEndian, &ref.length)
+		ref.segment = int(segIndex)
 
-		id := string(idBytes)
-		s.setIndex(id, int(segIndex), offset, dataLen)
+		// Desde la versión 2 cada entrada lleva el vencimiento
+		if version >= snapshotV2 {
+			binary.Read(buf, binary.BigEndian, &ref.expires)
+		}
+		// Desde la versión 3 la secuencia
+		var err error
+		if version >= snapshotV3 {
+			err = binary.Read(buf, binary.BigEndian, &ref.seq)
+		}
+		if err != nil {
+			return nil, errors.New(msg.MSG_INVALID_SNAPSHOT)
+		}
+
+		result.entries = append(result.entries, snapshotEntry{key: string(idBytes), ref: ref})
 	}
 
-	return nil
+	return result, nil
+}
+
+/**
+* tryLoadSnapshot: Loads the index of the snapshot, returns the state when its position is valid for the segments
+* @return *snapshotState, error
+**/
+func (s *FileStore) tryLoadSnapshot() (*snapshotState, error) {
+	data, err := readFile(s.fs, s.snapshotPath())
+	if err != nil {
+		return nil, nil // snapshot opcional
+	}
+
+	data, err = s.unsealSnapshot(data)
+	if err != nil && err.Error() == msg.MSG_SNAPSHOT_NOT_SEALED {
+		// Escrito antes de las llaves o reemplazado, el índice se reconstruye desde el log
+		logs.Alertf("snapshot:%s:%s:%s, replaying all segments", s.Path, s.Name, err.Error())
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := decodeSnapshot(data, len(s.segments))
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.validSnapshot(state) {
+		// Un log más corto que el snapshot o reemplazado, se reproduce completo
+		logs.Alertf("snapshot:%s:%s:position out of the log or inside a record, replaying all segments", s.Path, s.Name)
+		return nil, nil
+	}
+
+	s.resetIndex()
+	for _, entry := range state.entries {
+		ref := entry.ref
+		s.setIndex(entry.key, ref.segment, ref.offset, ref.length, ref.seq, ref.expires)
+	}
+	if state.seq > s.seq.Load() {
+		s.seq.Store(state.seq)
+	}
+
+	return state, nil
+}
+
+/**
+* validSnapshot: The position must be the end of the segment or the start of a record and the entries must be before it
+* @param state *snapshotState
+* @return bool
+**/
+func (s *FileStore) validSnapshot(state *snapshotState) bool {
+	if state.segment < 0 || state.segment >= len(s.segments) {
+		return false
+	}
+
+	seg := s.segments[state.segment]
+	if state.offset < 0 {
+		state.offset = seg.start()
+	}
+	if state.offset < seg.start() || state.offset > seg.size {
+		return false
+	}
+
+	// Un log reescrito puede dejar la posición en medio de un registro
+	if state.offset < seg.size {
+		h, _, err := seg.readRecordAt(state.offset, seg.size)
+		if err != nil || !validRecord(h, state.offset, seg.size) {
+			return false
+		}
+	}
+
+	for _, entry := range state.entries {
+		ref := entry.ref
+		if ref.segment < 0 || ref.segment > state.segment || ref.offset < 0 {
+			return false
+		}
+		if ref.segment == state.segment && ref.offset >= state.offset {
+			return false
+		}
+	}
+
+	return true
 }
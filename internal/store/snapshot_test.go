@@ -0,0 +1,167 @@
+package store
+
+import (
+	"os"
+	"testing"
+)
+
+/**
+* expectStrings: Checks the string values of the keys, an empty value is a missing key
+* @param t *testing.T, fs *FileStore, expected map[string]string
+**/
+func expectStrings(t *testing.T, fs *FileStore, expected map[string]string) {
+	t.Helper()
+	for id, value := range expected {
+		var got string
+		exists, err := fs.Get(id, &got)
+		if err != nil || exists != (value != "") || got != value {
+			t.Fatalf("%s: %q %v %v, expected %q", id, got, exists, err, value)
+		}
+	}
+}
+
+/**
+* loadSnapshotState: Decodes the snapshot of the store
+* @param t *testing.T, fs *FileStore
+* @return *snapshotState
+**/
+func loadSnapshotState(t *testing.T, fs *FileStore) *snapshotState {
+	t.Helper()
+	data, err := os.ReadFile(fs.snapshotPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := decodeSnapshot(data, len(fs.segments))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+func TestSnapshotReplaysAfterPosition(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := Open(dir, "snapshot", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs.Put("a", "a1")
+	fs.Put("b", "b1")
+	if err := fs.CreateSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := os.ReadFile(fs.snapshotPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := *indexRef(t, fs, "a")
+
+	fs.Put("a", "a2")
+	fs.Delete("b")
+	fs.Put("c", "c1")
+	path, name := fs.snapshotPath(), segmentFile(t, fs)
+	fs.Close()
+
+	// Con el snapshot anterior solo se reproduce el log después de su posición
+	if err := os.WriteFile(path, snapshot, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Un registro cubierto por el snapshot y ya reemplazado no se vuelve a leer
+	rewrite(t, name, func(data []byte) []byte {
+		data[old.offset+recordHeaderSize(segmentVersion, 1)] ^= 0xff
+		return data
+	})
+
+	fs, err = Open(dir, "snapshot", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	expectStrings(t, fs, map[string]string{"a": "a2", "b": "", "c": "c1"})
+	if fs.Count() != 2 {
+		t.Fatalf("%d records", fs.Count())
+	}
+	if _, err := os.Stat(fs.PathQuarantine); !os.IsNotExist(err) {
+		t.Fatal("log before the position replayed")
+	}
+}
+
+func TestSnapshotPastTornTail(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := Open(dir, "snapshot", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs.Put("a", "a1")
+	end := fs.Size
+	fs.Put("b", "b1")
+	name := segmentFile(t, fs)
+	fs.Close()
+
+	// El snapshot es durable y la cola del log no
+	rewrite(t, name, func(data []byte) []byte {
+		return data[:len(data)-3]
+	})
+
+	fs, err = Open(dir, "snapshot", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	expectStrings(t, fs, map[string]string{"a": "a1", "b": ""})
+	if info, err := os.Stat(name); err != nil || info.Size() != end {
+		t.Fatalf("torn tail not truncated: %v %v", info.Size(), err)
+	}
+
+	fs.Put("b", "b2")
+	expectStrings(t, fs, map[string]string{"a": "a1", "b": "b2"})
+}
+
+func TestSnapshotPositionInsideRecord(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := Open(dir, "snapshot", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs.Put("a", "a1")
+	fs.Put("b", "b1")
+	b := *indexRef(t, fs, "b")
+	name := segmentFile(t, fs)
+	fs.Close()
+
+	// Una posición en medio del último registro reproduciría una cola rota y la truncaría
+	state := loadSnapshotState(t, fs)
+	entries := state.entries[:0]
+	for _, entry := range state.entries {
+		if entry.key != "b" {
+			entries = append(entries, entry)
+		}
+	}
+	state.entries = entries
+	state.offset = b.offset + 3
+	if err := os.WriteFile(fs.snapshotPath(), encodeSnapshot(state), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err = Open(dir, "snapshot", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	expectStrings(t, fs, map[string]string{"a": "a1", "b": "b1"})
+	if after, err := os.Stat(name); err != nil || after.Size() != info.Size() {
+		t.Fatal("segment truncated by the snapshot position")
+	}
+}
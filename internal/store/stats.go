@@ -0,0 +1,181 @@
+package store
+
+import (
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/cgalvisleon/et/et"
+)
+
+const (
+	indexItemSize  = int64(unsafe.Sizeof(indexItem{}) + unsafe.Sizeof(RecordRef{}))
+	indexNodeBytes = int64(unsafe.Sizeof(bnode{})) / maxLeafItems // parte del nodo por entrada
+)
+
+/**
+* Stats: Introspection of the store, the sizes are in bytes
+**/
+type Stats struct {
+	Name           string          `json:"name"`
+	Path           string          `json:"path"`
+	Records        int             `json:"records"`
+	Expiring       int             `json:"expiring"`
+	Seq            uint64          `json:"seq"`
+	WAL            uint64          `json:"wal"`
+	TombStones     int             `json:"tomb_stones"`
+	Size           int64           `json:"size"`
+	Live           int64           `json:"live"`
+	Dead           int64           `json:"dead"`
+	Garbage        float64         `json:"garbage"`
+	ValueBytes     int64           `json:"value_bytes"`
+	AvgValueSize   float64         `json:"avg_value_size"`
+	IndexMemory    int64           `json:"index_memory"` // estimación de la memoria del índice
+	Segments       []SegmentStats  `json:"segments"`
+	Blobs          int             `json:"blobs"`
+	OpenFiles      int             `json:"open_files"`
+	ReadOnly       bool            `json:"read_only"`
+	Memory         bool            `json:"memory"`
+	Encrypted      bool            `json:"encrypted"`
+	Codec          string          `json:"codec"`
+	Compression    string          `json:"compression"`
+	LastCompaction time.Time       `json:"last_compaction"`
+	LastSnapshot   time.Time       `json:"last_snapshot"`
+	Compaction     CompactionStats `json:"compaction"`
+	Cache          CacheStats      `json:"cache"`
+}
+
+/**
+* ToJson
+* @return et.Json
+**/
+func (s *Stats) ToJson() et.Json {
+	segments := make([]et.Json, 0, len(s.Segments))
+	for _, seg := range s.Segments {
+		segments = append(segments, et.Json{
+			"name":      seg.Name,
+			"size":      seg.Size,
+			"live":      seg.Live,
+			"dead":      seg.Dead,
+			"garbage":   seg.Garbage,
+			"active":    seg.Active,
+			"compacted": seg.Compacted,
+		})
+	}
+
+	return et.Json{
+		"name":            s.Name,
+		"path":            s.Path,
+		"records":         s.Records,
+		"expiring":        s.Expiring,
+		"seq":             s.Seq,
+		"wal":             s.WAL,
+		"tomb_stones":     s.TombStones,
+		"size":            s.Size,
+		"live":            s.Live,
+		"dead":            s.Dead,
+		"garbage":         s.Garbage,
+		"value_bytes":     s.ValueBytes,
+		"avg_value_size":  s.AvgValueSize,
+		"index_memory":    s.IndexMemory,
+		"segments":        segments,
+		"blobs":           s.Blobs,
+		"open_files":      s.OpenFiles,
+		"read_only":       s.ReadOnly,
+		"memory":          s.Memory,
+		"encrypted":       s.Encrypted,
+		"codec":           s.Codec,
+		"compression":     s.Compression,
+		"last_compaction": s.LastCompaction,
+		"last_snapshot":   s.LastSnapshot,
+		"compaction": et.Json{
+			"runs":       s.Compaction.Runs,
+			"segments":   s.Compaction.Segments,
+			"bytes_read": s.Compaction.BytesRead,
+			"written":    s.Compaction.Written,
+			"reclaimed":  s.Compaction.Reclaimed,
+			"canceled":   s.Compaction.Canceled,
+			"last_run":   s.Compaction.LastRun,
+			"last_error": s.Compaction.LastError,
+		},
+		"cache": s.Cache.ToJson(),
+	}
+}
+
+/**
+* Stats: Returns the statistics of the store, the index is walked to measure the values and the keys
+* @return *Stats, error
+**/
+func (s *FileStore) Stats() (*Stats, error) {
+	segments := s.SegmentStats()
+
+	result := &Stats{
+		Name:        s.Name,
+		Path:        s.Path,
+		Segments:    segments,
+		ReadOnly:    s.mode != modeWrite,
+		Memory:      s.Memory,
+		Encrypted:   s.currentKeyring() != nil,
+		Codec:       s.currentCodec().Name(),
+		Compression: s.Compression,
+		Compaction:  s.compactor.Stats(),
+		Cache:       s.cache.stats(),
+	}
+
+	s.writeMu.Lock()
+	result.Size = s.Size
+	result.TombStones = int(s.tombStones.Load())
+	result.WAL = s.WAL
+	result.LastCompaction = s.lastCompaction
+	result.LastSnapshot = s.lastSnapshot
+	result.OpenFiles = 0
+	for _, seg := range s.segments {
+		if seg.file != nil {
+			result.OpenFiles++
+		}
+	}
+	if s.lock != nil {
+		result.OpenFiles++
+	}
+	s.writeMu.Unlock()
+
+	result.Seq = s.seq.Load()
+	for _, seg := range segments {
+		result.Live += seg.Live
+		result.Dead += seg.Dead
+	}
+	if total := result.Live + result.Dead; total > 0 {
+		result.Garbage = float64(result.Dead) / float64(total)
+	}
+
+	s.indexMu.RLock()
+	keyBytes := int64(0)
+	s.index.Ascend(0, func(key string, ref *RecordRef) bool {
+		keyBytes += int64(len(key))
+		result.ValueBytes += int64(ref.length)
+		return true
+	})
+	result.Records = s.index.Len()
+	if s.index.expiries != nil {
+		result.Expiring = s.index.expiries.Len()
+	}
+	s.indexMu.RUnlock()
+
+	if result.Records > 0 {
+		result.AvgValueSize = float64(result.ValueBytes) / float64(result.Records)
+	}
+	entries := int64(result.Records + result.Expiring)
+	result.IndexMemory = entries*(indexItemSize+indexNodeBytes) + keyBytes
+
+	blobs, err := s.blobFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range blobs {
+		if strings.HasSuffix(name, blobExt) {
+			result.Blobs++
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,173 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+/**
+* recordBytes: Returns the bytes of the live record of the key in its segment
+* @param t *testing.T, fs *FileStore, id string
+* @return int64
+**/
+func recordBytes(t *testing.T, fs *FileStore, id string) int64 {
+	t.Helper()
+	ref := indexRef(t, fs, id)
+	return recordHeaderSize(fs.segments[ref.segment].version, len(id)) + int64(ref.length)
+}
+
+/**
+* expectStats: Checks the live and dead bytes of the store and that each segment has the live bytes of the index
+* @param t *testing.T, fs *FileStore, live, dead int64
+* @return *Stats
+**/
+func expectStats(t *testing.T, fs *FileStore, live, dead int64) *Stats {
+	t.Helper()
+	result, err := fs.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Live != live || result.Dead != dead {
+		t.Fatalf("live %d dead %d, expected %d %d", result.Live, result.Dead, live, dead)
+	}
+
+	segments := make([]int64, len(fs.segments))
+	fs.index.Ascend(0, func(key string, ref *RecordRef) bool {
+		segments[ref.segment] += recordHeaderSize(fs.segments[ref.segment].version, len(key)) + int64(ref.length)
+		return true
+	})
+	size := int64(0)
+	for i, seg := range result.Segments {
+		if seg.Live != segments[i] {
+			t.Fatalf("segment %s with %d live bytes, the index has %d", seg.Name, seg.Live, segments[i])
+		}
+		size += seg.Size
+	}
+	if size != result.Size {
+		t.Fatalf("size %d with %d in the segments", result.Size, size)
+	}
+
+	return result
+}
+
+func TestStatsOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := Open(dir, "stats", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Compactor().stop()
+
+	fs.Put("a", "a1")
+	fs.Put("b", "b1")
+	fs.Put("c", "c1")
+	live := recordBytes(t, fs, "a") + recordBytes(t, fs, "b") + recordBytes(t, fs, "c")
+	stats := expectStats(t, fs, live, 0)
+	if stats.Size != fs.active.start()+live || stats.Garbage != 0 {
+		t.Fatalf("size %d garbage %v", stats.Size, stats.Garbage)
+	}
+
+	// El registro reemplazado pasa a los bytes muertos
+	old := recordBytes(t, fs, "a")
+	fs.Put("a", "a22")
+	live += recordBytes(t, fs, "a") - old
+	dead := old
+	expectStats(t, fs, live, dead)
+
+	// El borrado deja muerto el registro y su propia marca
+	size := fs.Size
+	removed := recordBytes(t, fs, "b")
+	fs.Delete("b")
+	live -= removed
+	dead += removed + fs.Size - size
+	stats = expectStats(t, fs, live, dead)
+	if stats.Garbage != float64(dead)/float64(live+dead) {
+		t.Fatalf("garbage %v", stats.Garbage)
+	}
+
+	// La apertura reconstruye los mismos bytes desde el snapshot y desde el log
+	fs.Close()
+	fs, err = Open(dir, "stats", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStats(t, fs, live, dead)
+	fs.Close()
+
+	if err := os.Remove(fs.snapshotPath()); err != nil {
+		t.Fatal(err)
+	}
+	fs, err = Open(dir, "stats", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	fs.Compactor().stop()
+	expectStats(t, fs, live, dead)
+
+	// La compactación completa deja solo bytes vivos
+	if err := fs.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	live = recordBytes(t, fs, "a") + recordBytes(t, fs, "c")
+	stats = expectStats(t, fs, live, 0)
+	if stats.Size != fs.active.start()+live || stats.Garbage != 0 {
+		t.Fatalf("compacted size %d garbage %v", stats.Size, stats.Garbage)
+	}
+}
+
+func TestStatsSegmentCompaction(t *testing.T) {
+	fs, err := Open(t.TempDir(), "stats", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	fs.Compactor().stop()
+	fs.MaxSegment = 300
+
+	keys := []string{}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("k%02d", i)
+		keys = append(keys, key)
+		fs.Put(key, strings.Repeat("v", 50))
+	}
+	closed := len(fs.segments) - 1
+	if closed < 2 {
+		t.Fatalf("%d segments", len(fs.segments))
+	}
+
+	// Cada segmento cerrado queda con un registro reemplazado
+	victims := map[int]bool{}
+	dead := int64(0)
+	for _, key := range keys {
+		if ref := indexRef(t, fs, key); ref.segment < closed && !victims[ref.segment] {
+			victims[ref.segment] = true
+			dead += recordBytes(t, fs, key)
+			fs.Put(key, "v")
+		}
+	}
+	live := int64(0)
+	for _, key := range keys {
+		live += recordBytes(t, fs, key)
+	}
+	expectStats(t, fs, live, dead)
+
+	fs.Compactor().SetRatio(0.01)
+	if err := fs.Compactor().Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Los segmentos reescritos no tienen bytes muertos y los vivos no cambian
+	stats := expectStats(t, fs, live, 0)
+	for i, seg := range stats.Segments {
+		if victims[i] && (!seg.Compacted || seg.Dead != 0 || seg.Size != fs.segments[i].start()+seg.Live) {
+			t.Fatalf("compacted segment %+v", seg)
+		}
+	}
+	if compaction := stats.Compaction; compaction.Segments != int64(len(victims)) || compaction.Reclaimed != dead {
+		t.Fatalf("compaction %+v, expected %d segments and %d bytes", compaction, len(victims), dead)
+	}
+}
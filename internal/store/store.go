@@ -7,12 +7,11 @@ import (
 	"fmt"
 	"io"
 	"math"
-	"os"
 	"path/filepath"
-	"slices"
 	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/cgalvisleon/et/envar"
 	"github.com/cgalvisleon/et/et"
@@ -22,17 +21,30 @@ import (
 )
 
 const (
-	packageName     = "store"
-	maxIdLen        = 65535
-	fixedHeaderSize = 11
+	packageName       = "store"
+	maxIdLen          = 65535
+	fixedHeaderSize   = 11       // DataLen, CRC, IDLen y Status
+	segmentMagic      = "JSEG"   // cabecera de los segmentos versionados
+	segmentHeaderSize = 8        // magic, versión y reservado
+	lockName          = "LOCK"   // archivo del lock del directorio
+	memoryPath        = "memory" // raíz de los stores en memoria
+	segmentCompacted  = 0x01     // flag de la cabecera del segmento, reescrito por la compactación
+)
+
+const (
+	segmentV1      uint16 = 1 // formato original, sin cabecera ni flags
+	segmentV2      uint16 = 2 // flags por registro
+	segmentV3      uint16 = 3 // secuencia y timestamp por registro
+	segmentVersion        = segmentV3
 )
 
 /**
-* newRecordHeaderAt
-* @param id string, data []byte, status byte
+* newRecordHeaderAt: Builds the header of a record in the current format
+* @param rec *logRecord
 * @return recordHeader, []byte, error
 **/
-func newRecordHeaderAt(id string, data []byte, status byte) (recordHeader, []byte, error) {
+func newRecordHeaderAt(rec *logRecord) (recordHeader, []byte, error) {
+	id, data, status, flags := rec.id, rec.data, rec.status, rec.flags
 	idBytes := []byte(id)
 	idLen := len(idBytes)
 
@@ -45,20 +57,26 @@ func newRecordHeaderAt(id string, data []byte, status byte) (recordHeader, []byt
 		return recordHeader{}, nil, errors.New(msg.MSG_DATA_TOO_LARGE)
 	}
 
-	headerLen := fixedHeaderSize + idLen
 	result := recordHeader{
-		DataLen: uint32(dataLen),
-		CRC:     checksum(data),
-		IDLen:   uint16(idLen),
-		Status:  status,
+		DataLen:   uint32(dataLen),
+		CRC:       checksum(data),
+		IDLen:     uint16(idLen),
+		Status:    status,
+		Flags:     flags,
+		Seq:       rec.seq,
+		Timestamp: rec.timestamp,
+		version:   segmentVersion,
 	}
 
-	header := make([]byte, headerLen)
+	header := make([]byte, result.HeaderSize())
 	putUint32(header[0:4], result.DataLen)
 	putUint32(header[4:8], result.CRC)
 	putUint16(header[8:10], result.IDLen)
 	copy(header[10:10+idLen], idBytes)
 	header[10+idLen] = status
+	header[11+idLen] = flags
+	putUint64(header[12+idLen:20+idLen], rec.seq)
+	putUint64(header[20+idLen:28+idLen], uint64(rec.timestamp))
 
 	return result, header, nil
 }
@@ -74,26 +92,58 @@ type Putfn func(string, []byte)
 type Deletefn func(string)
 
 type FileStore struct {
-	Name         string                `json:"name"`
-	Path         string                `json:"path"`
-	WAL          uint64                `json:"wal"` // Write-ahead log counter
-	TombStones   int                   `json:"tomb_stones"`
-	PathSegments string                `json:"path_segments"`
-	PathSnapshot string                `json:"path_snapshot"`
-	PathCompact  string                `json:"path_compact"`
-	MaxSegment   int64                 `json:"max_segment"`
-	SyncOnWrite  bool                  `json:"sync_on_write"`
-	Size         int64                 `json:"size"`
-	isDebug      bool                  `json:"-"`
-	writeMu      sync.Mutex            `json:"-"` // SOLO WAL append
-	indexMu      sync.RWMutex          `json:"-"` // índice en memoria
-	segments     []*segment            `json:"-"` // segmentos de datos
-	active       *segment              `json:"-"` // segmento activo para escritura
-	index        map[string]*RecordRef `json:"-"` // índice en memoria
-	keys         []string              `json:"-"` // claves en memoria
-	mode         mode                  `json:"-"` // modo de operación
-	onPut        []Putfn               `json:"-"` // función de escritura
-	onDelete     []Deletefn            `json:"-"` // función de eliminación
+	Name           string                  `json:"name"`
+	Path           string                  `json:"path"`
+	WAL            uint64                  `json:"wal"` // Write-ahead log counter
+	PathSegments   string                  `json:"path_segments"`
+	PathSnapshot   string                  `json:"path_snapshot"`
+	PathCompact    string                  `json:"path_compact"`
+	PathQuarantine string                  `json:"path_quarantine"`
+	PathBlobs      string                  `json:"path_blobs"`
+	MaxSegment     int64                   `json:"max_segment"`
+	BlobThreshold  int64                   `json:"blob_threshold"` // valores más grandes van a un blob, 0 desactiva
+	CacheSize      int64                   `json:"cache_size"`     // bytes de la caché de lectura, 0 desactiva
+	SyncOnWrite    bool                    `json:"sync_on_write"`
+	Compression    string                  `json:"compression"`
+	Encrypted      bool                    `json:"encrypted"` // los registros nuevos se cifran
+	Codec          string                  `json:"codec"`     // codec de los valores nuevos
+	MaxBatch       int                     `json:"max_batch"`
+	MaxQueue       int                     `json:"max_queue"` // eventos en cola por suscriptor, al superarlos se cierra
+	Recover        bool                    `json:"recover"`
+	Size           int64                   `json:"size"`
+	Memory         bool                    `json:"memory"` // los datos viven solo en memoria
+	isDebug        bool                    `json:"-"`
+	fs             FS                      `json:"-"` // sistema de archivos de los segmentos y snapshots
+	lock           io.Closer               `json:"-"` // lock del directorio, exclusivo en modo escritura
+	compression    byte                    `json:"-"` // compresión de los registros nuevos
+	codec          Codec                   `json:"-"` // codec de los valores nuevos
+	codecMu        sync.RWMutex            `json:"-"` // protege el codec
+	keyring        atomic.Pointer[Keyring] `json:"-"` // llaves del cifrado en reposo
+	rewrapping     atomic.Int32            `json:"-"` // compactaciones con blobs reescritos aún sin referenciar
+	writeMu        sync.Mutex              `json:"-"` // SOLO WAL append
+	blobMu         sync.RWMutex            `json:"-"` // los escritores lo toman en lectura, la recolección de blobs en escritura
+	closeMu        sync.RWMutex            `json:"-"` // protege el cierre del committer
+	closed         bool                    `json:"-"` // store cerrado
+	failed         atomic.Bool             `json:"-"` // una escritura no se pudo deshacer, el log en disco es incierto
+	compacting     atomic.Bool             `json:"-"` // compactación en curso
+	pins           atomic.Int32            `json:"-"` // lectores del log que impiden compactar
+	tombStones     atomic.Int64            `json:"-"` // registros reemplazados o borrados aún en los segmentos
+	compactor      *Compactor              `json:"-"` // compactación de segmentos en segundo plano
+	cache          *readCache              `json:"-"` // valores decodificados de las lecturas recientes
+	lastCompaction time.Time               `json:"-"` // última compactación, protegida por writeMu
+	lastSnapshot   time.Time               `json:"-"` // último snapshot, protegido por writeMu
+	seq            atomic.Uint64           `json:"-"` // última secuencia asignada
+	subMu          sync.Mutex              `json:"-"` // protege los suscriptores
+	subscribers    map[*Subscription]bool  `json:"-"` // suscriptores del stream de cambios
+	commits        chan *commitRequest     `json:"-"` // cola del group commit
+	commitWg       sync.WaitGroup          `json:"-"` // espera el loop del committer
+	indexMu        sync.RWMutex            `json:"-"` // índice en memoria
+	segments       []*segment              `json:"-"` // segmentos de datos
+	active         *segment                `json:"-"` // segmento activo para escritura
+	index          *index                  `json:"-"` // índice ordenado en memoria
+	mode           mode                    `json:"-"` // modo de operación
+	onPut          []Putfn                 `json:"-"` // función de escritura
+	onDelete       []Deletefn              `json:"-"` // función de eliminación
 }
 
 /**
@@ -124,6 +174,7 @@ func (s *FileStore) ToJson() et.Json {
 	if err != nil {
 		return et.Json{}
 	}
+	result["tomb_stones"] = s.tombStones.Load()
 
 	return result
 }
@@ -141,47 +192,60 @@ func (s *FileStore) ToString() string {
 * @return int
 **/
 func (s *FileStore) Count() int {
-	return len(s.index)
+	s.reapExpired()
+
+	s.indexMu.RLock()
+	defer s.indexMu.RUnlock()
+
+	return s.index.Len()
 }
 
 /**
-* loadSegments
+* Seq: Returns the last sequence assigned to a mutation
+* @return uint64
+**/
+func (s *FileStore) Seq() uint64 {
+	return s.seq.Load()
+}
+
+/**
+* loadSegments: Opens the segments of the directory, read only the files are not written and a store without segments is an error
 * @return error
 **/
 func (s *FileStore) loadSegments() error {
-	files, err := os.ReadDir(s.PathSegments)
+	files, err := s.fs.ReadDir(s.PathSegments)
 	if err != nil {
 		return err
 	}
 
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].Name() < files[j].Name()
-	})
+	sort.Strings(files)
 
-	for _, f := range files {
-		name := f.Name()
+	for _, name := range files {
 		path := filepath.Join(s.PathSegments, name)
-		st, _ := os.Stat(path)
-
-		fd, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
-		if err != nil {
-			return err
+		var seg *segment
+		if s.mode == modeRead {
+			seg, err = openSegmentReadOnly(s.fs, path, name)
+		} else {
+			seg, err = openSegment(s.fs, path, name, 0)
 		}
-
-		size := st.Size()
-		if _, err := fd.Seek(size, io.SeekStart); err != nil {
+		if err != nil {
 			return err
 		}
 
-		seg := newSegment(fd, size, name)
 		s.segments = append(s.segments, seg)
-		s.Size += size
+		s.Size += seg.size
+		if seg.baseSeq > s.seq.Load() {
+			s.seq.Store(seg.baseSeq)
+		}
 		if s.isDebug {
 			logs.Log(packageName, "load:segments:", s.Path, ":", s.Name, ":", seg.ToString())
 		}
 	}
 
 	if len(s.segments) == 0 {
+		if s.mode == modeRead {
+			return errors.New(msg.MSG_STORE_NOT_FOUND)
+		}
 		return s.newSegment()
 	}
 
@@ -190,22 +254,27 @@ func (s *FileStore) loadSegments() error {
 }
 
 /**
-* newSegment
+* newSegment: Creates the next segment and makes it the active one, only with the store open for writing
 * @return error
 **/
 func (s *FileStore) newSegment() error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+
 	name := fmt.Sprintf("segment-%06d.dat", len(s.segments)+1)
 	path := filepath.Join(s.PathSegments, name)
 
-	fd, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	seg, err := openSegment(s.fs, path, name, s.seq.Load())
 	if err != nil {
 		return err
 	}
 
-	seg := newSegment(fd, 0, name)
 	s.segments = append(s.segments, seg)
+	s.Size += seg.size
 	if s.active != nil {
-		err := s.active.Close()
+		// El segmento anterior queda abierto para lectura
+		err := s.active.Sync()
 		if err != nil {
 			return err
 		}
@@ -219,204 +288,181 @@ func (s *FileStore) newSegment() error {
 }
 
 /**
-* appendRecord
-* @param id string, data []byte, status byte
-* @return *RecordRef, error
+* upgradeActive: Moves the writes to a new segment when the active one has an old format
+* @return error
 **/
-func (s *FileStore) appendRecord(id string, data []byte, status byte) (*RecordRef, error) {
-	s.writeMu.Lock()
-	defer s.writeMu.Unlock()
-
-	recordSize := int64(len(id)) + int64(len(data)) + 11
-	currentSize := s.active.size
-	totalSize := currentSize + recordSize
-	s.Size += recordSize
-	if totalSize > s.MaxSegment {
-		if err := s.newSegment(); err != nil {
-			return nil, err
-		}
-
-		if err := s.CreateSnapshot(); err != nil {
-			return nil, err
-		}
-	}
-
-	ref, err := s.active.WriteRecord(id, data, status)
-	if err != nil {
-		return nil, err
-	}
-
-	ref.segment = len(s.segments) - 1
-
-	if s.SyncOnWrite {
-		if err := s.active.Sync(); err != nil {
-			return nil, err
-		}
+func (s *FileStore) upgradeActive() error {
+	if s.mode != modeWrite || s.active.version == segmentVersion {
+		return nil
 	}
 
-	n := len(s.index)
-	threshold := int(float64(n) * 0.1) // 10% del tamaño del índice
-	if s.TombStones > threshold {
-		go s.Compact()
+	if err := s.newSegment(); err != nil {
+		return err
 	}
 
-	return ref, nil
+	// El snapshot cubre el segmento anterior, solo se reproduce el nuevo
+	return s.CreateSnapshot()
 }
 
 /**
 * setIndex
-* @param id string, segIndex int, offset int64, dataLen uint32
+* @param id string, segIndex int, offset int64, dataLen uint32, seq uint64, expires int64
 * @return error
 **/
-func (s *FileStore) setIndex(id string, segIndex int, offset int64, dataLen uint32) error {
+func (s *FileStore) setIndex(id string, segIndex int, offset int64, dataLen uint32, seq uint64, expires int64) error {
 	ref := &RecordRef{
 		segment: segIndex,
 		offset:  offset,
 		length:  dataLen,
+		seq:     seq,
+		expires: expires,
 	}
-	s.index[id] = ref
-	s.keys = append(s.keys, id)
+	s.putIndex(id, ref)
 	return nil
 }
 
+/**
+* putIndex: Points the key to ref and moves its bytes to the live bytes of the segment, the caller holds indexMu
+* @param id string, ref *RecordRef
+**/
+func (s *FileStore) putIndex(id string, ref *RecordRef) {
+	if old, ok := s.index.Get(id); ok {
+		s.accountLive(id, old, -1)
+		s.uncache(old)
+	}
+	s.accountLive(id, ref, 1)
+	s.index.Set(id, ref)
+}
+
 /**
 * deleteIndex
 * @param id string
 **/
 func (s *FileStore) deleteIndex(id string) {
-	delete(s.index, id)
-	idx := slices.Index(s.keys, id)
-	if idx != -1 {
-		s.keys = append(s.keys[:idx], s.keys[idx+1:]...)
+	if old, ok := s.index.Get(id); ok {
+		s.accountLive(id, old, -1)
+		s.uncache(old)
 	}
+	s.index.Delete(id)
 }
 
 /**
-* rebuildIndex
-* @param segIndex int
-* @return error
+* accountLive: Adds or subtracts the bytes of the record to the live bytes of its segment
+* @param id string, ref *RecordRef, sign int64
 **/
-func (s *FileStore) rebuildIndex(segIndex int) error {
-	if len(s.index) == 0 {
-		s.index = make(map[string]*RecordRef)
-		s.keys = make([]string, 0)
+func (s *FileStore) accountLive(id string, ref *RecordRef, sign int64) {
+	if ref.segment < 0 || ref.segment >= len(s.segments) {
+		return
 	}
 
-	seg := s.segments[segIndex]
-	offset := int64(0)
-	for {
-		// Leer header mínimo
-		fixed := make([]byte, 11)
-		n, err := seg.ReadAt(fixed, offset)
-		if err != nil {
-			if errors.Is(err, io.EOF) || n < len(fixed) {
-				break
-			}
-			return err
-		}
-
-		dataLen := getUint32(fixed[0:4])
-		crcStored := getUint32(fixed[4:8])
-		idLen := getUint16(fixed[8:10])
-
-		if idLen == 0 || idLen > maxIdLen {
-			break // corrupción → paro seguro
-		}
+	seg := s.segments[ref.segment]
+	seg.live += sign * (recordHeaderSize(seg.version, len(id)) + int64(ref.length))
+}
 
-		// Leer ID
-		idBytes := make([]byte, idLen)
-		if _, err := seg.ReadAt(idBytes, offset+10); err != nil {
-			break
-		}
-		id := string(idBytes)
+/**
+* resetIndex: Empties the index and the live bytes of the segments, the caller holds indexMu
+**/
+func (s *FileStore) resetIndex() {
+	s.index = newIndex()
+	for _, seg := range s.segments {
+		seg.live = 0
+	}
+}
 
-		// Leer status
-		statusLen := int64(1)
-		statusByte := make([]byte, statusLen)
-		if _, err := seg.ReadAt(statusByte, offset+10+int64(idLen)); err != nil {
-			break
+/**
+* rebuildIndex: Replays the segment from start
+* @param segIndex int, start int64
+* @return error
+**/
+func (s *FileStore) rebuildIndex(segIndex int, start int64) error {
+	seg := s.segments[segIndex]
+	corrupt := seg.replayFrom(start, seg.size, func(h recordHeader, offset int64) {
+		if h.Seq > s.seq.Load() {
+			s.seq.Store(h.Seq)
 		}
-		status := statusByte[0]
-
-		// Leer payload
-		data := make([]byte, dataLen)
-		if dataLen > 0 {
-			if _, err := seg.ReadAt(data, offset+10+int64(idLen)+statusLen); err != nil {
-				break
-			}
-			if checksum(data) != crcStored {
-				break
-			}
+		if h.Status == Active {
+			// El registro ya fue validado, la lectura del vencimiento no falla
+			expires, _ := seg.readExpiry(h, offset)
+			s.setIndex(h.ID, segIndex, offset, h.DataLen, h.Seq, expires)
+		} else if h.Status == Deleted {
+			s.deleteIndex(h.ID)
 		}
+	})
+	if len(corrupt) == 0 {
+		return nil
+	}
 
-		if status == Active {
-			s.setIndex(id, segIndex, offset, dataLen)
-		} else if status == Deleted {
-			s.deleteIndex(id)
-		}
+	if !s.Recover {
+		return fmt.Errorf("%s: %s", msg.MSG_SEGMENT_CORRUPTED, corrupt[0].ToString())
+	}
 
-		offset += int64(11) + int64(idLen) + int64(dataLen)
+	if s.mode == modeRead {
+		// Sin el lock exclusivo no se repara, los rangos corruptos solo se omiten
+		logs.Alertf("recover:%s:%s:%s read only, corrupt ranges skipped:%d", s.Path, s.Name, seg.name, len(corrupt))
+		return nil
 	}
 
-	return nil
+	return s.repairSegment(segIndex, corrupt)
 }
 
 /**
-* buildIndex
+* buildIndex: With snapshot only the log after its position is replayed, without it all the segments
+* @param state *snapshotState
 * @return error
 **/
-func (s *FileStore) buildIndex() error {
-	idx := len(s.segments) - 1
-	return s.rebuildIndex(idx)
+func (s *FileStore) buildIndex(state *snapshotState) error {
+	first, start := 0, int64(-1)
+	if state != nil {
+		first, start = state.segment, state.offset
+	}
+
+	for i := first; i < len(s.segments); i++ {
+		from := s.segments[i].start()
+		if i == first && start >= 0 {
+			from = start
+		}
+		if err := s.rebuildIndex(i, from); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 /**
 * getRecords
 * @param asc bool, offset int, limit int
-* @return map[string]*RecordRef, []string
+* @return []indexItem
 **/
-func (s *FileStore) getRecords(asc bool, offset, limit int) (map[string]*RecordRef, []string) {
-	n := len(s.index)
-	keys := make([]string, 0)
-	indexResult := make(map[string]*RecordRef, 0)
-	if offset >= n {
-		return indexResult, keys
-	}
-
-	if limit <= 0 {
-		limit = n
-	}
+func (s *FileStore) getRecords(asc bool, offset, limit int) []indexItem {
+	s.reapExpired()
 
 	s.indexMu.RLock()
-	nKeys := len(s.keys)
-	if n != nKeys {
-		s.keys = make([]string, 0)
-		for k := range s.index {
-			s.keys = append(s.keys, k)
-		}
-		sort.Strings(keys)
+	defer s.indexMu.RUnlock()
+
+	result := make([]indexItem, 0)
+	s.walk(asc, offset, func(key string, ref *RecordRef) bool {
+		result = append(result, indexItem{key: key, ref: ref})
+		return limit <= 0 || len(result) < limit
+	})
+
+	return result
+}
+
+/**
+* walk: Walks the index in order, the caller holds indexMu
+* @param asc bool, offset int, fn func(key string, ref *RecordRef) bool
+**/
+func (s *FileStore) walk(asc bool, offset int, fn func(key string, ref *RecordRef) bool) {
+	if offset < 0 {
+		offset = 0
 	}
 
 	if asc {
-		sort.Strings(s.keys)
+		s.index.Ascend(offset, fn)
 	} else {
-		sort.Sort(sort.Reverse(sort.StringSlice(s.keys)))
-	}
-	i := 0
-	for {
-		k := s.keys[offset]
-		v := s.index[k]
-		indexResult[k] = v
-		keys = append(keys, k)
-		offset++
-		i++
-		if i >= limit {
-			break
-		}
+		s.index.Descend(offset, fn)
 	}
-	s.indexMu.RUnlock()
-
-	return indexResult, keys
 }
 
 /**
@@ -424,16 +470,59 @@ func (s *FileStore) getRecords(asc bool, offset, limit int) (map[string]*RecordR
 * @return error
 **/
 func (s *FileStore) Close() error {
+	s.compactor.stop()
+	s.stopCommitter()
+	s.closeSubscribers()
+
+	// Espera una compactación en curso
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
 	if s.active == nil {
 		return nil
 	}
 
-	err := s.active.Close()
-	if err != nil {
-		return err
+	// El snapshot de un store fallido podría cubrir bytes que no son durables
+	if s.mode == modeWrite && !s.failed.Load() {
+		if err := s.createSnapshot(); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	err := s.closeSegments()
+	s.active = nil
+
+	// El lock se libera aunque falle el cierre de los segmentos
+	if s.lock != nil {
+		if lerr := s.lock.Close(); err == nil {
+			err = lerr
+		}
+		s.lock = nil
+	}
+
+	return err
+}
+
+/**
+* closeSegments
+* @return error
+**/
+func (s *FileStore) closeSegments() error {
+	var result error
+	for _, seg := range s.segments {
+		var err error
+		if s.failed.Load() {
+			// Un store fallido no sincroniza los bytes del lote que no pudo deshacer
+			err = seg.file.Close()
+		} else {
+			err = seg.Close()
+		}
+		if err != nil && result == nil {
+			result = err
+		}
+	}
+
+	return result
 }
 
 /**
@@ -442,31 +531,16 @@ func (s *FileStore) Close() error {
 * @return []string
 **/
 func (s *FileStore) Keys(asc bool, offset, limit int) []string {
-	n := len(s.keys)
-	result := make([]string, 0)
-	if offset >= n {
-		return result
-	}
+	s.reapExpired()
 
-	if limit <= 0 {
-		limit = n
-	}
+	s.indexMu.RLock()
+	defer s.indexMu.RUnlock()
 
-	if asc {
-		sort.Strings(s.keys)
-	} else {
-		sort.Sort(sort.Reverse(sort.StringSlice(s.keys)))
-	}
-	i := 0
-	for {
-		k := s.keys[offset]
-		result = append(result, k)
-		offset++
-		i++
-		if i >= limit {
-			break
-		}
-	}
+	result := make([]string, 0)
+	s.walk(asc, offset, func(key string, ref *RecordRef) bool {
+		result = append(result, key)
+		return limit <= 0 || len(result) < limit
+	})
 
 	return result
 }
@@ -479,9 +553,9 @@ func (s *FileStore) rebuildIndexes() error {
 	s.indexMu.Lock()
 	defer s.indexMu.Unlock()
 
-	s.index = make(map[string]*RecordRef)
+	s.resetIndex()
 	for i := range s.segments {
-		if err := s.rebuildIndex(i); err != nil {
+		if err := s.rebuildIndex(i, s.segments[i].start()); err != nil {
 			return err
 		}
 	}
@@ -511,36 +585,30 @@ func (s *FileStore) OnDelete(fn func(string)) {
 * @return error
 **/
 func (s *FileStore) Put(id string, value any) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+
 	if id == "" {
 		return errors.New(msg.MSG_ID_IS_REQUIRED)
 	}
 
-	data, err := json.Marshal(value)
+	s.blobMu.RLock()
+	data, stored, flags, err := s.encode(id, value, 0)
 	if err != nil {
+		s.blobMu.RUnlock()
 		return err
 	}
-
-	ref, err := s.appendRecord(id, data, Active)
+	ref, err := s.appendRecord(id, stored, Active, flags)
+	s.blobMu.RUnlock()
 	if err != nil {
 		return err
 	}
 
-	s.indexMu.Lock()
-	_, exists := s.index[id]
-	if exists {
-		s.TombStones++
-	} else {
-		s.WAL++
-	}
-	s.index[id] = ref
-	s.indexMu.Unlock()
-
-	for _, fn := range s.onPut {
-		fn(id, data)
-	}
+	s.firePut(id, data, flags)
 
 	if s.isDebug {
-		i := len(s.index)
+		i := s.Count()
 		logs.Debug("put:", s.Path, ":", s.Name, ":total:", i, ":ID:", id, ":ref:", ref.ToString())
 	}
 
@@ -553,31 +621,28 @@ func (s *FileStore) Put(id string, value any) error {
 * @return bool, error
 **/
 func (s *FileStore) Delete(id string) (bool, error) {
-	s.indexMu.RLock()
-	_, exists := s.index[id]
-	if exists {
-		s.TombStones++
+	if err := s.writable(); err != nil {
+		return false, err
 	}
+
+	s.indexMu.RLock()
+	_, exists := s.index.Get(id)
 	s.indexMu.RUnlock()
 
 	if !exists {
 		return false, nil
 	}
 
-	if _, err := s.appendRecord(id, nil, Deleted); err != nil {
+	if _, err := s.appendRecord(id, nil, Deleted, CompressNone); err != nil {
 		return false, logs.Error(err)
 	}
 
-	s.indexMu.Lock()
-	s.deleteIndex(id)
-	s.indexMu.Unlock()
-
 	for _, fn := range s.onDelete {
 		fn(id)
 	}
 
 	if s.isDebug {
-		i := len(s.index)
+		i := s.Count()
 		logs.Debug("deleted:", s.Path, ":", s.Name, ":total:", i, ":ID:", id)
 	}
 
@@ -591,10 +656,10 @@ func (s *FileStore) Delete(id string) (bool, error) {
 **/
 func (s *FileStore) IsExist(id string) bool {
 	s.indexMu.RLock()
-	_, existed := s.index[id]
+	ref, existed := s.index.Get(id)
 	s.indexMu.RUnlock()
 
-	return existed
+	return existed && !ref.expired(time.Now().UnixNano())
 }
 
 /**
@@ -603,16 +668,16 @@ func (s *FileStore) IsExist(id string) bool {
 * @return bool, error
 **/
 func (s *FileStore) Get(id string, dest any) (bool, error) {
-	s.indexMu.RLock()
-	ref, existed := s.index[id]
-	s.indexMu.RUnlock()
+	data, codec, existed, err := s.readKey(id)
+	if err != nil {
+		return existed, err
+	}
 
 	if !existed {
 		return false, nil
 	}
 
-	seg := s.segments[ref.segment]
-	err := seg.Read(ref, dest)
+	err = codec.Unmarshal(data, dest)
 	if err != nil {
 		return existed, err
 	}
@@ -621,20 +686,62 @@ func (s *FileStore) Get(id string, dest any) (bool, error) {
 }
 
 /**
-* Iterate
+* readKey: Reads the current record of the key, the lock keeps the compaction from swapping the segments during the read
+* @param id string
+* @return []byte, Codec, bool, error
+**/
+func (s *FileStore) readKey(id string) ([]byte, Codec, bool, error) {
+	s.indexMu.RLock()
+	defer s.indexMu.RUnlock()
+
+	ref, existed := s.index.Get(id)
+	if !existed || ref.expired(time.Now().UnixNano()) {
+		return nil, nil, false, nil
+	}
+
+	data, codec, err := s.readRef(id, ref)
+	if err != nil {
+		return nil, nil, existed, err
+	}
+
+	return data, codec, existed, nil
+}
+
+/**
+* Iterate: The data of the records is JSON whatever the codec they were written with
 * @param fn func(id string, data []byte) bool, asc bool, offset, limit, workers int
 * @return error
 **/
 func (s *FileStore) Iterate(fn func(id string, data []byte) (bool, error), asc bool, offset, limit, workers int) error {
 	// 1. Seleccionar IDs
-	index, keys := s.getRecords(asc, offset, limit)
+	records := s.getRecords(asc, offset, limit)
+
+	return s.iterate(records, jsonFn(fn), workers)
+}
+
+/**
+* IterateObjects: Iterates the records decoded with their codec, the integers keep their type with the binary codecs
+* @param fn func(id string, item et.Json) (bool, error), asc bool, offset, limit, workers int
+* @return error
+**/
+func (s *FileStore) IterateObjects(fn func(id string, item et.Json) (bool, error), asc bool, offset, limit, workers int) error {
+	records := s.getRecords(asc, offset, limit)
+
+	return s.iterate(records, objectFn(fn), workers)
+}
 
+/**
+* iterate: Reads the records with a pool of workers
+* @param records []indexItem, fn iterateFn, workers int
+* @return error
+**/
+func (s *FileStore) iterate(records []indexItem, fn iterateFn, workers int) error {
 	if workers <= 0 {
 		workers = 1
 	}
 
 	// 2) Worker pool
-	jobs := make(chan string, 1024)
+	jobs := make(chan indexItem, 1024)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -667,27 +774,23 @@ func (s *FileStore) Iterate(fn func(id string, data []byte) (bool, error), asc b
 				case <-ctx.Done():
 					return
 
-				case id, ok := <-jobs:
+				case item, ok := <-jobs:
 					if !ok {
 						return
 					}
 
-					ref, ok := index[id]
-					if !ok {
-						// si esto puede pasar, es inconsistencia del índice
-						// define si debe ser error o skip
-						continue
-					}
-
-					seg := s.segments[ref.segment]
-
-					data, err := seg.read(ref)
+					id := item.key
+					data, codec, existed, err := s.readKey(id)
 					if err != nil {
 						setErr(err)
 						return
 					}
 
-					cont, err := fn(id, data)
+					if !existed {
+						continue // eliminado durante la iteración
+					}
+
+					cont, err := fn(id, data, codec)
 					if err != nil {
 						setErr(err)
 						return
@@ -706,11 +809,11 @@ func (s *FileStore) Iterate(fn func(id string, data []byte) (bool, error), asc b
 	}
 
 	// 4) Enviar jobs (producer)
-	for _, id := range keys {
+	for _, item := range records {
 		select {
 		case <-ctx.Done():
 			break
-		case jobs <- id:
+		case jobs <- item:
 		}
 	}
 
@@ -720,6 +823,180 @@ func (s *FileStore) Iterate(fn func(id string, data []byte) (bool, error), asc b
 	return mErr
 }
 
+/**
+* getRange
+* @param start, end string, asc bool, offset, limit int
+* @return []indexItem
+**/
+func (s *FileStore) getRange(start, end string, asc bool, offset, limit int) []indexItem {
+	s.reapExpired()
+
+	s.indexMu.RLock()
+	defer s.indexMu.RUnlock()
+
+	result := make([]indexItem, 0)
+	collect := func(key string, ref *RecordRef) bool {
+		if offset > 0 {
+			offset--
+			return true
+		}
+
+		result = append(result, indexItem{key: key, ref: ref})
+		return limit <= 0 || len(result) < limit
+	}
+
+	if asc {
+		s.index.AscendRange(start, end, collect)
+	} else {
+		s.index.DescendRange(start, end, collect)
+	}
+
+	return result
+}
+
+/**
+* CountRange: Returns the number of keys in [start, end), end "" is unbounded
+* @param start, end string
+* @return int
+**/
+func (s *FileStore) CountRange(start, end string) int {
+	s.reapExpired()
+
+	s.indexMu.RLock()
+	defer s.indexMu.RUnlock()
+
+	last := s.index.Len()
+	if end != "" {
+		last = s.index.Rank(end)
+	}
+
+	return max(last-s.index.Rank(start), 0)
+}
+
+/**
+* rangeBatch: Collects up to n refs of the range without holding the lock for the whole walk
+* @param start, end string, asc bool, n int
+* @return []indexItem
+**/
+func (s *FileStore) rangeBatch(start, end string, asc bool, n int) []indexItem {
+	s.indexMu.RLock()
+	defer s.indexMu.RUnlock()
+
+	result := make([]indexItem, 0, n)
+	collect := func(key string, ref *RecordRef) bool {
+		result = append(result, indexItem{key: key, ref: ref})
+		return len(result) < n
+	}
+
+	if asc {
+		s.index.AscendRange(start, end, collect)
+	} else {
+		s.index.DescendRange(start, end, collect)
+	}
+
+	return result
+}
+
+/**
+* scanRange: Walks the keys of [start, end) by batches, end "" is unbounded
+* @param start, end string, asc bool, fn func(item indexItem) (bool, error)
+* @return error
+**/
+func (s *FileStore) scanRange(start, end string, asc bool, fn func(item indexItem) (bool, error)) error {
+	const batchSize = 256
+	s.reapExpired()
+	for {
+		items := s.rangeBatch(start, end, asc, batchSize)
+		for _, item := range items {
+			next, err := fn(item)
+			if err != nil {
+				return err
+			}
+			if !next {
+				return nil
+			}
+		}
+
+		if len(items) < batchSize {
+			return nil
+		}
+
+		last := items[len(items)-1].key
+		if asc {
+			start = last + "\x00"
+		} else {
+			end = last
+		}
+	}
+}
+
+/**
+* KeysRange: Returns the keys in [start, end), end "" is unbounded
+* @param start, end string, asc bool, offset, limit int
+* @return []string
+**/
+func (s *FileStore) KeysRange(start, end string, asc bool, offset, limit int) []string {
+	result := make([]string, 0)
+	s.scanRange(start, end, asc, func(item indexItem) (bool, error) {
+		if offset > 0 {
+			offset--
+			return true, nil
+		}
+
+		result = append(result, item.key)
+		return limit <= 0 || len(result) < limit, nil
+	})
+
+	return result
+}
+
+/**
+* KeysPrefix: Returns the keys that start with prefix
+* @param prefix string, asc bool, offset, limit int
+* @return []string
+**/
+func (s *FileStore) KeysPrefix(prefix string, asc bool, offset, limit int) []string {
+	return s.KeysRange(prefix, PrefixEnd(prefix), asc, offset, limit)
+}
+
+/**
+* IterateRange: Iterates the records in [start, end), end "" is unbounded, fn returns false to stop
+* @param start, end string, asc bool, fn func(id string, data []byte) (bool, error)
+* @return error
+**/
+func (s *FileStore) IterateRange(start, end string, asc bool, fn func(id string, data []byte) (bool, error)) error {
+	return s.iterateRange(start, end, asc, jsonFn(fn))
+}
+
+/**
+* iterateRange
+* @param start, end string, asc bool, fn iterateFn
+* @return error
+**/
+func (s *FileStore) iterateRange(start, end string, asc bool, fn iterateFn) error {
+	return s.scanRange(start, end, asc, func(item indexItem) (bool, error) {
+		data, codec, existed, err := s.readKey(item.key)
+		if err != nil {
+			return false, err
+		}
+
+		if !existed {
+			return true, nil
+		}
+
+		return fn(item.key, data, codec)
+	})
+}
+
+/**
+* IteratePrefix: Iterates the records whose id starts with prefix, fn returns false to stop
+* @param prefix string, asc bool, fn func(id string, data []byte) (bool, error)
+* @return error
+**/
+func (s *FileStore) IteratePrefix(prefix string, asc bool, fn func(id string, data []byte) (bool, error)) error {
+	return s.IterateRange(prefix, PrefixEnd(prefix), asc, fn)
+}
+
 /**
 * Prune
 * @return error
@@ -743,60 +1020,159 @@ func (s *FileStore) Prune() error {
 * @return error
 **/
 func (s *FileStore) Empty() error {
-	s.index = make(map[string]*RecordRef)
+	s.indexMu.Lock()
+	s.resetIndex()
+	s.indexMu.Unlock()
 	s.WAL = 0
-	s.TombStones = 0
+	s.tombStones.Store(0)
+
+	return nil
+}
+
+/**
+* load: Loads the segments and builds the index
+* @return error
+**/
+func (s *FileStore) load() error {
+	if err := s.loadSegments(); err != nil {
+		return fmt.Errorf("loadSegments: %w", err)
+	}
+	state, err := s.tryLoadSnapshot()
+	if err != nil {
+		return fmt.Errorf("tryLoadSnapshot: %w", err)
+	}
+	if err := s.buildIndex(state); err != nil {
+		return fmt.Errorf("buildIndex: %w", err)
+	}
+	if err := s.upgradeActive(); err != nil {
+		return fmt.Errorf("upgradeActive: %w", err)
+	}
+
+	return nil
+}
+
+/**
+* prepare: Recovers a stopped compaction swap and creates the directories of the store, the caller holds the lock
+* @return error
+**/
+func (s *FileStore) prepare() error {
+	if err := s.recoverSwap(); err != nil {
+		return err
+	}
+
+	for _, dir := range []string{s.PathSegments, s.PathSnapshot, s.PathCompact, s.PathBlobs} {
+		if err := s.fs.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
 /**
 * open
-* @param path, name string,
+* @param fsys FS, path, name string, isDebug bool, mode mode
 * @return *FileStore, error
 **/
-func open(path, name string, isDebug bool, mode mode) (*FileStore, error) {
+func open(fsys FS, path, name string, isDebug bool, mode mode) (*FileStore, error) {
 	maxSegmentMG := envar.GetInt64("RELSEG_SIZE", 128)
 	maxSegmentMG = maxSegmentMG * 1024 * 1024
 	name = utility.Normalize(name)
 	fs := &FileStore{
-		Name:         name,
-		Path:         filepath.Join(path),
-		PathSegments: filepath.Join(path, name, "segments"),
-		PathSnapshot: filepath.Join(path, name, "snapshot"),
-		PathCompact:  filepath.Join(path, name, "compact"),
-		MaxSegment:   maxSegmentMG,
-		isDebug:      isDebug,
-		mode:         mode,
-		onPut:        make([]Putfn, 0),
-		onDelete:     make([]Deletefn, 0),
+		Name:           name,
+		Path:           filepath.Join(path),
+		PathSegments:   filepath.Join(path, name, "segments"),
+		PathSnapshot:   filepath.Join(path, name, "snapshot"),
+		PathCompact:    filepath.Join(path, name, "compact"),
+		PathQuarantine: filepath.Join(path, name, "quarantine"),
+		PathBlobs:      filepath.Join(path, name, "blobs"),
+		MaxSegment:     maxSegmentMG,
+		isDebug:        isDebug,
+		fs:             fsys,
+		mode:           mode,
+		onPut:          make([]Putfn, 0),
+		onDelete:       make([]Deletefn, 0),
+		subscribers:    make(map[*Subscription]bool),
 	}
 
 	syncOnWrite := envar.GetBool("SYNC_ON_WRITE", true)
-	fs.index = make(map[string]*RecordRef)
-	fs.keys = make([]string, 0)
-	fs.SyncOnWrite = syncOnWrite
-
-	if err := os.MkdirAll(fs.PathSegments, 0755); err != nil {
-		return nil, err
+	recoverOnOpen := envar.GetBool("RECOVER_ON_OPEN", true)
+	compression := compressionCode(envar.GetStr("STORE_COMPRESSION", "none"))
+	codec, err := CodecByName(envar.GetStr("STORE_CODEC", "json"))
+	if err != nil {
+		codec = jsonCodec{}
 	}
-	if err := os.MkdirAll(fs.PathSnapshot, 0755); err != nil {
+	blobThreshold := envar.GetInt64("BLOB_THRESHOLD", 1024) * 1024
+	keyring, err := loadKeyring()
+	if err != nil {
 		return nil, err
 	}
-	if err := os.MkdirAll(fs.PathCompact, 0755); err != nil {
-		return nil, err
+	cacheSize := envar.GetInt64("READ_CACHE_MB", 32) * 1024 * 1024
+	// Los datos de un MemFS ya están en memoria, no se sincronizan ni se cachean
+	_, memory := fsys.(*MemFS)
+	if memory {
+		syncOnWrite = false
+		cacheSize = 0
+	}
+	maxBatch := envar.GetInt("GROUP_COMMIT_SIZE", 512)
+	if maxBatch <= 0 {
+		maxBatch = 1
+	}
+	maxQueue := envar.GetInt("CDC_QUEUE_SIZE", 10000)
+	fs.index = newIndex()
+	fs.compactor = newCompactor(fs)
+	fs.SyncOnWrite = syncOnWrite
+	fs.Memory = memory
+	fs.MaxBatch = maxBatch
+	fs.MaxQueue = max(maxQueue, 1)
+	fs.BlobThreshold = max(blobThreshold, 0)
+	fs.CacheSize = max(cacheSize, 0)
+	fs.cache = newReadCache(fs.CacheSize)
+	fs.Recover = recoverOnOpen
+	fs.compression = compression
+	fs.Compression = compressionName(compression)
+	fs.codec = codec
+	fs.Codec = codec.Name()
+	fs.SetKeyring(keyring)
+
+	if mode == modeRead && !fsys.Exists(fs.PathSegments) {
+		// Un swap interrumpido deja los segmentos anteriores completos
+		if !fsys.Exists(fs.oldSegmentsPath()) {
+			return nil, errors.New(msg.MSG_STORE_NOT_FOUND)
+		}
+		fs.PathSegments = fs.oldSegmentsPath()
+	}
+
+	if mode == modeWrite {
+		if err := fs.fs.MkdirAll(filepath.Join(path, name), 0755); err != nil {
+			return nil, err
+		}
 	}
 
-	if err := fs.loadSegments(); err != nil {
-		return nil, fmt.Errorf("loadSegments: %w", err)
+	// Un solo escritor por directorio, los lectores comparten el lock
+	lock, err := fsys.Lock(filepath.Join(path, name, lockName), mode == modeWrite)
+	if err != nil {
+		return nil, err
 	}
-	if err := fs.tryLoadSnapshot(); err != nil {
-		return nil, fmt.Errorf("tryLoadSnapshot: %w", err)
+	fs.lock = lock
+
+	if mode == modeWrite {
+		if err := fs.prepare(); err != nil {
+			fs.lock.Close()
+			return nil, err
+		}
 	}
-	if err := fs.buildIndex(); err != nil {
-		return nil, fmt.Errorf("buildIndex: %w", err)
+
+	if err := fs.load(); err != nil {
+		fs.closeSegments()
+		fs.lock.Close()
+		return nil, err
 	}
 
+	fs.startCommitter()
+	if mode == modeWrite {
+		fs.compactor.start()
+	}
 	return fs, nil
 }
 
@@ -806,7 +1182,25 @@ func open(path, name string, isDebug bool, mode mode) (*FileStore, error) {
 * @return *FileStore, error
 **/
 func Open(path, name string, isDebug bool) (*FileStore, error) {
-	return open(path, name, isDebug, modeWrite)
+	return open(OSFS, path, name, isDebug, modeWrite)
+}
+
+/**
+* OpenFS: Opens the store over the given filesystem
+* @param fsys FS, path, name string, isDebug bool
+* @return *FileStore, error
+**/
+func OpenFS(fsys FS, path, name string, isDebug bool) (*FileStore, error) {
+	return open(fsys, path, name, isDebug, modeWrite)
+}
+
+/**
+* OpenMemory: Opens a store that lives only in memory, the data is lost when it is closed
+* @param name string, isDebug bool
+* @return *FileStore, error
+**/
+func OpenMemory(name string, isDebug bool) (*FileStore, error) {
+	return open(NewMemFS(), memoryPath, name, isDebug, modeWrite)
 }
 
 /**
@@ -815,5 +1209,5 @@ func Open(path, name string, isDebug bool) (*FileStore, error) {
 * @return *FileStore, error
 **/
 func ReadOnly(path, name string, isDebug bool) (*FileStore, error) {
-	return open(path, name, isDebug, modeRead)
+	return open(OSFS, path, name, isDebug, modeRead)
 }
@@ -0,0 +1,256 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+/**
+* rangeStore: Opens a store with the keys given, the value of each key is its hex
+* @param t *testing.T, keys ...string
+* @return *FileStore
+**/
+func rangeStore(t *testing.T, keys ...string) *FileStore {
+	t.Helper()
+	result, err := Open(t.TempDir(), "range", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { result.Close() })
+
+	for _, key := range keys {
+		if err := result.Put(key, fmt.Sprintf("%x", key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return result
+}
+
+/**
+* iterated: Returns the ids given by IterateRange until fn stops after limit ids, limit 0 is unbounded
+* @param t *testing.T, fs *FileStore, start, end string, asc bool, limit int
+* @return []string
+**/
+func iterated(t *testing.T, fs *FileStore, start, end string, asc bool, limit int) []string {
+	t.Helper()
+	result := []string{}
+	err := fs.IterateRange(start, end, asc, func(id string, data []byte) (bool, error) {
+		if string(data) != fmt.Sprintf(`"%x"`, id) {
+			t.Fatalf("%s with value %s", id, data)
+		}
+		result = append(result, id)
+		return limit <= 0 || len(result) < limit, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, expected := range map[string]string{
+		"":          "",
+		"ab":        "ac",
+		"a\xff":     "b",
+		"a\xff\xff": "b",
+		"\xff":      "",
+		"\xff\xff":  "",
+	} {
+		if end := PrefixEnd(prefix); end != expected {
+			t.Fatalf("end of %q %q, expected %q", prefix, end, expected)
+		}
+	}
+}
+
+func TestIterateRange(t *testing.T) {
+	fs := rangeStore(t, "a", "b", "ba", "bz", "b\xff", "b\xff\x01", "c", "d")
+	fs.Delete("d")
+
+	for _, c := range []struct {
+		start, end string
+		expected   []string
+	}{
+		{"b", "c", []string{"b", "ba", "bz", "b\xff", "b\xff\x01"}},
+		{"ba", "bz", []string{"ba"}},
+		{"", "", []string{"a", "b", "ba", "bz", "b\xff", "b\xff\x01", "c"}},
+		{"bz", "", []string{"bz", "b\xff", "b\xff\x01", "c"}},
+		{"b", "b", []string{}},
+		{"c", "b", []string{}},
+		{"x", "", []string{}},
+	} {
+		asc := iterated(t, fs, c.start, c.end, true, 0)
+		if !slices.Equal(asc, c.expected) {
+			t.Fatalf("[%q, %q) ascending %q", c.start, c.end, asc)
+		}
+
+		desc := iterated(t, fs, c.start, c.end, false, 0)
+		slices.Reverse(desc)
+		if !slices.Equal(desc, c.expected) {
+			t.Fatalf("[%q, %q) descending %q", c.start, c.end, desc)
+		}
+	}
+
+	// fn detiene el recorrido en los dos sentidos
+	if ids := iterated(t, fs, "", "", true, 2); !slices.Equal(ids, []string{"a", "b"}) {
+		t.Fatalf("ascending stopped at %q", ids)
+	}
+	if ids := iterated(t, fs, "", "", false, 2); !slices.Equal(ids, []string{"c", "b\xff\x01"}) {
+		t.Fatalf("descending stopped at %q", ids)
+	}
+}
+
+func TestIteratePrefix(t *testing.T) {
+	fs := rangeStore(t, "a", "b", "ba", "b\xff", "b\xff\x01", "b\xff\xff", "c")
+
+	for prefix, expected := range map[string][]string{
+		"b":     {"b", "ba", "b\xff", "b\xff\x01", "b\xff\xff"},
+		"b\xff": {"b\xff", "b\xff\x01", "b\xff\xff"},
+		"bb":    {},
+		"":      {"a", "b", "ba", "b\xff", "b\xff\x01", "b\xff\xff", "c"},
+	} {
+		ids := []string{}
+		err := fs.IteratePrefix(prefix, false, func(id string, data []byte) (bool, error) {
+			ids = append(ids, id)
+			return true, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Reverse(ids)
+		if !slices.Equal(ids, expected) {
+			t.Fatalf("prefix %q descending %q", prefix, ids)
+		}
+		if keys := fs.KeysPrefix(prefix, true, 0, 0); !slices.Equal(keys, expected) {
+			t.Fatalf("keys of the prefix %q %q", prefix, keys)
+		}
+	}
+}
+
+func TestKeysRange(t *testing.T) {
+	// Más claves que un lote del recorrido
+	keys := []string{}
+	for i := 0; i < 600; i++ {
+		keys = append(keys, fmt.Sprintf("k%04d", i))
+	}
+	fs := rangeStore(t, keys...)
+
+	if result := fs.KeysRange("", "", true, 0, 0); !slices.Equal(result, keys) {
+		t.Fatalf("%d keys ascending", len(result))
+	}
+	desc := fs.KeysRange("", "", false, 0, 0)
+	slices.Reverse(desc)
+	if !slices.Equal(desc, keys) {
+		t.Fatalf("%d keys descending", len(desc))
+	}
+
+	for _, c := range []struct {
+		start, end    string
+		asc           bool
+		offset, limit int
+		expected      []string
+	}{
+		{"k0100", "k0400", true, 250, 10, keys[350:360]},
+		{"k0100", "k0400", true, 295, 10, keys[395:400]},
+		{"k0100", "k0400", false, 250, 3, []string{"k0149", "k0148", "k0147"}},
+		{"k0100", "k0400", false, 300, 3, []string{}},
+		{"k0100", "k0100", true, 0, 0, []string{}},
+		{"k0599", "", false, 0, 0, []string{"k0599"}},
+		{"z", "", true, 0, 0, []string{}},
+	} {
+		result := fs.KeysRange(c.start, c.end, c.asc, c.offset, c.limit)
+		if !slices.Equal(result, c.expected) {
+			t.Fatalf("[%s, %s) asc %v offset %d limit %d: %v", c.start, c.end, c.asc, c.offset, c.limit, result)
+		}
+	}
+}
+
+/**
+* chdirTemp: Moves the working directory and the temporary directory of the process to empty directories
+* @param t *testing.T
+* @return []string
+**/
+func chdirTemp(t *testing.T) []string {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, tmp := t.TempDir(), t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	return []string{dir, tmp}
+}
+
+/**
+* expectEmpty: Checks that nothing was written in the directories
+* @param t *testing.T, dirs []string
+**/
+func expectEmpty(t *testing.T, dirs []string) {
+	t.Helper()
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 0 {
+			t.Fatalf("%d entries written in %s, the first %s", len(entries), dir, entries[0].Name())
+		}
+	}
+}
+
+func TestOpenMemory(t *testing.T) {
+	t.Setenv("BLOB_THRESHOLD", "1")
+	dirs := chdirTemp(t)
+
+	fs, err := OpenMemory("memory", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fs.Memory || fs.CacheSize != 0 {
+		t.Fatalf("memory %v cache %d", fs.Memory, fs.CacheSize)
+	}
+
+	// Escrituras, blobs, snapshot, compactación y backup quedan en memoria
+	large := strings.Repeat("x", 2048)
+	fs.Put("a", "a1")
+	fs.Put("b", large)
+	fs.Put("a", "a2")
+	fs.Delete("c")
+	if err := fs.CreateSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.CollectBlobs(); err != nil {
+		t.Fatal(err)
+	}
+	backupOf(t, fs)
+	if stats, err := fs.Stats(); err != nil || stats.Blobs != 1 {
+		t.Fatalf("stats %v %v", stats, err)
+	}
+	expectStrings(t, fs, map[string]string{"a": "a2", "b": large})
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Los datos se pierden al cerrar
+	fs, err = OpenMemory("memory", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if fs.Count() != 0 {
+		t.Fatalf("%d records after the close", fs.Count())
+	}
+	expectEmpty(t, dirs)
+}
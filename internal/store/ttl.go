@@ -0,0 +1,178 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cgalvisleon/et/logs"
+	"github.com/cgalvisleon/josefina/pkg/msg"
+)
+
+const (
+	flagExpires  byte = 0x04 // los datos comienzan con la fecha de vencimiento
+	expiresSize       = 8
+	expiryKeyLen      = 20 // fecha de vencimiento con ceros a la izquierda
+)
+
+/**
+* expiryKey: Key of the expiries index, ordered by date and then by key
+* @param expires int64, key string
+* @return string
+**/
+func expiryKey(expires int64, key string) string {
+	return fmt.Sprintf("%020d", expires) + key
+}
+
+/**
+* expired
+* @param now int64
+* @return bool
+**/
+func (s *RecordRef) expired(now int64) bool {
+	return s.expires != 0 && s.expires <= now
+}
+
+/**
+* withExpiry: Prefixes the stored data with the expiration date
+* @param data []byte, flags byte, expires int64
+* @return []byte, byte
+**/
+func withExpiry(data []byte, flags byte, expires int64) ([]byte, byte) {
+	if expires == 0 {
+		return data, flags
+	}
+
+	result := make([]byte, expiresSize+len(data))
+	putUint64(result[0:expiresSize], uint64(expires))
+	copy(result[expiresSize:], data)
+	return result, flags | flagExpires
+}
+
+/**
+* splitExpiry: Separates the expiration date of the stored data
+* @param data []byte, flags byte
+* @return []byte, int64, error
+**/
+func splitExpiry(data []byte, flags byte) ([]byte, int64, error) {
+	if flags&flagExpires == 0 {
+		return data, 0, nil
+	}
+
+	if len(data) < expiresSize {
+		return nil, 0, errors.New(msg.MSG_CORRUPTED_RECORD)
+	}
+
+	return data[expiresSize:], int64(getUint64(data[0:expiresSize])), nil
+}
+
+/**
+* readExpiry: Reads the expiration date of the record at offset
+* @param h recordHeader, offset int64
+* @return int64, error
+**/
+func (s *segment) readExpiry(h recordHeader, offset int64) (int64, error) {
+	if h.Flags&flagExpires == 0 {
+		return 0, nil
+	}
+
+	buf := make([]byte, expiresSize)
+	if _, err := s.ReadAt(buf, offset+h.HeaderSize()); err != nil {
+		return 0, err
+	}
+
+	return int64(getUint64(buf)), nil
+}
+
+/**
+* PutWithTTL: Writes the value, it stops being visible after ttl and the compaction drops it
+* @param id string, value any, ttl time.Duration
+* @return error
+**/
+func (s *FileStore) PutWithTTL(id string, value any, ttl time.Duration) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+
+	if id == "" {
+		return errors.New(msg.MSG_ID_IS_REQUIRED)
+	}
+
+	if ttl <= 0 {
+		return errors.New(msg.MSG_INVALID_TTL)
+	}
+
+	s.blobMu.RLock()
+	defer s.blobMu.RUnlock()
+
+	expires := time.Now().Add(ttl).UnixNano()
+	data, stored, flags, err := s.encode(id, value, expires)
+	if err != nil {
+		return err
+	}
+	rec := newLogRecord(id, stored, Active, flags)
+	rec.expires = expires
+	if _, err := s.commit(rec); err != nil {
+		return err
+	}
+
+	s.firePut(id, data, flags)
+
+	return nil
+}
+
+/**
+* ExpiresAt: Returns the expiration date of the key, zero when it does not expire
+* @param id string
+* @return time.Time, bool
+**/
+func (s *FileStore) ExpiresAt(id string) (time.Time, bool) {
+	s.indexMu.RLock()
+	defer s.indexMu.RUnlock()
+
+	ref, ok := s.index.Get(id)
+	if !ok || ref.expired(time.Now().UnixNano()) {
+		return time.Time{}, false
+	}
+
+	if ref.expires == 0 {
+		return time.Time{}, true
+	}
+
+	return time.Unix(0, ref.expires), true
+}
+
+/**
+* reapExpired: Removes the expired keys from the index, their records stay in the log until the compaction
+* @return int
+**/
+func (s *FileStore) reapExpired() int {
+	now := time.Now().UnixNano()
+
+	s.indexMu.RLock()
+	n := s.index.ExpiredCount(now)
+	s.indexMu.RUnlock()
+	if n == 0 {
+		return 0
+	}
+
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	keys := []string{}
+	s.index.Expired(now, func(key string, ref *RecordRef) bool {
+		keys = append(keys, key)
+		return true
+	})
+
+	for _, key := range keys {
+		s.deleteIndex(key)
+		s.tombStones.Add(1)
+	}
+
+	if s.isDebug && len(keys) > 0 {
+		logs.Debug("expired:", s.Path, ":", s.Name, ":keys:", len(keys))
+	}
+
+	return len(keys)
+}
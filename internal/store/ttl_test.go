@@ -0,0 +1,46 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReapExpiredConcurrentWrites(t *testing.T) {
+	fs, err := OpenMemory("ttl", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				id := fmt.Sprintf("k%d", i%20)
+				if err := fs.PutWithTTL(id, i, time.Millisecond); err != nil {
+					t.Error(err)
+					return
+				}
+				fs.Count()
+				fs.Stats()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	time.Sleep(5 * time.Millisecond)
+	if n := fs.Count(); n != 0 {
+		t.Fatalf("expired keys still counted: %d", n)
+	}
+	stats, err := fs.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TombStones == 0 {
+		t.Fatal("replaced and expired records not counted as tombstones")
+	}
+}
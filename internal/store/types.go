@@ -6,8 +6,10 @@ import (
 )
 
 const (
-	Active  byte = 1
-	Deleted byte = 2
+	Active      byte = 1
+	Deleted     byte = 2
+	BatchBegin  byte = 3
+	BatchCommit byte = 4
 )
 
 var crcTable = crc32.MakeTable(crc32.Castagnoli)
@@ -30,6 +32,15 @@ func putUint32(b []byte, v uint32) {
 	binary.BigEndian.PutUint32(b, v)
 }
 
+/**
+* putUint64
+* @param b []byte, v uint64
+* @return void
+**/
+func putUint64(b []byte, v uint64) {
+	binary.BigEndian.PutUint64(b, v)
+}
+
 /**
 * putUint16
 * @param b []byte, v uint16
@@ -47,6 +58,14 @@ func getUint32(b []byte) uint32 {
 	return binary.BigEndian.Uint32(b)
 }
 
+/**
+* @param b []byte
+* @return uint64
+**/
+func getUint64(b []byte) uint64 {
+	return binary.BigEndian.Uint64(b)
+}
+
 /**
 * @param b []byte
 * @return uint16
@@ -0,0 +1,230 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cgalvisleon/josefina/pkg/msg"
+)
+
+/**
+* File: Handle of a file of the store
+**/
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+	Name() string
+	Size() (int64, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+/**
+* FS: Filesystem used by the store for the segments, the snapshots and the compaction
+**/
+type FS interface {
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	ReadDir(name string) ([]string, error)
+	MkdirAll(path string, perm os.FileMode) error
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	RemoveAll(path string) error
+	Exists(name string) bool
+	Lock(name string, exclusive bool) (io.Closer, error)
+}
+
+/**
+* OSFS: Filesystem of the operating system
+**/
+var OSFS FS = osFS{}
+
+type osFS struct{}
+
+type osFile struct {
+	*os.File
+}
+
+/**
+* Size
+* @return int64, error
+**/
+func (s osFile) Size() (int64, error) {
+	st, err := s.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	return st.Size(), nil
+}
+
+/**
+* OpenFile
+* @param name string, flag int, perm os.FileMode
+* @return File, error
+**/
+func (s osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return osFile{f}, nil
+}
+
+/**
+* ReadDir: Returns the names of the directory ordered by name
+* @param name string
+* @return []string, error
+**/
+func (s osFS) ReadDir(name string) ([]string, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, len(entries))
+	for i, entry := range entries {
+		result[i] = entry.Name()
+	}
+
+	return result, nil
+}
+
+/**
+* MkdirAll
+* @param path string, perm os.FileMode
+* @return error
+**/
+func (s osFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+/**
+* Rename
+* @param oldpath, newpath string
+* @return error
+**/
+func (s osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+/**
+* Remove
+* @param name string
+* @return error
+**/
+func (s osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+/**
+* RemoveAll
+* @param path string
+* @return error
+**/
+func (s osFS) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+/**
+* Exists
+* @param name string
+* @return bool
+**/
+func (s osFS) Exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+/**
+* Lock: Takes the lock of the file, exclusive for the writers and shared for the readers, it fails when it is held.
+* The readers do not create the file, without it no writer has opened the store
+* @param name string, exclusive bool
+* @return io.Closer, error
+**/
+func (s osFS) Lock(name string, exclusive bool) (io.Closer, error) {
+	flag := os.O_CREATE | os.O_RDWR
+	if !exclusive {
+		flag = os.O_RDONLY
+	}
+
+	f, err := os.OpenFile(name, flag, 0644)
+	if !exclusive && os.IsNotExist(err) {
+		return noLock{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := lockFile(f, exclusive)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !ok {
+		f.Close()
+		return nil, fmt.Errorf(msg.MSG_STORE_LOCKED, name)
+	}
+
+	// Cerrar el archivo libera el lock
+	return f, nil
+}
+
+type noLock struct{}
+
+/**
+* Close
+* @return error
+**/
+func (noLock) Close() error {
+	return nil
+}
+
+/**
+* readFile
+* @param fsys FS, name string
+* @return []byte, error
+**/
+func readFile(fsys FS, name string) ([]byte, error) {
+	f, err := fsys.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	size, err := f.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, size)
+	if _, err := f.ReadAt(result, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+/**
+* writeFile: Writes and syncs the file
+* @param fsys FS, name string, data []byte
+* @return error
+**/
+func writeFile(fsys FS, name string, data []byte) error {
+	f, err := fsys.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteAt(data, 0)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -75,6 +75,38 @@ var (
 	MSG_BYE                         = "bay"
 	MSG_HOLA                        = "hola"
 	MSG_CHANNEL_NOT_FOUND           = "channel not found (%s)"
+	MSG_STORE_CLOSED                = "store closed"
+	MSG_UNKNOWN_COMPRESSION         = "unknown compression"
+	MSG_INVALID_SEGMENT_VERSION     = "invalid segment version"
+	MSG_INCOMPLETE_RECORD           = "incomplete record"
+	MSG_INCOMPLETE_BATCH            = "incomplete batch"
+	MSG_SEQ_COMPACTED               = "sequence no longer available, the log was compacted"
+	MSG_SEGMENT_CORRUPTED           = "segment corrupted, open with RECOVER_ON_OPEN=true to repair"
+	MSG_STORE_EXISTS                = "store already exists (%s)"
+	MSG_INVALID_BACKUP_ENTRY        = "invalid backup entry (%s)"
+	MSG_IS_DIRECTORY                = "is a directory"
+	MSG_NOT_DIRECTORY               = "not a directory"
+	MSG_DIRECTORY_NOT_EMPTY         = "directory not empty"
+	MSG_FILE_CRASHED                = "file handle invalidated by a crash"
+	MSG_INJECTED_FAULT              = "injected fault"
+	MSG_STORE_LOCKED                = "store locked by another process (%s)"
+	MSG_STORE_READ_ONLY             = "store opened read only"
+	MSG_VERSION_CONFLICT            = "version conflict, the record changed"
+	MSG_INVALID_TTL                 = "ttl must be greater than zero"
+	MSG_CORRUPTED_BLOB              = "corrupted blob"
+	MSG_INVALID_CODEC               = "invalid codec (%s)"
+	MSG_INVALID_DESTINATION         = "destination must be a non nil pointer"
+	MSG_UNSUPPORTED_TYPE            = "unsupported type (%s)"
+	MSG_INVALID_KEY                 = "invalid encryption key (%s)"
+	MSG_KEY_NOT_FOUND               = "encryption key not found (%d)"
+	MSG_DECRYPTION_FAILED           = "decryption failed, wrong key or corrupted data"
+	MSG_SNAPSHOT_NOT_SEALED         = "snapshot not encrypted in a store with keys"
+	MSG_FIELD_PROTECTED             = "the field is protected, the values of the indexes are stored in plain text (%s)"
+	MSG_PROTECTED_NOT_ENCRYPTED     = "the model has protected fields and its store has no encryption keys (%s)"
+	MSG_MODEL_STORE_OPENED          = "the store of the model is already opened (%s)"
+	MSG_SEGMENT_EMPTY               = "segment without header (%s)"
+	MSG_SUBSCRIBER_BEHIND           = "subscriber too far behind, subscribe again from the last sequence received"
+	MSG_STORE_FAILED                = "store failed to make a write durable, reopen it to recover"
 	ERROR_INTERNAL_ERROR            = MessageError{Code: 500, Message: "internal error"}
 	ERROR_CLIENT_NOT_AUTHENTICATION = MessageError{Code: 401, Message: "client not authentication"}
 )
@@ -136,6 +168,38 @@ func init() {
 		MSG_BYE = "Chao"
 		MSG_HOLA = "hola"
 		MSG_CHANNEL_NOT_FOUND = "channel no encontrado (%s)"
+		MSG_STORE_CLOSED = "store cerrado"
+		MSG_UNKNOWN_COMPRESSION = "compresión desconocida"
+		MSG_INVALID_SEGMENT_VERSION = "versión de segmento inválida"
+		MSG_INCOMPLETE_RECORD = "registro incompleto"
+		MSG_INCOMPLETE_BATCH = "batch incompleto"
+		MSG_SEQ_COMPACTED = "secuencia no disponible, el log fue compactado"
+		MSG_SEGMENT_CORRUPTED = "segmento corrupto, abra con RECOVER_ON_OPEN=true para reparar"
+		MSG_STORE_EXISTS = "store ya existe (%s)"
+		MSG_INVALID_BACKUP_ENTRY = "entrada de backup inválida (%s)"
+		MSG_IS_DIRECTORY = "es un directorio"
+		MSG_NOT_DIRECTORY = "no es un directorio"
+		MSG_DIRECTORY_NOT_EMPTY = "directorio no vacío"
+		MSG_FILE_CRASHED = "archivo invalidado por una caída"
+		MSG_INJECTED_FAULT = "falla inyectada"
+		MSG_STORE_LOCKED = "store bloqueado por otro proceso (%s)"
+		MSG_STORE_READ_ONLY = "store abierto en solo lectura"
+		MSG_VERSION_CONFLICT = "conflicto de versión, el registro cambió"
+		MSG_INVALID_TTL = "el ttl debe ser mayor que cero"
+		MSG_CORRUPTED_BLOB = "blob corrupto"
+		MSG_INVALID_CODEC = "codec inválido (%s)"
+		MSG_INVALID_DESTINATION = "el destino debe ser un puntero no nulo"
+		MSG_UNSUPPORTED_TYPE = "tipo no soportado (%s)"
+		MSG_INVALID_KEY = "llave de cifrado inválida (%s)"
+		MSG_KEY_NOT_FOUND = "llave de cifrado no encontrada (%d)"
+		MSG_DECRYPTION_FAILED = "descifrado fallido, llave incorrecta o datos corruptos"
+		MSG_SNAPSHOT_NOT_SEALED = "snapshot sin cifrar en un store con llaves"
+		MSG_FIELD_PROTECTED = "el campo está protegido, los valores de los índices se guardan en texto plano (%s)"
+		MSG_PROTECTED_NOT_ENCRYPTED = "el modelo tiene campos protegidos y su store no tiene llaves de cifrado (%s)"
+		MSG_MODEL_STORE_OPENED = "el store del modelo ya está abierto (%s)"
+		MSG_SEGMENT_EMPTY = "segmento sin cabecera (%s)"
+		MSG_SUBSCRIBER_BEHIND = "suscriptor demasiado atrasado, suscríbase de nuevo desde la última secuencia recibida"
+		MSG_STORE_FAILED = "el store no pudo hacer durable una escritura, ábralo de nuevo para recuperarlo"
 		ERROR_INTERNAL_ERROR = MessageError{Code: 500, Message: "internal error"}
 		ERROR_CLIENT_NOT_AUTHENTICATION = MessageError{Code: 401, Message: "cliente no autenticado"}
 	}